@@ -9,8 +9,10 @@ import (
 	"github.com/micromdm/nanomdm/storage"
 	"github.com/micromdm/nanomdm/storage/allmulti"
 	"github.com/micromdm/nanomdm/storage/file"
+	"github.com/micromdm/nanomdm/storage/inmem"
 	"github.com/micromdm/nanomdm/storage/mysql"
 	"github.com/micromdm/nanomdm/storage/pgsql"
+	"github.com/micromdm/nanomdm/storage/sqlite"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
@@ -39,6 +41,11 @@ func NewStorage() *Storage {
 }
 
 func (s *Storage) Parse(logger log.Logger) (storage.AllStorage, error) {
+	// the inmem backend has no DSN so allow omitting the DSN flags
+	// when it is the only backend.
+	if len(s.Storage) == 1 && s.Storage[0] == "inmem" && len(s.DSN) < 1 {
+		s.DSN = append(s.DSN, "")
+	}
 	if len(s.Storage) != len(s.DSN) {
 		return nil, errors.New("must have same number of storage and DSN flags")
 	}
@@ -80,6 +87,18 @@ func (s *Storage) Parse(logger log.Logger) (storage.AllStorage, error) {
 				return nil, err
 			}
 			mdmStorage = append(mdmStorage, pgsqlStorage)
+		case "sqlite":
+			sqliteStorage, err := sqliteStorageConfig(dsn, options, logger)
+			if err != nil {
+				return nil, err
+			}
+			mdmStorage = append(mdmStorage, sqliteStorage)
+		case "inmem":
+			inmemStorage, err := inmemStorageConfig(dsn, options, logger)
+			if err != nil {
+				return nil, err
+			}
+			mdmStorage = append(mdmStorage, inmemStorage)
 		default:
 			return nil, fmt.Errorf("unknown storage: %s", storage)
 		}
@@ -166,3 +185,53 @@ func pgsqlStorageConfig(dsn, options string, logger log.Logger) (*pgsql.PgSQLSto
 	}
 	return pgsql.New(opts...)
 }
+
+func sqliteStorageConfig(dsn, options string, logger log.Logger) (*sqlite.SQLiteStorage, error) {
+	logger = logger.With("storage", "sqlite")
+	opts := []sqlite.Option{
+		sqlite.WithDSN(dsn),
+		sqlite.WithLogger(logger),
+	}
+	if options != "" {
+		for k, v := range splitOptions(options) {
+			switch k {
+			case "delete":
+				if v == "1" {
+					opts = append(opts, sqlite.WithDeleteCommands())
+					logger.Debug("msg", "deleting commands")
+				} else if v != "0" {
+					return nil, fmt.Errorf("invalid value for delete option: %q", v)
+				}
+			default:
+				return nil, fmt.Errorf("invalid option: %q", k)
+			}
+		}
+	}
+	return sqlite.New(opts...)
+}
+
+func inmemStorageConfig(dsn, options string, logger log.Logger) (*inmem.InMem, error) {
+	if dsn != "" {
+		return nil, errors.New("inmem storage backend does not use a DSN, please specify no (or empty) DSN")
+	}
+	logger = logger.With("storage", "inmem")
+	opts := []inmem.Option{
+		inmem.WithLogger(logger),
+	}
+	if options != "" {
+		for k, v := range splitOptions(options) {
+			switch k {
+			case "delete":
+				if v == "1" {
+					opts = append(opts, inmem.WithDeleteCommands())
+					logger.Debug("msg", "deleting commands")
+				} else if v != "0" {
+					return nil, fmt.Errorf("invalid value for delete option: %q", v)
+				}
+			default:
+				return nil, fmt.Errorf("invalid option: %q", k)
+			}
+		}
+	}
+	return inmem.New(opts...), nil
+}
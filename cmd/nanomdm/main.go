@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/x509"
 	"flag"
 	"fmt"
@@ -18,14 +19,21 @@ import (
 	httpapi "github.com/micromdm/nanomdm/http/api"
 	"github.com/micromdm/nanomdm/http/authproxy"
 	httpmdm "github.com/micromdm/nanomdm/http/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/push/certmonitor"
 	"github.com/micromdm/nanomdm/push/nanopush"
+	"github.com/micromdm/nanomdm/push/retry"
+	"github.com/micromdm/nanomdm/push/scheduler"
 	pushsvc "github.com/micromdm/nanomdm/push/service"
+	"github.com/micromdm/nanomdm/push/wakeup"
 	"github.com/micromdm/nanomdm/service"
 	"github.com/micromdm/nanomdm/service/certauth"
 	"github.com/micromdm/nanomdm/service/dump"
+	"github.com/micromdm/nanomdm/service/inventory"
 	"github.com/micromdm/nanomdm/service/microwebhook"
 	"github.com/micromdm/nanomdm/service/multi"
 	"github.com/micromdm/nanomdm/service/nanomdm"
+	"github.com/micromdm/nanomdm/storage/sweeper"
 
 	"github.com/micromdm/nanolib/log/stdlogfmt"
 )
@@ -39,11 +47,20 @@ const (
 
 	endpointAuthProxy = "/authproxy/"
 
-	endpointAPIPushCert  = "/v1/pushcert"
-	endpointAPIPush      = "/v1/push/"
-	endpointAPIEnqueue   = "/v1/enqueue/"
-	endpointAPIMigration = "/migration"
-	endpointAPIVersion   = "/version"
+	endpointAPIPushCert    = "/v1/pushcert"
+	endpointAPIPushCerts   = "/v1/pushcert/"
+	endpointAPIPushSelect  = "/v1/push"
+	endpointAPIPush        = "/v1/push/"
+	endpointAPIEnqueue     = "/v1/enqueue/"
+	endpointAPIEnrollments = "/v1/enrollments"
+	endpointAPIQueue       = "/v1/queue/"
+	endpointAPICommand     = "/v1/commands"
+	endpointAPICommands    = "/v1/commands/"
+	endpointAPIDeadLetters = "/v1/deadletters"
+	endpointAPIInventory   = "/v1/inventory/"
+	endpointAPIPushHistory = "/v1/pushhistory/"
+	endpointAPIMigration   = "/migration"
+	endpointAPIVersion     = "/version"
 )
 
 const (
@@ -74,6 +91,26 @@ func main() {
 		flDMURLPfx   = flag.String("dm", "", "URL to send Declarative Management requests to")
 		flAuthProxy  = flag.String("auth-proxy-url", "", "Reverse proxy URL target for MDM-authenticated HTTP requests")
 		flUAZLChal   = flag.Bool("ua-zl-dc", false, "reply with zero-length DigestChallenge for UserAuthenticate")
+		flSchedule   = flag.Duration("schedule-interval", 0, "interval to push enrollments with newly deliverable scheduled commands (0 to disable)")
+		flSweep      = flag.Duration("sweep-interval", 0, "interval to expire queued commands (0 to disable)")
+		flNotNowMax  = flag.Int("notnow-max-count", 0, "dead-letter commands after this many NotNow replies (0 for no limit)")
+		flNotNowAge  = flag.Duration("notnow-max-age", 0, "dead-letter commands this long after their first NotNow reply (0 for no limit)")
+		flCoalesce   = flag.Duration("push-coalesce", 0, "suppress duplicate pushes to an enrollment within this window (0 to disable)")
+		flInventory  = flag.Bool("inventory", false, "store device inventory from DeviceInformation and SecurityInfo command results")
+		flRetry      = flag.Duration("push-retry-interval", 0, "interval to retry pushes that failed with transient errors (0 to disable)")
+		flRetryMax   = flag.Int("push-retry-max", retry.DefaultMaxAttempts, "maximum push attempts for pushes that failed with transient errors")
+		flCertExpiry = flag.Int("push-cert-expiry-days", 0, "report push certificates expiring within this many days (0 to disable)")
+		flHistory    = flag.Int("push-history", 0, "number of push results to keep per enrollment (0 to disable)")
+		flBadToken   = flag.String("push-bad-token", "", "action for push tokens APNs reports as no longer valid: \"record\", \"skip\", or \"disable\"")
+		flPushWork   = flag.Int("push-workers", 5, "maximum concurrent APNs pushes per push topic")
+		flPushExp    = flag.Duration("push-expiration", 0, "APNs push expiration (0 for none)")
+		flPushEnv    = flag.String("push-env", "production", "APNs environment: \"production\" or \"development\"")
+		flPushTopics = flag.String("push-topic-env", "", "comma-separated list of topic=environment APNs environment overrides")
+		flPush2197   = flag.Bool("push-2197", false, "use APNs port 2197 instead of 443")
+		flWakeup     = flag.Duration("wakeup-interval", 0, "interval to push inactive enrollments and enrollments with pending commands (0 to disable)")
+		flWakeupIdle = flag.Duration("wakeup-inactive", 24*time.Hour, "push enabled enrollments not seen for this long (0 to disable)")
+		flWakeupPend = flag.Duration("wakeup-pending", 30*time.Minute, "push enabled enrollments with commands pending for this long (0 to disable)")
+		flWakeupMax  = flag.Int("wakeup-max", wakeup.DefaultMaxPushes, "maximum enrollments to push at each wake-up interval")
 	)
 	flag.Parse()
 
@@ -132,15 +169,51 @@ func main() {
 		}
 		nanoOpts = append(nanoOpts, nanomdm.WithDeclarativeManagement(dm))
 	}
+	if *flNotNowMax > 0 || *flNotNowAge > 0 {
+		nanoOpts = append(nanoOpts, nanomdm.WithNotNowPolicy(mdmStorage, nanomdm.NotNowPolicy{
+			MaxCount: *flNotNowMax,
+			MaxAge:   *flNotNowAge,
+		}))
+	}
 	nano := nanomdm.New(mdmStorage, nanoOpts...)
 
+	if *flSweep > 0 {
+		// periodically expire queued commands
+		sweeperOpts := []sweeper.Option{
+			sweeper.WithLogger(logger.With("service", "sweeper")),
+			sweeper.WithInterval(*flSweep),
+		}
+		if *flWebhook != "" {
+			sweeperOpts = append(sweeperOpts, sweeper.WithNotifier(microwebhook.New(*flWebhook, mdmStorage)))
+		}
+		go sweeper.New(mdmStorage, sweeperOpts...).Run(context.Background())
+	}
+
+	if *flCertExpiry > 0 {
+		// periodically report expiring push certificates
+		monitorOpts := []certmonitor.Option{
+			certmonitor.WithLogger(logger.With("service", "cert-monitor")),
+			certmonitor.WithWindow(time.Duration(*flCertExpiry) * 24 * time.Hour),
+		}
+		if *flWebhook != "" {
+			monitorOpts = append(monitorOpts, certmonitor.WithNotifier(microwebhook.New(*flWebhook, mdmStorage)))
+		}
+		go certmonitor.New(mdmStorage, monitorOpts...).Run(context.Background())
+	}
+
 	mux := http.NewServeMux()
 
 	if !*flDisableMDM {
 		var mdmService service.CheckinAndCommandService = nano
+		svcs := []service.CheckinAndCommandService{mdmService}
 		if *flWebhook != "" {
-			webhookService := microwebhook.New(*flWebhook, mdmStorage)
-			mdmService = multi.New(logger.With("service", "multi"), mdmService, webhookService)
+			svcs = append(svcs, microwebhook.New(*flWebhook, mdmStorage))
+		}
+		if *flInventory {
+			svcs = append(svcs, inventory.New(mdmStorage, inventory.WithLogger(logger.With("service", "inventory"))))
+		}
+		if len(svcs) > 1 {
+			mdmService = multi.New(logger.With("service", "multi"), svcs...)
 		}
 		certAuthOpts := []certauth.Option{certauth.WithLogger(logger.With("service", "certauth"))}
 		if *flRetro {
@@ -208,31 +281,188 @@ func main() {
 		const apiUsername = "nanomdm"
 
 		// create our push provider and push service
-		pushProviderFactory := nanopush.NewFactory()
-		pushService := pushsvc.New(mdmStorage, mdmStorage, pushProviderFactory, logger.With("service", "push"))
+		pushProviderFactory, err := newPushProviderFactory(*flPushEnv, *flPushTopics, *flPush2197, *flPushWork, *flPushExp)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		pushOpts := []pushsvc.Option{pushsvc.WithCoalesceWindow(*flCoalesce)}
+		if *flHistory > 0 {
+			pushOpts = append(pushOpts, pushsvc.WithPushHistory(mdmStorage, *flHistory))
+		}
+		switch *flBadToken {
+		case "disable":
+			pushOpts = append(pushOpts, pushsvc.WithBadTokenDisabler(mdmStorage))
+			fallthrough
+		case "skip":
+			pushOpts = append(pushOpts, pushsvc.WithSkipBadTokens())
+			fallthrough
+		case "record":
+			pushOpts = append(pushOpts, pushsvc.WithBadTokenStore(mdmStorage))
+			if *flWebhook != "" {
+				pushOpts = append(pushOpts, pushsvc.WithNotifier(microwebhook.New(*flWebhook, mdmStorage)))
+			}
+		case "":
+		default:
+			stdlog.Fatalf("invalid -push-bad-token action: %q", *flBadToken)
+		}
+		pushService := pushsvc.New(
+			mdmStorage,
+			mdmStorage,
+			pushProviderFactory,
+			logger.With("service", "push"),
+			pushOpts...,
+		)
+
+		var pusher push.Pusher = pushService
+		if *flRetry > 0 {
+			// queue pushes that failed with transient errors and
+			// periodically retry them.
+			retrier := retry.New(
+				pushService,
+				mdmStorage,
+				retry.WithLogger(logger.With("service", "push-retry")),
+				retry.WithInterval(*flRetry),
+				retry.WithMaxAttempts(*flRetryMax),
+			)
+			go retrier.Run(context.Background())
+			pusher = retrier
+		}
 
-		// register API handler for push cert storage/upload.
+		if *flSchedule > 0 {
+			// periodically push to enrollments whose scheduled
+			// commands have become deliverable.
+			sched := scheduler.New(
+				mdmStorage,
+				pusher,
+				scheduler.WithLogger(logger.With("service", "scheduler")),
+				scheduler.WithInterval(*flSchedule),
+			)
+			go sched.Run(context.Background())
+		}
+
+		if *flWakeup > 0 {
+			// periodically push to enrollments that appear to have
+			// missed earlier pushes.
+			waker := wakeup.New(
+				mdmStorage,
+				pusher,
+				wakeup.WithLogger(logger.With("service", "wakeup")),
+				wakeup.WithInterval(*flWakeup),
+				wakeup.WithInactivity(*flWakeupIdle),
+				wakeup.WithPendingAge(*flWakeupPend),
+				wakeup.WithMaxPushes(*flWakeupMax),
+			)
+			go waker.Run(context.Background())
+		}
+
+		// register API handler for push cert storage/upload and listing.
 		var pushCertHandler http.Handler
 		pushCertHandler = httpapi.StorePushCertHandler(mdmStorage, logger.With("handler", "store-cert"))
+		pushCertHandler = mdmhttp.MethodHandler(
+			pushCertHandler,
+			http.MethodGet,
+			httpapi.ListPushCertsHandler(mdmStorage, logger.With("handler", "list-certs")),
+		)
 		pushCertHandler = mdmhttp.BasicAuthMiddleware(pushCertHandler, apiUsername, *flAPIKey, "nanomdm")
 		mux.Handle(endpointAPIPushCert, pushCertHandler)
 
+		// register API handler for push cert deletion.
+		// we strip the prefix to use the path as a topic.
+		var deleteCertHandler http.Handler
+		deleteCertHandler = mdmhttp.MethodHandler(
+			http.NotFoundHandler(),
+			http.MethodDelete,
+			httpapi.DeletePushCertHandler(mdmStorage, logger.With("handler", "delete-cert")),
+		)
+		deleteCertHandler = http.StripPrefix(endpointAPIPushCerts, deleteCertHandler)
+		deleteCertHandler = mdmhttp.BasicAuthMiddleware(deleteCertHandler, apiUsername, *flAPIKey, "nanomdm")
+		mux.Handle(endpointAPIPushCerts, deleteCertHandler)
+
 		// register API handler for push notifications.
 		// we strip the prefix to use the path as an id.
 		var pushHandler http.Handler
-		pushHandler = httpapi.PushHandler(pushService, logger.With("handler", "push"))
+		pushHandler = httpapi.PushHandler(pusher, logger.With("handler", "push"))
 		pushHandler = http.StripPrefix(endpointAPIPush, pushHandler)
 		pushHandler = mdmhttp.BasicAuthMiddleware(pushHandler, apiUsername, *flAPIKey, "nanomdm")
 		mux.Handle(endpointAPIPush, pushHandler)
 
-		// register API handler for new command queueing.
+		// register API handler for push notifications by selector.
+		var pushSelectHandler http.Handler
+		pushSelectHandler = mdmhttp.MethodHandler(
+			http.NotFoundHandler(),
+			http.MethodPost,
+			httpapi.PushSelectHandler(mdmStorage, pusher, logger.With("handler", "push-select")),
+		)
+		pushSelectHandler = mdmhttp.BasicAuthMiddleware(pushSelectHandler, apiUsername, *flAPIKey, "nanomdm")
+		mux.Handle(endpointAPIPushSelect, pushSelectHandler)
+
+		// register API handler for new command queueing and dequeueing.
 		// we strip the prefix to use the path as an id.
 		var enqueueHandler http.Handler
-		enqueueHandler = httpapi.RawCommandEnqueueHandler(mdmStorage, pushService, logger.With("handler", "enqueue"))
+		enqueueHandler = httpapi.RawCommandEnqueueHandler(mdmStorage, pusher, logger.With("handler", "enqueue"), httpapi.WithEnrollmentLister(mdmStorage))
+		enqueueHandler = mdmhttp.MethodHandler(
+			enqueueHandler,
+			http.MethodDelete,
+			httpapi.CommandDequeueHandler(mdmStorage, logger.With("handler", "dequeue")),
+		)
 		enqueueHandler = http.StripPrefix(endpointAPIEnqueue, enqueueHandler)
 		enqueueHandler = mdmhttp.BasicAuthMiddleware(enqueueHandler, apiUsername, *flAPIKey, "nanomdm")
 		mux.Handle(endpointAPIEnqueue, enqueueHandler)
 
+		// register API handler for listing enrollments.
+		var enrollmentsHandler http.Handler
+		enrollmentsHandler = httpapi.ListEnrollmentsHandler(mdmStorage, logger.With("handler", "enrollments"))
+		enrollmentsHandler = mdmhttp.BasicAuthMiddleware(enrollmentsHandler, apiUsername, *flAPIKey, "nanomdm")
+		mux.Handle(endpointAPIEnrollments, enrollmentsHandler)
+
+		// register API handler for inspecting an enrollment's queue.
+		// we strip the prefix to use the path as an id.
+		var queueHandler http.Handler
+		queueHandler = httpapi.RetrieveQueueHandler(mdmStorage, logger.With("handler", "queue"))
+		queueHandler = http.StripPrefix(endpointAPIQueue, queueHandler)
+		queueHandler = mdmhttp.BasicAuthMiddleware(queueHandler, apiUsername, *flAPIKey, "nanomdm")
+		mux.Handle(endpointAPIQueue, queueHandler)
+
+		// register API handler for enqueueing JSON commands.
+		var cmdHandler http.Handler
+		cmdHandler = mdmhttp.MethodHandler(
+			http.NotFoundHandler(),
+			http.MethodPost,
+			httpapi.CommandEnqueueHandler(mdmStorage, pusher, logger.With("handler", "command"), httpapi.WithEnrollmentLister(mdmStorage)),
+		)
+		cmdHandler = mdmhttp.BasicAuthMiddleware(cmdHandler, apiUsername, *flAPIKey, "nanomdm")
+		mux.Handle(endpointAPICommand, cmdHandler)
+
+		// register API handler for command results.
+		// we strip the prefix to use the path as a command UUID.
+		var cmdResultsHandler http.Handler
+		cmdResultsHandler = httpapi.CommandResultsHandler(mdmStorage, logger.With("handler", "command-results"))
+		cmdResultsHandler = http.StripPrefix(endpointAPICommands, cmdResultsHandler)
+		cmdResultsHandler = mdmhttp.BasicAuthMiddleware(cmdResultsHandler, apiUsername, *flAPIKey, "nanomdm")
+		mux.Handle(endpointAPICommands, cmdResultsHandler)
+
+		// register API handler for dead-lettered commands.
+		var deadLettersHandler http.Handler
+		deadLettersHandler = httpapi.DeadLettersHandler(mdmStorage, logger.With("handler", "dead-letters"))
+		deadLettersHandler = mdmhttp.BasicAuthMiddleware(deadLettersHandler, apiUsername, *flAPIKey, "nanomdm")
+		mux.Handle(endpointAPIDeadLetters, deadLettersHandler)
+
+		// register API handler for enrollment inventory.
+		// we strip the prefix to use the path as an id.
+		var inventoryHandler http.Handler
+		inventoryHandler = httpapi.RetrieveInventoryHandler(mdmStorage, logger.With("handler", "inventory"))
+		inventoryHandler = http.StripPrefix(endpointAPIInventory, inventoryHandler)
+		inventoryHandler = mdmhttp.BasicAuthMiddleware(inventoryHandler, apiUsername, *flAPIKey, "nanomdm")
+		mux.Handle(endpointAPIInventory, inventoryHandler)
+
+		// register API handler for enrollment push history.
+		// we strip the prefix to use the path as an id.
+		var pushHistoryHandler http.Handler
+		pushHistoryHandler = httpapi.RetrievePushHistoryHandler(mdmStorage, logger.With("handler", "push-history"))
+		pushHistoryHandler = http.StripPrefix(endpointAPIPushHistory, pushHistoryHandler)
+		pushHistoryHandler = mdmhttp.BasicAuthMiddleware(pushHistoryHandler, apiUsername, *flAPIKey, "nanomdm")
+		mux.Handle(endpointAPIPushHistory, pushHistoryHandler)
+
 		if *flMigration {
 			// setup a "migration" handler that takes Check-In messages
 			// without bothering with certificate auth or other
@@ -271,3 +501,36 @@ func newTraceID(_ *http.Request) string {
 	rand.Read(b)
 	return fmt.Sprintf("%x", b)
 }
+
+// newPushProviderFactory creates the APNs push provider factory.
+// topicEnvs is a comma-separated list of topic=environment pairs that
+// override the env APNs environment for those push topics.
+func newPushProviderFactory(env, topicEnvs string, port2197 bool, workers int, expiration time.Duration) (*nanopush.Factory, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("invalid push workers: %d", workers)
+	}
+	baseURL, err := nanopush.BaseURL(env, port2197)
+	if err != nil {
+		return nil, err
+	}
+	opts := []nanopush.Option{
+		nanopush.WithBaseURL(baseURL),
+		nanopush.WithWorkers(workers),
+		nanopush.WithExpiration(expiration),
+	}
+	for _, topicEnv := range strings.Split(topicEnvs, ",") {
+		if topicEnv = strings.TrimSpace(topicEnv); topicEnv == "" {
+			continue
+		}
+		split := strings.SplitN(topicEnv, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("invalid push topic environment: %q", topicEnv)
+		}
+		baseURL, err := nanopush.BaseURL(split[1], port2197)
+		if err != nil {
+			return nil, fmt.Errorf("topic %s: %w", split[0], err)
+		}
+		opts = append(opts, nanopush.WithTopicBaseURL(split[0], baseURL))
+	}
+	return nanopush.NewFactory(opts...), nil
+}
@@ -5,11 +5,12 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,6 +29,9 @@ type enrolledAPIResult struct {
 	PushError    string `json:"push_error,omitempty"`
 	PushResult   string `json:"push_result,omitempty"`
 	CommandError string `json:"command_error,omitempty"`
+	// PushCoalesced is true if the push was suppressed because the
+	// enrollment was recently pushed to.
+	PushCoalesced bool `json:"push_coalesced,omitempty"`
 }
 
 // enrolledAPIResults is a map of enrollments to a per-enrollment API result.
@@ -73,6 +77,84 @@ func setupCtxLog(ctx context.Context, ids []string, logger log.Logger) (context.
 	return ctx, ctxlog.Logger(ctx, logger)
 }
 
+// apiTally counts the outcomes of enqueuing commands and sending
+// pushes, possibly over multiple batches of enrollment IDs.
+type apiTally struct {
+	ct, errCt         int
+	err               error
+	pushCt, pushErrCt int
+	pushErr           error
+}
+
+// pushHeader returns the HTTP status for push results depending on if
+// everything succeeded, failed, or partially succeeded.
+func (t *apiTally) pushHeader() int {
+	if (t.pushErrCt > 0 || t.pushErr != nil) && t.pushCt > 0 {
+		return http.StatusMultiStatus
+	} else if (t.pushErrCt == 0 && t.pushErr == nil) && t.pushCt >= 1 {
+		return http.StatusOK
+	}
+	return http.StatusInternalServerError
+}
+
+// enqueueHeader returns the HTTP status for enqueue (and push) results
+// depending on if everything succeeded, failed, or partially succeeded.
+func (t *apiTally) enqueueHeader(nopush bool) int {
+	if (t.errCt > 0 || t.err != nil || (!nopush && (t.pushErrCt > 0 || t.pushErr != nil))) && (t.ct > 0 || (!nopush && (t.pushCt > 0))) {
+		return http.StatusMultiStatus
+	} else if (t.errCt == 0 && t.err == nil && (nopush || (t.pushErrCt == 0 && t.pushErr == nil))) && (t.ct >= 1 && (nopush || (t.pushCt >= 1))) {
+		return http.StatusOK
+	}
+	return http.StatusInternalServerError
+}
+
+// pushIDs sends push notifications to ids and adds the results to
+// output and tally.
+func pushIDs(ctx context.Context, output *apiResult, tally *apiTally, pusher push.Pusher, ids []string, logger log.Logger) {
+	pushResp, err := pusher.Push(ctx, ids)
+	if err != nil {
+		if tally.pushErr == nil {
+			tally.pushErr = err
+			output.PushError = err.Error()
+		}
+	}
+	var ct, errCt int
+	for id, resp := range pushResp {
+		if _, ok := output.Status[id]; ok {
+			output.Status[id].PushResult = resp.Id
+			output.Status[id].PushCoalesced = resp.Coalesced
+		} else {
+			output.Status[id] = &enrolledAPIResult{
+				PushResult:    resp.Id,
+				PushCoalesced: resp.Coalesced,
+			}
+		}
+		if resp.Err != nil {
+			output.Status[id].PushError = resp.Err.Error()
+			errCt++
+		} else {
+			ct++
+		}
+	}
+	tally.pushCt += ct
+	tally.pushErrCt += errCt
+	logs := []interface{}{
+		"msg", "push",
+		"count", ct,
+	}
+	if err != nil {
+		logs = append(logs, "err", err)
+	}
+	if errCt > 0 {
+		logs = append(logs, "errs", errCt)
+	}
+	if err != nil || errCt > 0 {
+		logger.Info(logs...)
+	} else {
+		logger.Debug(logs...)
+	}
+}
+
 // PushHandler sends APNs push notifications to MDM enrollments.
 //
 // Note the whole URL path is used as the identifier to push to. This
@@ -86,64 +168,48 @@ func PushHandler(pusher push.Pusher, logger log.Logger) http.HandlerFunc {
 		output := apiResult{
 			Status: make(enrolledAPIResults),
 		}
-		logs := []interface{}{"msg", "push"}
-		pushResp, err := pusher.Push(ctx, ids)
-		if err != nil {
-			logs = append(logs, "err", err)
-			output.PushError = err.Error()
-		}
-		var ct, errCt int
-		for id, resp := range pushResp {
-			output.Status[id] = &enrolledAPIResult{
-				PushResult: resp.Id,
-			}
-			if resp.Err != nil {
-				output.Status[id].PushError = resp.Err.Error()
-				errCt += 1
-			} else {
-				ct += 1
-			}
-		}
-		logs = append(logs, "count", ct)
-		if errCt > 0 {
-			logs = append(logs, "errs", errCt)
-		}
-		if err != nil || errCt > 0 {
-			logger.Info(logs...)
-		} else {
-			logger.Debug(logs...)
-		}
-		// generate response codes depending on if everything succeeded, failed, or parially succedded
-		header := http.StatusInternalServerError
-		if (errCt > 0 || err != nil) && ct > 0 {
-			header = http.StatusMultiStatus
-		} else if (errCt == 0 && err == nil) && ct >= 1 {
-			header = http.StatusOK
-		}
-		json, err := json.MarshalIndent(output, "", "\t")
-		if err != nil {
-			logger.Info("msg", "marshal json", "err", err)
-		}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(header)
-		_, err = w.Write(json)
-		if err != nil {
-			logger.Info("msg", "writing body", "err", err)
-		}
+		tally := new(apiTally)
+		pushIDs(ctx, &output, tally, pusher, ids, logger)
+		writeJSON(w, tally.pushHeader(), output, logger)
 	}
 }
 
 // RawCommandEnqueueHandler enqueues a raw MDM command plist and sends
-// push notifications to MDM enrollments.
+// push notifications to MDM enrollments. The optional "priority" query
+// parameter sets the priority of the queued command and the optional
+// RFC 3339 "not_before" query parameter schedules the command for later
+// delivery. Push notifications are not sent for scheduled commands. The
+// optional RFC 3339 "expires" query parameter sets the time after which
+// the command will no longer be delivered.
 //
 // Note the whole URL path is used as the identifier to enqueue (and
 // push to. This probably necessitates stripping the URL prefix before
 // using. Also note we expose Go errors to the output as this is meant
 // for "API" users.
-func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Pusher, logger log.Logger) http.HandlerFunc {
+//
+// With the WithEnrollmentLister option and an empty URL path the
+// enrollments are instead chosen by selector query parameters: the
+// filter parameters of ListEnrollmentsHandler or "all" to select all
+// enrollments. The selected enrollments are enqueued in batches.
+func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Pusher, logger log.Logger, opts ...EnqueueHandlerOption) http.HandlerFunc {
+	h := newEnqueueHandler(opts)
 	return func(w http.ResponseWriter, r *http.Request) {
-		ids := strings.Split(r.URL.Path, ",")
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
 		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		filter, err := selectorFromQuery(r.URL.Query())
+		if err == nil && filter != nil && len(ids) > 0 {
+			err = errIDsAndSelector
+		} else if err == nil && filter == nil && len(ids) < 1 {
+			err = errEmptySelector
+		}
+		if err != nil {
+			logger.Info("msg", "selector", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err, logger)
+			return
+		}
 		b, err := mdmhttp.ReadAllAndReplaceBody(r)
 		if err != nil {
 			logger.Info("msg", "reading body", "err", err)
@@ -156,110 +222,121 @@ func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Push
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 			return
 		}
-		nopush := r.URL.Query().Get("nopush") != ""
-		output := apiResult{
-			Status:      make(enrolledAPIResults),
-			NoPush:      nopush,
-			CommandUUID: command.CommandUUID,
-			RequestType: command.Command.RequestType,
-		}
-		logger = logger.With(
-			"command_uuid", command.CommandUUID,
-			"request_type", command.Command.RequestType,
-		)
-		logs := []interface{}{
-			"msg", "enqueue",
-		}
-		idErrs, err := enqueuer.EnqueueCommand(ctx, ids, command)
-		ct := len(ids) - len(idErrs)
+		opts, nopush, err := enqueueOptions(r.URL.Query())
 		if err != nil {
-			logs = append(logs, "err", err)
-			output.CommandError = err.Error()
-			if len(idErrs) == 0 {
-				// we assume if there were no ID-specific errors but
-				// there was a general error then all IDs failed
-				ct = 0
-			}
-		}
-		logs = append(logs, "count", ct)
-		if len(idErrs) > 0 {
-			logs = append(logs, "errs", len(idErrs))
-		}
-		if err != nil || len(idErrs) > 0 {
-			logger.Info(logs...)
-		} else {
-			logger.Debug(logs...)
-		}
-		// loop through our command errors, if any, and add to output
-		for id, err := range idErrs {
-			if err != nil {
-				output.Status[id] = &enrolledAPIResult{
-					CommandError: err.Error(),
-				}
-			}
-		}
-		// optionally send pushes
-		pushResp := make(map[string]*push.Response)
-		var pushErr error
-		if !nopush {
-			pushResp, pushErr = pusher.Push(ctx, ids)
-			if err != nil {
-				logger.Info("msg", "push", "err", err)
-				output.PushError = err.Error()
-			}
-		} else {
-			pushErr = nil
-		}
-		// loop through our push errors, if any, and add to output
-		var pushCt, pushErrCt int
-		for id, resp := range pushResp {
-			if _, ok := output.Status[id]; ok {
-				output.Status[id].PushResult = resp.Id
-			} else {
-				output.Status[id] = &enrolledAPIResult{
-					PushResult: resp.Id,
-				}
-			}
-			if resp.Err != nil {
-				output.Status[id].PushError = resp.Err.Error()
-				pushErrCt++
-			} else {
-				pushCt++
-			}
-		}
-		logs = []interface{}{
-			"msg", "push",
-			"count", pushCt,
+			logger.Info("msg", "parsing enqueue options", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
 		}
-		if pushErr != nil {
-			logs = append(logs, "err", pushErr)
+		if filter != nil {
+			h.enqueueSelected(ctx, w, enqueuer, pusher, filter, command, nopush, opts, logger)
+			return
 		}
-		if pushErrCt > 0 {
-			logs = append(logs, "errs", pushErrCt)
+		enqueueAndPush(ctx, w, enqueuer, pusher, ids, command, nopush, opts, logger)
+	}
+}
+
+// enqueueOptions parses the "priority", "not_before", "expires", and
+// "nopush" enqueue query parameters.
+func enqueueOptions(q url.Values) (opts []storage.EnqueueOption, nopush bool, err error) {
+	if v := q.Get("priority"); v != "" {
+		// priorities are limited to the smallest range supported
+		// by our storage backends.
+		priority, err := strconv.ParseInt(v, 10, 8)
+		if err != nil {
+			return nil, false, fmt.Errorf("parsing priority: %w", err)
 		}
-		if pushErr != nil || pushErrCt > 0 {
-			logger.Info(logs...)
-		} else {
-			logger.Debug(logs...)
+		opts = append(opts, storage.WithPriority(int(priority)))
+	}
+	nopush = q.Get("nopush") != ""
+	notBefore, err := queryTime(q, "not_before")
+	if err != nil {
+		return nil, false, err
+	}
+	if !notBefore.IsZero() {
+		opts = append(opts, storage.WithNotBefore(notBefore))
+		// the enrollments can't retrieve the command until
+		// not_before so there's no point in pushing now.
+		if notBefore.After(time.Now()) {
+			nopush = true
 		}
-		// generate response codes depending on if everything succeeded, failed, or parially succedded
-		header := http.StatusInternalServerError
-		if (len(idErrs) > 0 || err != nil || (!nopush && (pushErrCt > 0 || pushErr != nil))) && (ct > 0 || (!nopush && (pushCt > 0))) {
-			header = http.StatusMultiStatus
-		} else if (len(idErrs) == 0 && err == nil && (nopush || (pushErrCt == 0 && pushErr == nil))) && (ct >= 1 && (nopush || (pushCt >= 1))) {
-			header = http.StatusOK
+	}
+	expires, err := queryTime(q, "expires")
+	if err != nil {
+		return nil, false, err
+	}
+	if !expires.IsZero() {
+		opts = append(opts, storage.WithExpires(expires))
+	}
+	return opts, nopush, nil
+}
+
+// enqueueIDs enqueues command for ids, sends push notifications to ids
+// unless nopush is set, and adds the results to output and tally.
+func enqueueIDs(ctx context.Context, output *apiResult, tally *apiTally, enqueuer storage.CommandEnqueuer, pusher push.Pusher, ids []string, command *mdm.Command, nopush bool, opts []storage.EnqueueOption, logger log.Logger) {
+	logs := []interface{}{
+		"msg", "enqueue",
+	}
+	idErrs, err := enqueuer.EnqueueCommand(ctx, ids, command, opts...)
+	ct := len(ids) - len(idErrs)
+	if err != nil {
+		logs = append(logs, "err", err)
+		if tally.err == nil {
+			tally.err = err
+			output.CommandError = err.Error()
 		}
-		json, err := json.MarshalIndent(output, "", "\t")
-		if err != nil {
-			logger.Info("msg", "marshal json", "err", err)
+		if len(idErrs) == 0 {
+			// we assume if there were no ID-specific errors but
+			// there was a general error then all IDs failed
+			ct = 0
 		}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(header)
-		_, err = w.Write(json)
+	}
+	tally.ct += ct
+	tally.errCt += len(idErrs)
+	logs = append(logs, "count", ct)
+	if len(idErrs) > 0 {
+		logs = append(logs, "errs", len(idErrs))
+	}
+	if err != nil || len(idErrs) > 0 {
+		logger.Info(logs...)
+	} else {
+		logger.Debug(logs...)
+	}
+	// loop through our command errors, if any, and add to output
+	for id, err := range idErrs {
 		if err != nil {
-			logger.Info("msg", "writing body", "err", err)
+			output.Status[id] = &enrolledAPIResult{
+				CommandError: err.Error(),
+			}
 		}
 	}
+	// optionally send pushes
+	if !nopush {
+		pushIDs(ctx, output, tally, pusher, ids, logger)
+	}
+}
+
+// newEnqueueResult creates the API result for enqueuing command.
+func newEnqueueResult(command *mdm.Command, nopush bool) *apiResult {
+	return &apiResult{
+		Status:      make(enrolledAPIResults),
+		NoPush:      nopush,
+		CommandUUID: command.CommandUUID,
+		RequestType: command.Command.RequestType,
+	}
+}
+
+// enqueueAndPush enqueues command for ids, sends push notifications to
+// ids unless nopush is set, and writes the JSON API result to w.
+func enqueueAndPush(ctx context.Context, w http.ResponseWriter, enqueuer storage.CommandEnqueuer, pusher push.Pusher, ids []string, command *mdm.Command, nopush bool, opts []storage.EnqueueOption, logger log.Logger) {
+	output := newEnqueueResult(command, nopush)
+	logger = logger.With(
+		"command_uuid", command.CommandUUID,
+		"request_type", command.Command.RequestType,
+	)
+	tally := new(apiTally)
+	enqueueIDs(ctx, output, tally, enqueuer, pusher, ids, command, nopush, opts, logger)
+	writeJSON(w, tally.enqueueHeader(nopush), output, logger)
 }
 
 // readPEMCertAndKey reads a PEM-encoded certificate and non-encrypted
@@ -295,7 +372,16 @@ func readPEMCertAndKey(input []byte) (cert []byte, key []byte, err error) {
 // enables us to do something like:
 // "% cat push.pem push.key | curl -T - http://api.example.com/" to
 // upload our push certs.
-func StorePushCertHandler(storage storage.PushCertStore, logger log.Logger) http.HandlerFunc {
+//
+// As a safety check uploads of a certificate that expires before the
+// stored certificate for the same topic or for a topic that no
+// enrollments use (when there are enrollments) are refused unless the
+// "force" query parameter is given. These checks are skipped if store
+// is not also a storage.PushCertLister or storage.EnrollmentLister,
+// respectively, which is logged at info level.
+func StorePushCertHandler(store storage.PushCertStore, logger log.Logger) http.HandlerFunc {
+	certLister, _ := store.(storage.PushCertLister)
+	enrollmentLister, _ := store.(storage.EnrollmentLister)
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger := ctxlog.Logger(r.Context(), logger)
 		b, err := mdmhttp.ReadAllAndReplaceBody(r)
@@ -304,10 +390,13 @@ func StorePushCertHandler(storage storage.PushCertStore, logger log.Logger) http
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
+		header := http.StatusBadRequest
 		certPEM, keyPEM, err := readPEMCertAndKey(b)
 		if err == nil {
 			// sanity check the provided cert and key to make sure they're usable as a pair.
-			_, err = tls.X509KeyPair(certPEM, keyPEM)
+			if _, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+				err = fmt.Errorf("certificate and private key do not match: %w", err)
+			}
 		}
 		var cert *x509.Certificate
 		if err == nil {
@@ -317,34 +406,49 @@ func StorePushCertHandler(storage storage.PushCertStore, logger log.Logger) http
 		if err == nil {
 			topic, err = cryptoutil.TopicFromCert(cert)
 		}
+		var previous *storage.PushCertInfo
 		if err == nil {
-			err = storage.StorePushCert(r.Context(), certPEM, keyPEM)
+			header = http.StatusInternalServerError
+			if certLister != nil {
+				previous, err = storedPushCert(r.Context(), certLister, topic)
+			}
+		}
+		if err == nil && r.URL.Query().Get("force") == "" {
+			if certLister == nil {
+				logger.Info("msg", "push cert check skipped", "check", "expiry", "reason", "storage does not list push certs")
+			}
+			if enrollmentLister == nil {
+				logger.Info("msg", "push cert check skipped", "check", "topic", "reason", "storage does not list enrollments")
+			}
+			var refused bool
+			if refused, err = checkPushCertUpload(r.Context(), enrollmentLister, cert, topic, previous); refused {
+				header = http.StatusConflict
+			}
+		}
+		if err == nil {
+			err = store.StorePushCert(r.Context(), certPEM, keyPEM)
 		}
 		output := &struct {
-			Error    string    `json:"error,omitempty"`
-			Topic    string    `json:"topic,omitempty"`
-			NotAfter time.Time `json:"not_after,omitempty"`
+			Error            string     `json:"error,omitempty"`
+			Topic            string     `json:"topic,omitempty"`
+			NotAfter         time.Time  `json:"not_after,omitempty"`
+			PreviousNotAfter *time.Time `json:"previous_not_after,omitempty"`
 		}{
 			Topic: topic,
 		}
 		if cert != nil {
 			output.NotAfter = cert.NotAfter
 		}
+		if previous != nil {
+			output.PreviousNotAfter = &previous.NotAfter
+		}
 		if err != nil {
 			logger.Info("msg", "store push cert", "err", err)
 			output.Error = err.Error()
-			w.WriteHeader(http.StatusInternalServerError)
 		} else {
+			header = http.StatusOK
 			logger.Debug("msg", "stored push cert", "topic", topic)
 		}
-		json, err := json.MarshalIndent(output, "", "\t")
-		if err != nil {
-			logger.Info("msg", "marshal json", "err", err)
-		}
-		w.Header().Set("Content-type", "application/json")
-		_, err = w.Write(json)
-		if err != nil {
-			logger.Info("msg", "writing body", "err", err)
-		}
+		writeJSON(w, header, output, logger)
 	}
 }
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// commandResultAPIResult is a per-enrollment command result.
+type commandResultAPIResult struct {
+	ID string `json:"id"`
+	// Status is "Pending" if the enrollment has not yet responded.
+	Status      string           `json:"status"`
+	ErrorChain  []mdm.ErrorChain `json:"error_chain,omitempty"`
+	NotNowTally int              `json:"not_now_tally"`
+	Result      string           `json:"result,omitempty"`
+	ResultJSON  interface{}      `json:"result_json,omitempty"`
+	UpdatedAt   *time.Time       `json:"updated_at,omitempty"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// newCommandResultAPIResult converts a storage command result for
+// API output. The result plist is additionally converted for JSON
+// output if convert is true.
+func newCommandResultAPIResult(result *storage.CommandResult, convert bool) *commandResultAPIResult {
+	out := &commandResultAPIResult{
+		ID:          result.ID,
+		Status:      result.Status,
+		NotNowTally: result.NotNowTally,
+		UpdatedAt:   result.UpdatedAt,
+	}
+	if out.Status == "" {
+		out.Status = "Pending"
+	}
+	if len(result.Result) < 1 {
+		return out
+	}
+	out.Result = string(result.Result)
+	results, err := mdm.DecodeCommandResults(result.Result)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	out.ErrorChain = results.ErrorChain
+	if convert {
+		if err = plist.Unmarshal(result.Result, &out.ResultJSON); err != nil {
+			out.Error = err.Error()
+		}
+	}
+	return out
+}
+
+// CommandResultsHandler returns the results of a queued command for
+// each enrollment it was queued to.
+//
+// The URL path is expected to be in the form of "{uuid}/results". This
+// probably necessitates stripping the URL prefix before using. The
+// "json" query parameter additionally includes the result plists
+// converted to JSON.
+func CommandResultsHandler(retriever storage.CommandResultsRetriever, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		uuid := strings.TrimSuffix(r.URL.Path, "/results")
+		if uuid == r.URL.Path || uuid == "" || strings.Contains(uuid, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		logger = logger.With("command_uuid", uuid)
+		results, err := retriever.RetrieveCommandResults(r.Context(), uuid)
+		if err != nil {
+			logger.Info("msg", "retrieve command results", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, err, logger)
+			return
+		}
+		if len(results) < 1 {
+			writeJSONError(w, http.StatusNotFound, errors.New("command not found"), logger)
+			return
+		}
+		convert := r.URL.Query().Get("json") != ""
+		output := &struct {
+			CommandUUID string                    `json:"command_uuid"`
+			Results     []*commandResultAPIResult `json:"results"`
+		}{
+			CommandUUID: uuid,
+		}
+		for _, result := range results {
+			output.Results = append(output.Results, newCommandResultAPIResult(result, convert))
+		}
+		logger.Debug("msg", "retrieve command results", "count", len(results))
+		writeJSON(w, http.StatusOK, output, logger)
+	}
+}
+
+// jsonCommandRequest is a JSON-described MDM command to enqueue.
+type jsonCommandRequest struct {
+	// CommandUUID is generated if empty.
+	CommandUUID string `json:"command_uuid,omitempty"`
+	// Command is the MDM command dictionary.
+	Command map[string]interface{} `json:"command"`
+	IDs     []string               `json:"ids,omitempty"`
+	NoPush  bool                   `json:"nopush,omitempty"`
+	// Selector selects the enrollments to enqueue the command for
+	// instead of IDs.
+	Selector *enrollmentSelector `json:"selector,omitempty"`
+}
+
+// plistValue converts a JSON value decoded with json.Number numbers
+// into a value that marshals to the proper plist type. Integral numbers
+// become integers and other numbers become reals. As JSON has no data
+// or date types objects of the form {"$data": "<base64>"} become data
+// and objects of the form {"$date": "<RFC 3339>"} become dates.
+func plistValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if s, ok := v["$data"].(string); ok {
+				return base64.StdEncoding.DecodeString(s)
+			}
+			if s, ok := v["$date"].(string); ok {
+				return time.Parse(time.RFC3339, s)
+			}
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			var err error
+			if out[k], err = plistValue(val); err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			var err error
+			if out[i], err = plistValue(val); err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+		}
+		return out, nil
+	case nil:
+		return nil, errors.New("null values not supported")
+	}
+	return v, nil
+}
+
+// newJSONCommand converts req into an MDM command.
+func newJSONCommand(req *jsonCommandRequest) (*mdm.Command, error) {
+	if req.Command == nil {
+		return nil, errors.New("no command")
+	}
+	cmd, err := plistValue(req.Command)
+	if err != nil {
+		return nil, fmt.Errorf("converting command: %w", err)
+	}
+	return mdm.NewCommand(req.CommandUUID, cmd)
+}
+
+// CommandEnqueueHandler enqueues an MDM command described by a JSON
+// body and sends push notifications to MDM enrollments. The command
+// UUID is generated if not supplied. The enrollments are either given
+// as IDs or chosen by a selector (which requires the
+// WithEnrollmentLister option; the selected enrollments are enqueued
+// in batches). If neither are supplied the generated command is
+// returned without being enqueued. Enqueue options are taken from the
+// same query parameters as RawCommandEnqueueHandler.
+func CommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Pusher, logger log.Logger, opts ...EnqueueHandlerOption) http.HandlerFunc {
+	h := newEnqueueHandler(opts)
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := new(jsonCommandRequest)
+		dec := json.NewDecoder(r.Body)
+		dec.UseNumber()
+		if err := dec.Decode(req); err != nil {
+			logger := ctxlog.Logger(r.Context(), logger)
+			logger.Info("msg", "decoding JSON command", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err, logger)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), req.IDs, logger)
+		command, err := newJSONCommand(req)
+		if err != nil {
+			logger.Info("msg", "converting JSON command", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err, logger)
+			return
+		}
+		opts, nopush, err := enqueueOptions(r.URL.Query())
+		if err != nil {
+			logger.Info("msg", "parsing enqueue options", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err, logger)
+			return
+		}
+		if req.Selector != nil {
+			var filter *storage.EnrollmentFilter
+			if len(req.IDs) > 0 {
+				err = errIDsAndSelector
+			} else {
+				filter, err = req.Selector.filter()
+			}
+			if err != nil {
+				logger.Info("msg", "selector", "err", err)
+				writeJSONError(w, http.StatusBadRequest, err, logger)
+				return
+			}
+			h.enqueueSelected(ctx, w, enqueuer, pusher, filter, command, nopush || req.NoPush, opts, logger)
+			return
+		}
+		if len(req.IDs) < 1 {
+			output := &struct {
+				CommandUUID string `json:"command_uuid"`
+				RequestType string `json:"request_type"`
+				Command     string `json:"command"`
+			}{
+				CommandUUID: command.CommandUUID,
+				RequestType: command.Command.RequestType,
+				Command:     string(command.Raw),
+			}
+			writeJSON(w, http.StatusOK, output, logger)
+			return
+		}
+		enqueueAndPush(ctx, w, enqueuer, pusher, req.IDs, command, nopush || req.NoPush, opts, logger)
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/groob/plist"
+)
+
+func TestNewJSONCommand(t *testing.T) {
+	body := `{
+	"command": {
+		"RequestType": "InstallProfile",
+		"Payload": {"$data": "aGVsbG8="},
+		"Integer": 42,
+		"Real": 1.5,
+		"Bool": true,
+		"Date": {"$date": "2022-06-01T18:20:02Z"},
+		"Array": ["a", 1]
+	}
+}`
+	req := new(jsonCommandRequest)
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(req); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := newJSONCommand(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.CommandUUID == "" {
+		t.Error("expected generated command UUID")
+	}
+	if have, want := cmd.Command.RequestType, "InstallProfile"; have != want {
+		t.Errorf("request type: have: %q, want: %q", have, want)
+	}
+	for _, want := range []string{
+		"<data>aGVsbG8=</data>",
+		"<integer>42</integer>",
+		"<real>1.5</real>",
+		"<true/>",
+		"<date>2022-06-01T18:20:02Z</date>",
+		"<integer>1</integer>",
+	} {
+		if !bytes.Contains(cmd.Raw, []byte(want)) {
+			t.Errorf("command plist missing %s", want)
+		}
+	}
+
+	typed := &struct {
+		Command struct {
+			Payload []byte
+			Integer int
+			Date    time.Time
+		}
+	}{}
+	if err = plist.Unmarshal(cmd.Raw, typed); err != nil {
+		t.Fatal(err)
+	}
+	if string(typed.Command.Payload) != "hello" || typed.Command.Integer != 42 || typed.Command.Date.IsZero() {
+		t.Errorf("unexpected command: %+v", typed.Command)
+	}
+
+	req = &jsonCommandRequest{
+		CommandUUID: "CMD1",
+		Command:     map[string]interface{}{"RequestType": "ProfileList"},
+	}
+	if cmd, err = newJSONCommand(req); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := cmd.CommandUUID, "CMD1"; have != want {
+		t.Errorf("command UUID: have: %q, want: %q", have, want)
+	}
+
+	// missing RequestType fails validation
+	req = &jsonCommandRequest{Command: map[string]interface{}{"Identifier": "x"}}
+	if _, err = newJSONCommand(req); err == nil {
+		t.Error("expected error")
+	}
+
+	req = &jsonCommandRequest{Command: map[string]interface{}{"RequestType": "x", "Null": nil}}
+	if _, err = newJSONCommand(req); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+const (
+	defaultEnrollmentsLimit = 100
+	maxEnrollmentsLimit     = 1000
+)
+
+// writeJSON marshals v as indented JSON and writes it with header.
+func writeJSON(w http.ResponseWriter, header int, v interface{}, logger log.Logger) {
+	json, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		logger.Info("msg", "marshal json", "err", err)
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(header)
+	_, err = w.Write(json)
+	if err != nil {
+		logger.Info("msg", "writing body", "err", err)
+	}
+}
+
+// writeJSONError writes err as a JSON error object with header.
+func writeJSONError(w http.ResponseWriter, header int, err error, logger log.Logger) {
+	output := &struct {
+		Error string `json:"error"`
+	}{Error: err.Error()}
+	writeJSON(w, header, output, logger)
+}
+
+// queryValues returns all values of the query parameter key.
+// Comma-separated values are split into separate values.
+func queryValues(q url.Values, key string) (values []string) {
+	for _, v := range q[key] {
+		for _, s := range strings.Split(v, ",") {
+			if s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return
+}
+
+// queryTime parses the RFC 3339 formatted query parameter key.
+// A zero time is returned if the parameter is not present.
+func queryTime(q url.Values, key string) (time.Time, error) {
+	v := q.Get(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, fmt.Errorf("parsing %s: %w", key, err)
+	}
+	return t, nil
+}
+
+// enrollmentFilterFromQuery assembles an enrollment filter from URL
+// query parameters.
+func enrollmentFilterFromQuery(q url.Values) (*storage.EnrollmentFilter, error) {
+	filter := &storage.EnrollmentFilter{
+		IDs:           queryValues(q, "id"),
+		Types:         queryValues(q, "type"),
+		Topics:        queryValues(q, "topic"),
+		SerialNumbers: queryValues(q, "serial_number"),
+		ParentIDs:     queryValues(q, "parent_id"),
+	}
+	if v := q.Get("device_channel"); v != "" {
+		device, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing device_channel: %w", err)
+		}
+		filter.DeviceChannel = &device
+	}
+	if v := q.Get("enabled"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing enabled: %w", err)
+		}
+		filter.Enabled = &enabled
+	}
+	var err error
+	if filter.LastSeenAfter, err = queryTime(q, "last_seen_after"); err != nil {
+		return nil, err
+	}
+	if filter.LastSeenBefore, err = queryTime(q, "last_seen_before"); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// ListEnrollmentsHandler returns a page of enrollments matching the
+// filters given in the URL query parameters.
+//
+// Supported filter parameters are "id", "type", "topic",
+// "serial_number", "parent_id" (each may be repeated or comma-separated),
+// "device_channel", "enabled" and the RFC 3339 "last_seen_after" and
+// "last_seen_before".
+// Paging is controlled with "limit" and "cursor" where the "next_cursor"
+// of a response is supplied as the "cursor" of the next request.
+func ListEnrollmentsHandler(lister storage.EnrollmentLister, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		q := r.URL.Query()
+		filter, err := enrollmentFilterFromQuery(q)
+		if err != nil {
+			logger.Info("msg", "parsing filter", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err, logger)
+			return
+		}
+		limit := defaultEnrollmentsLimit
+		if v := q.Get("limit"); v != "" {
+			limit, err = strconv.Atoi(v)
+			if err == nil && (limit < 1 || limit > maxEnrollmentsLimit) {
+				err = fmt.Errorf("limit must be between 1 and %d", maxEnrollmentsLimit)
+			} else if err != nil {
+				err = errors.New("limit must be a number")
+			}
+			if err != nil {
+				logger.Info("msg", "parsing limit", "err", err)
+				writeJSONError(w, http.StatusBadRequest, err, logger)
+				return
+			}
+		}
+		enrollments, err := lister.ListEnrollments(r.Context(), filter, q.Get("cursor"), limit)
+		if err != nil {
+			logger.Info("msg", "list enrollments", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, err, logger)
+			return
+		}
+		output := &struct {
+			Enrollments []*storage.Enrollment `json:"enrollments"`
+			NextCursor  string                `json:"next_cursor,omitempty"`
+		}{
+			Enrollments: enrollments,
+		}
+		if output.Enrollments == nil {
+			output.Enrollments = []*storage.Enrollment{}
+		}
+		if len(enrollments) >= limit {
+			output.NextCursor = enrollments[len(enrollments)-1].ID
+		}
+		logger.Debug("msg", "list enrollments", "count", len(enrollments))
+		writeJSON(w, http.StatusOK, output, logger)
+	}
+}
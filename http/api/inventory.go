@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// RetrieveInventoryHandler returns the inventory of an enrollment.
+//
+// Note the whole URL path is used as the enrollment ID. This probably
+// necessitates stripping the URL prefix before using.
+func RetrieveInventoryHandler(retriever storage.InventoryRetriever, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path
+		ctx, logger := setupCtxLog(r.Context(), []string{id}, logger)
+		if id == "" {
+			err := errors.New("no enrollment ID")
+			logger.Info("msg", "retrieve inventory", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err, logger)
+			return
+		}
+		values, err := retriever.RetrieveInventory(ctx, id)
+		if err != nil {
+			logger.Info("msg", "retrieve inventory", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, err, logger)
+			return
+		}
+		output := &struct {
+			ID        string                  `json:"id"`
+			Inventory storage.InventoryValues `json:"inventory"`
+		}{
+			ID:        id,
+			Inventory: values,
+		}
+		if output.Inventory == nil {
+			output.Inventory = storage.InventoryValues{}
+		}
+		logger.Debug("msg", "retrieve inventory", "count", len(values))
+		writeJSON(w, http.StatusOK, output, logger)
+	}
+}
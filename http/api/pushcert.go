@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// ListPushCertsHandler returns information about each stored push
+// certificate.
+func ListPushCertsHandler(lister storage.PushCertLister, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		infos, err := lister.ListPushCerts(r.Context())
+		if err != nil {
+			logger.Info("msg", "list push certs", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, err, logger)
+			return
+		}
+		output := &struct {
+			PushCerts []*storage.PushCertInfo `json:"push_certs"`
+		}{
+			PushCerts: infos,
+		}
+		if output.PushCerts == nil {
+			output.PushCerts = []*storage.PushCertInfo{}
+		}
+		logger.Debug("msg", "list push certs", "count", len(infos))
+		writeJSON(w, http.StatusOK, output, logger)
+	}
+}
+
+// DeletePushCertHandler deletes the stored push certificate of a topic.
+//
+// Note the whole URL path is used as the topic. This probably
+// necessitates stripping the URL prefix before using.
+func DeletePushCertHandler(deleter storage.PushCertDeleter, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		topic := r.URL.Path
+		logger := ctxlog.Logger(r.Context(), logger).With("topic", topic)
+		if topic == "" || strings.Contains(topic, "/") {
+			err := errors.New("invalid topic")
+			logger.Info("msg", "delete push cert", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err, logger)
+			return
+		}
+		if err := deleter.DeletePushCert(r.Context(), topic); err != nil {
+			logger.Info("msg", "delete push cert", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, err, logger)
+			return
+		}
+		logger.Info("msg", "deleted push cert")
+		output := &struct {
+			Topic string `json:"topic"`
+		}{
+			Topic: topic,
+		}
+		writeJSON(w, http.StatusOK, output, logger)
+	}
+}
+
+// storedPushCert returns the stored push certificate of topic, if any.
+func storedPushCert(ctx context.Context, lister storage.PushCertLister, topic string) (*storage.PushCertInfo, error) {
+	infos, err := lister.ListPushCerts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing push certs: %w", err)
+	}
+	for _, info := range infos {
+		if info.Topic == topic {
+			return info, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkPushCertUpload checks whether cert for topic is safe to store
+// over previous (the stored push certificate of topic, if any). A cert
+// that expires before previous or whose topic is not used by any
+// enrollment (when there are enrollments) is refused. The enrollment
+// check is skipped if lister is nil.
+func checkPushCertUpload(ctx context.Context, lister storage.EnrollmentLister, cert *x509.Certificate, topic string, previous *storage.PushCertInfo) (refused bool, err error) {
+	if previous != nil && cert.NotAfter.Before(previous.NotAfter) {
+		return true, fmt.Errorf(
+			"push certificate expires (%s) before the stored push certificate (%s); use force to override",
+			cert.NotAfter.Format(time.RFC3339),
+			previous.NotAfter.Format(time.RFC3339),
+		)
+	}
+	if lister == nil {
+		return false, nil
+	}
+	enrollments, err := lister.ListEnrollments(ctx, &storage.EnrollmentFilter{Topics: []string{topic}}, "", 1)
+	if err != nil || len(enrollments) > 0 {
+		return false, err
+	}
+	enrollments, err = lister.ListEnrollments(ctx, nil, "", 1)
+	if err != nil || len(enrollments) < 1 {
+		return false, err
+	}
+	return true, fmt.Errorf("no enrollments use topic %q; use force to override", topic)
+}
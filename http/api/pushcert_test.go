@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/push/apnstest"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+type testPushCertStore struct {
+	certs       map[string][]byte
+	enrollments []*storage.Enrollment
+}
+
+func (s *testPushCertStore) IsPushCertStale(context.Context, string, string) (bool, error) {
+	return false, nil
+}
+
+func (s *testPushCertStore) RetrievePushCert(context.Context, string) (*tls.Certificate, string, error) {
+	return nil, "", errors.New("not implemented")
+}
+
+func (s *testPushCertStore) StorePushCert(_ context.Context, pemCert, _ []byte) error {
+	info, err := storage.NewPushCertInfo(pemCert, "")
+	if err != nil {
+		return err
+	}
+	s.certs[info.Topic] = pemCert
+	return nil
+}
+
+func (s *testPushCertStore) ListPushCerts(context.Context) ([]*storage.PushCertInfo, error) {
+	var infos []*storage.PushCertInfo
+	for _, pemCert := range s.certs {
+		info, err := storage.NewPushCertInfo(pemCert, "")
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *testPushCertStore) ListEnrollments(_ context.Context, filter *storage.EnrollmentFilter, _ string, _ int) ([]*storage.Enrollment, error) {
+	var enrollments []*storage.Enrollment
+	for _, e := range s.enrollments {
+		if filter.Match(e) {
+			enrollments = append(enrollments, e)
+		}
+	}
+	return enrollments, nil
+}
+
+func TestStorePushCert(t *testing.T) {
+	const topic = "com.apple.mgmt.External.1b9d3f6a-2c4e-4a8b-9d7f-0e2c4a6b8d1f"
+	const otherTopic = "com.apple.mgmt.External.5e7a9c1b-3d5f-4b7a-8c9e-1f3a5c7e9b2d"
+	now := time.Now().Truncate(time.Second)
+	store := &testPushCertStore{certs: make(map[string][]byte)}
+	handler := StorePushCertHandler(store, log.NopLogger)
+
+	upload := func(certPEM, keyPEM []byte, query string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPut, "/"+query, bytes.NewReader(append(certPEM, keyPEM...)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		output := make(map[string]interface{})
+		if err := json.Unmarshal(rec.Body.Bytes(), &output); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, output
+	}
+
+	// initial upload with no enrollments
+	certPEM, keyPEM := apnstest.NewPushCert(t, topic, 1, now.Add(365*24*time.Hour))
+	if code, output := upload(certPEM, keyPEM, ""); code != http.StatusOK {
+		t.Fatalf("unexpected response: %d: %v", code, output)
+	}
+	store.enrollments = []*storage.Enrollment{{ID: "ID1", Topic: topic}}
+
+	// older cert for the same topic
+	olderPEM, olderKeyPEM := apnstest.NewPushCert(t, topic, 2, now.Add(30*24*time.Hour))
+	code, output := upload(olderPEM, olderKeyPEM, "")
+	if code != http.StatusConflict {
+		t.Errorf("unexpected response: %d: %v", code, output)
+	}
+	if output["previous_not_after"] == nil || output["not_after"] == nil {
+		t.Errorf("expected expiry dates: %v", output)
+	}
+	if code, output = upload(olderPEM, olderKeyPEM, "?force=1"); code != http.StatusOK {
+		t.Errorf("unexpected response: %d: %v", code, output)
+	}
+
+	// renewal
+	newerPEM, newerKeyPEM := apnstest.NewPushCert(t, topic, 3, now.Add(2*365*24*time.Hour))
+	if code, output = upload(newerPEM, newerKeyPEM, ""); code != http.StatusOK {
+		t.Errorf("unexpected response: %d: %v", code, output)
+	}
+
+	// different topic than enrollments use
+	otherPEM, otherKeyPEM := apnstest.NewPushCert(t, otherTopic, 4, now.Add(365*24*time.Hour))
+	if code, output = upload(otherPEM, otherKeyPEM, ""); code != http.StatusConflict {
+		t.Errorf("unexpected response: %d: %v", code, output)
+	}
+	if _, ok := store.certs[otherTopic]; ok {
+		t.Error("refused push cert was stored")
+	}
+
+	// mismatched key
+	if code, output = upload(newerPEM, otherKeyPEM, "?force=1"); code != http.StatusBadRequest {
+		t.Errorf("unexpected response: %d: %v", code, output)
+	}
+}
+
+// certOnlyStore hides the listing methods of the store it embeds.
+type certOnlyStore struct {
+	storage.PushCertStore
+}
+
+func TestStorePushCertWithoutListers(t *testing.T) {
+	const topic = "com.apple.mgmt.External.1b9d3f6a-2c4e-4a8b-9d7f-0e2c4a6b8d1f"
+	now := time.Now().Truncate(time.Second)
+	store := &testPushCertStore{
+		certs:       make(map[string][]byte),
+		enrollments: []*storage.Enrollment{{ID: "ID1", Topic: "com.example.other"}},
+	}
+	logger := &skipLogger{}
+	handler := StorePushCertHandler(&certOnlyStore{store}, logger)
+
+	// the safety checks need listing so they are skipped
+	for i, notAfter := range []time.Time{now.Add(365 * 24 * time.Hour), now.Add(30 * 24 * time.Hour)} {
+		certPEM, keyPEM := apnstest.NewPushCert(t, topic, int64(i+1), notAfter)
+		req := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(append(certPEM, keyPEM...)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("unexpected response: %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	if _, ok := store.certs[topic]; !ok {
+		t.Error("push cert not stored")
+	}
+	for _, check := range []string{"expiry", "topic"} {
+		if have, want := logger.skipped[check], 2; have != want {
+			t.Errorf("%s check: skipped logged %d times, want %d", check, have, want)
+		}
+	}
+}
+
+// skipLogger counts info logs of skipped push cert checks by check.
+type skipLogger struct {
+	skipped map[string]int
+}
+
+func (l *skipLogger) Info(args ...interface{}) {
+	if len(args) < 4 || args[1] != "push cert check skipped" {
+		return
+	}
+	if l.skipped == nil {
+		l.skipped = make(map[string]int)
+	}
+	l.skipped[args[3].(string)]++
+}
+
+func (l *skipLogger) Debug(_ ...interface{}) {}
+
+func (l *skipLogger) With(_ ...interface{}) log.Logger { return l }
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+const (
+	defaultPushHistoryLimit = 10
+	maxPushHistoryLimit     = 1000
+)
+
+// RetrievePushHistoryHandler returns the most recent push results of
+// an enrollment from newest to oldest. The number of push results is
+// controlled with the "limit" URL query parameter.
+//
+// Note the whole URL path is used as the enrollment ID. This probably
+// necessitates stripping the URL prefix before using.
+func RetrievePushHistoryHandler(retriever storage.PushHistoryRetriever, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path
+		ctx, logger := setupCtxLog(r.Context(), []string{id}, logger)
+		if id == "" {
+			err := errors.New("no enrollment ID")
+			logger.Info("msg", "retrieve push history", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err, logger)
+			return
+		}
+		limit := defaultPushHistoryLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			limit, err = strconv.Atoi(v)
+			if err == nil && (limit < 1 || limit > maxPushHistoryLimit) {
+				err = fmt.Errorf("limit must be between 1 and %d", maxPushHistoryLimit)
+			} else if err != nil {
+				err = errors.New("limit must be a number")
+			}
+			if err != nil {
+				logger.Info("msg", "parsing limit", "err", err)
+				writeJSONError(w, http.StatusBadRequest, err, logger)
+				return
+			}
+		}
+		results, err := retriever.RetrievePushHistory(ctx, id, limit)
+		if err != nil {
+			logger.Info("msg", "retrieve push history", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, err, logger)
+			return
+		}
+		output := &struct {
+			ID     string                `json:"id"`
+			Pushes []*storage.PushResult `json:"pushes"`
+		}{
+			ID:     id,
+			Pushes: results,
+		}
+		if output.Pushes == nil {
+			output.Pushes = []*storage.PushResult{}
+		}
+		logger.Debug("msg", "retrieve push history", "count", len(results))
+		writeJSON(w, http.StatusOK, output, logger)
+	}
+}
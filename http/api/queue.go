@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// RetrieveQueueHandler returns the command queue of an enrollment.
+//
+// Note the whole URL path is used as the enrollment ID. This probably
+// necessitates stripping the URL prefix before using.
+func RetrieveQueueHandler(retriever storage.QueueRetriever, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path
+		ctx, logger := setupCtxLog(r.Context(), []string{id}, logger)
+		if id == "" {
+			err := errors.New("no enrollment ID")
+			logger.Info("msg", "retrieve queue", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err, logger)
+			return
+		}
+		items, err := retriever.RetrieveQueue(ctx, id)
+		if err != nil {
+			logger.Info("msg", "retrieve queue", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, err, logger)
+			return
+		}
+		output := &struct {
+			ID    string               `json:"id"`
+			Queue []*storage.QueueItem `json:"queue"`
+		}{
+			ID:    id,
+			Queue: items,
+		}
+		if output.Queue == nil {
+			output.Queue = []*storage.QueueItem{}
+		}
+		logger.Debug("msg", "retrieve queue", "count", len(items))
+		writeJSON(w, http.StatusOK, output, logger)
+	}
+}
+
+// CommandDequeueHandler removes a queued command from MDM enrollments.
+// The command UUID is taken from the "command_uuid" query parameter.
+//
+// Note the whole URL path is used as the identifier(s) to dequeue
+// from. An empty path dequeues the command from all enrollments. This
+// probably necessitates stripping the URL prefix before using.
+func CommandDequeueHandler(dequeuer storage.CommandDequeuer, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		uuid := r.URL.Query().Get("command_uuid")
+		if uuid == "" {
+			err := errors.New("no command_uuid")
+			logger.Info("msg", "dequeue", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err, logger)
+			return
+		}
+		logger = logger.With("command_uuid", uuid)
+		ct, err := dequeuer.DequeueCommand(ctx, ids, uuid)
+		if err != nil {
+			logger.Info("msg", "dequeue", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, err, logger)
+			return
+		}
+		logger.Info("msg", "dequeue", "count", ct)
+		output := &struct {
+			CommandUUID string `json:"command_uuid"`
+			Count       int    `json:"count"`
+		}{
+			CommandUUID: uuid,
+			Count:       ct,
+		}
+		writeJSON(w, http.StatusOK, output, logger)
+	}
+}
+
+// DeadLettersHandler returns dead-lettered commands. The optional "id"
+// query parameter limits the results to those enrollment IDs.
+func DeadLettersHandler(retriever storage.DeadLetterRetriever, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ids := queryValues(r.URL.Query(), "id")
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		deadLetters, err := retriever.RetrieveDeadLetters(ctx, ids)
+		if err != nil {
+			logger.Info("msg", "retrieve dead letters", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, err, logger)
+			return
+		}
+		output := &struct {
+			DeadLetters []*storage.DeadLetter `json:"dead_letters"`
+		}{
+			DeadLetters: deadLetters,
+		}
+		if output.DeadLetters == nil {
+			output.DeadLetters = []*storage.DeadLetter{}
+		}
+		logger.Debug("msg", "retrieve dead letters", "count", len(deadLetters))
+		writeJSON(w, http.StatusOK, output, logger)
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// selectBatchSize is the number of selected enrollments enqueued to
+// and pushed to at a time.
+const selectBatchSize = 500
+
+var (
+	errEmptySelector       = errors.New("empty selector: set \"all\" to select all enrollments")
+	errNoneSelected        = errors.New("no enrollments selected")
+	errSelectorUnsupported = errors.New("enrollment selectors not supported")
+	errIDsAndSelector      = errors.New("both ids and selector supplied")
+)
+
+// EnqueueHandlerOption configures the command enqueue handlers.
+type EnqueueHandlerOption func(*enqueueHandler)
+
+type enqueueHandler struct {
+	lister storage.EnrollmentLister
+}
+
+// WithEnrollmentLister enables enqueueing commands for the enrollments
+// chosen by a selector. The selected enrollments are listed with lister.
+func WithEnrollmentLister(lister storage.EnrollmentLister) EnqueueHandlerOption {
+	return func(h *enqueueHandler) {
+		h.lister = lister
+	}
+}
+
+func newEnqueueHandler(opts []EnqueueHandlerOption) *enqueueHandler {
+	h := new(enqueueHandler)
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// enrollmentSelector selects enrollments to push to or enqueue
+// commands for. Multiple values within the same field match any of
+// those values while separate fields must all match.
+type enrollmentSelector struct {
+	IDs           []string `json:"ids,omitempty"`
+	SerialNumbers []string `json:"serial_numbers,omitempty"`
+	Topics        []string `json:"topics,omitempty"`
+	Types         []string `json:"types,omitempty"`
+	// ParentIDs selects the user channel enrollments of these device
+	// channel enrollment IDs.
+	ParentIDs     []string `json:"parent_ids,omitempty"`
+	DeviceChannel *bool    `json:"device_channel,omitempty"`
+	Enabled       *bool    `json:"enabled,omitempty"`
+	// All must be set to select all enrollments (otherwise matched by
+	// an empty selector).
+	All bool `json:"all,omitempty"`
+}
+
+// filter converts the selector to a storage enrollment filter.
+func (s *enrollmentSelector) filter() (*storage.EnrollmentFilter, error) {
+	filter := &storage.EnrollmentFilter{
+		IDs:           s.IDs,
+		SerialNumbers: s.SerialNumbers,
+		Topics:        s.Topics,
+		Types:         s.Types,
+		ParentIDs:     s.ParentIDs,
+		DeviceChannel: s.DeviceChannel,
+		Enabled:       s.Enabled,
+	}
+	if !s.All && emptyFilter(filter) {
+		return nil, errEmptySelector
+	}
+	return filter, nil
+}
+
+// emptyFilter reports whether filter has no criteria (and so matches
+// every enrollment).
+func emptyFilter(f *storage.EnrollmentFilter) bool {
+	return len(f.IDs) < 1 && len(f.SerialNumbers) < 1 && len(f.Topics) < 1 &&
+		len(f.Types) < 1 && len(f.ParentIDs) < 1 && f.DeviceChannel == nil &&
+		f.Enabled == nil && f.LastSeenAfter.IsZero() && f.LastSeenBefore.IsZero()
+}
+
+// selectorFromQuery assembles an enrollment filter from the selector
+// URL query parameters. These are the filter parameters of
+// ListEnrollmentsHandler and "all" to select all enrollments. A nil
+// filter is returned if no selector parameters are present.
+func selectorFromQuery(q url.Values) (*storage.EnrollmentFilter, error) {
+	filter, err := enrollmentFilterFromQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	if v := q.Get("all"); v != "" {
+		all, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing all: %w", err)
+		}
+		if !all && emptyFilter(filter) {
+			return nil, errEmptySelector
+		}
+		return filter, nil
+	}
+	if emptyFilter(filter) {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// selectIDs calls fn with batches of the enrollment IDs selected by
+// filter. It returns errNoneSelected if no enrollments were selected.
+func selectIDs(ctx context.Context, lister storage.EnrollmentLister, filter *storage.EnrollmentFilter, fn func([]string) error) (int, error) {
+	var ct int
+	err := storage.ListEnrollmentIDs(ctx, lister, filter, selectBatchSize, func(ids []string) error {
+		ct += len(ids)
+		return fn(ids)
+	})
+	if err == nil && ct < 1 {
+		err = errNoneSelected
+	}
+	return ct, err
+}
+
+// PushSelectHandler sends APNs push notifications to the MDM
+// enrollments chosen by the JSON "selector" object of the body.
+// Enrollments are selected in storage and pushed to in batches.
+func PushSelectHandler(lister storage.EnrollmentLister, pusher push.Pusher, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		req := new(struct {
+			Selector *enrollmentSelector `json:"selector"`
+		})
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err == nil && req.Selector == nil {
+			err = errors.New("no selector")
+		}
+		var filter *storage.EnrollmentFilter
+		if err == nil {
+			filter, err = req.Selector.filter()
+		}
+		if err != nil {
+			logger.Info("msg", "decoding selector", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err, logger)
+			return
+		}
+		output := apiResult{
+			Status: make(enrolledAPIResults),
+		}
+		tally := new(apiTally)
+		ct, err := selectIDs(r.Context(), lister, filter, func(ids []string) error {
+			pushIDs(r.Context(), &output, tally, pusher, ids, logger)
+			return nil
+		})
+		if errors.Is(err, errNoneSelected) {
+			writeJSONError(w, http.StatusNotFound, err, logger)
+			return
+		} else if err != nil {
+			logger.Info("msg", "selecting enrollments", "count", ct, "err", err)
+			if ct < 1 {
+				writeJSONError(w, http.StatusInternalServerError, err, logger)
+				return
+			}
+			if tally.pushErr == nil {
+				tally.pushErr = err
+				output.PushError = err.Error()
+			}
+		}
+		logger.Debug("msg", "push selected", "count", ct)
+		writeJSON(w, tally.pushHeader(), output, logger)
+	}
+}
+
+// enqueueSelected enqueues command for the enrollments selected by
+// filter (in batches), sends push notifications to them unless nopush
+// is set, and writes the JSON API result to w.
+func (h *enqueueHandler) enqueueSelected(ctx context.Context, w http.ResponseWriter, enqueuer storage.CommandEnqueuer, pusher push.Pusher, filter *storage.EnrollmentFilter, command *mdm.Command, nopush bool, opts []storage.EnqueueOption, logger log.Logger) {
+	if h.lister == nil {
+		logger.Info("msg", "enqueue", "err", errSelectorUnsupported)
+		writeJSONError(w, http.StatusNotImplemented, errSelectorUnsupported, logger)
+		return
+	}
+	output := newEnqueueResult(command, nopush)
+	logger = logger.With(
+		"command_uuid", command.CommandUUID,
+		"request_type", command.Command.RequestType,
+	)
+	tally := new(apiTally)
+	// batches after the first add to the already enqueued command
+	batchOpts := opts
+	appendOpts := append(opts[:len(opts):len(opts)], storage.WithAppend())
+	ct, err := selectIDs(ctx, h.lister, filter, func(ids []string) error {
+		enqueueIDs(ctx, output, tally, enqueuer, pusher, ids, command, nopush, batchOpts, logger)
+		if tally.ct < 1 && tally.err != nil {
+			// the first batch failed entirely (e.g. a duplicate
+			// command UUID) so don't bother with the rest
+			return tally.err
+		}
+		batchOpts = appendOpts
+		return nil
+	})
+	if errors.Is(err, errNoneSelected) {
+		writeJSONError(w, http.StatusNotFound, err, logger)
+		return
+	} else if err != nil && err != tally.err {
+		logger.Info("msg", "selecting enrollments", "count", ct, "err", err)
+		if tally.err == nil {
+			tally.err = err
+			output.CommandError = err.Error()
+		}
+	}
+	logger.Debug("msg", "enqueue selected", "count", ct)
+	writeJSON(w, tally.enqueueHeader(nopush), output, logger)
+}
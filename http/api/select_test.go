@@ -0,0 +1,230 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// testSelectStore is an enrollment lister, command enqueuer, and pusher.
+type testSelectStore struct {
+	enrollments []*storage.Enrollment
+	enqueued    [][]string
+	appended    []bool
+	pushed      [][]string
+}
+
+func (s *testSelectStore) ListEnrollments(_ context.Context, filter *storage.EnrollmentFilter, cursor string, limit int) ([]*storage.Enrollment, error) {
+	var enrollments []*storage.Enrollment
+	for _, e := range s.enrollments {
+		if e.ID > cursor && filter.Match(e) {
+			enrollments = append(enrollments, e)
+		}
+		if limit > 0 && len(enrollments) >= limit {
+			break
+		}
+	}
+	return enrollments, nil
+}
+
+func (s *testSelectStore) EnqueueCommand(_ context.Context, ids []string, _ *mdm.Command, opts ...storage.EnqueueOption) (map[string]error, error) {
+	s.enqueued = append(s.enqueued, ids)
+	s.appended = append(s.appended, storage.NewEnqueueOptions(opts...).Append)
+	return nil, nil
+}
+
+func (s *testSelectStore) Push(_ context.Context, ids []string) (map[string]*push.Response, error) {
+	s.pushed = append(s.pushed, ids)
+	resps := make(map[string]*push.Response)
+	for _, id := range ids {
+		resps[id] = &push.Response{Id: "apns-" + id}
+	}
+	return resps, nil
+}
+
+func newTestSelectStore(devices int) *testSelectStore {
+	s := new(testSelectStore)
+	for i := 0; i < devices; i++ {
+		id := fmt.Sprintf("DEVICE-%04d", i)
+		s.enrollments = append(s.enrollments, &storage.Enrollment{
+			ID:           id,
+			Type:         "Device",
+			SerialNumber: fmt.Sprintf("SERIAL%04d", i),
+			Enabled:      true,
+		}, &storage.Enrollment{
+			ID:       id + "-USER",
+			ParentID: id,
+			Type:     "User",
+			Enabled:  true,
+		})
+	}
+	sort.Slice(s.enrollments, func(i, j int) bool { return s.enrollments[i].ID < s.enrollments[j].ID })
+	return s
+}
+
+func serveSelect(t *testing.T, handler http.Handler, body string) (int, *apiResult) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	output := new(apiResult)
+	if err := json.Unmarshal(rec.Body.Bytes(), output); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, output
+}
+
+func TestPushSelect(t *testing.T) {
+	store := newTestSelectStore(selectBatchSize + 1)
+	handler := PushSelectHandler(store, store, log.NopLogger)
+
+	for _, body := range []string{`{}`, `{"selector": {}}`, `not json`} {
+		if code, _ := serveSelect(t, handler, body); code != http.StatusBadRequest {
+			t.Errorf("%s: status: have: %d, want: %d", body, code, http.StatusBadRequest)
+		}
+	}
+	if code, _ := serveSelect(t, handler, `{"selector": {"serial_numbers": ["NONE"]}}`); code != http.StatusNotFound {
+		t.Errorf("status: have: %d, want: %d", code, http.StatusNotFound)
+	}
+
+	// all enabled device channels are pushed to in batches
+	code, output := serveSelect(t, handler, `{"selector": {"device_channel": true, "enabled": true}}`)
+	if code != http.StatusOK {
+		t.Errorf("status: have: %d, want: %d", code, http.StatusOK)
+	}
+	if have, want := len(output.Status), selectBatchSize+1; have != want {
+		t.Errorf("status: have: %d, want: %d", have, want)
+	}
+	if have, want := len(store.pushed), 2; have != want {
+		t.Fatalf("batches: have: %d, want: %d", have, want)
+	}
+	if have, want := len(store.pushed[0]), selectBatchSize; have != want {
+		t.Errorf("batch size: have: %d, want: %d", have, want)
+	}
+
+	// user channels of a device
+	store.pushed = nil
+	_, output = serveSelect(t, handler, `{"selector": {"parent_ids": ["DEVICE-0001"]}}`)
+	if r := output.Status["DEVICE-0001-USER"]; len(output.Status) != 1 || r == nil || r.PushResult != "apns-DEVICE-0001-USER" {
+		t.Errorf("unexpected status: %+v", output.Status)
+	}
+}
+
+func TestEnqueueSelect(t *testing.T) {
+	store := newTestSelectStore(selectBatchSize + 1)
+	handler := CommandEnqueueHandler(store, store, log.NopLogger, WithEnrollmentLister(store))
+
+	const command = `"command": {"RequestType": "ProfileList"}`
+	// selectors need a lister
+	noLister := CommandEnqueueHandler(store, store, log.NopLogger)
+	if code, _ := serveSelect(t, noLister, `{`+command+`, "selector": {"all": true}}`); code != http.StatusNotImplemented {
+		t.Errorf("status: have: %d, want: %d", code, http.StatusNotImplemented)
+	}
+	if code, _ := serveSelect(t, handler, `{`+command+`, "ids": ["DEVICE-0001"], "selector": {"all": true}}`); code != http.StatusBadRequest {
+		t.Errorf("status: have: %d, want: %d", code, http.StatusBadRequest)
+	}
+
+	code, output := serveSelect(t, handler, `{`+command+`, "selector": {"types": ["Device"]}}`)
+	if code != http.StatusOK {
+		t.Errorf("status: have: %d, want: %d", code, http.StatusOK)
+	}
+	if output.CommandUUID == "" || output.RequestType != "ProfileList" {
+		t.Errorf("unexpected output: %+v", output)
+	}
+	if have, want := len(store.enqueued), 2; have != want {
+		t.Fatalf("batches: have: %d, want: %d", have, want)
+	}
+	if have, want := len(store.enqueued[1]), 1; have != want {
+		t.Errorf("batch size: have: %d, want: %d", have, want)
+	}
+	// only the first batch stores the command
+	if store.appended[0] || !store.appended[1] {
+		t.Errorf("unexpected append options: %v", store.appended)
+	}
+	if have, want := len(store.pushed), 2; have != want {
+		t.Errorf("push batches: have: %d, want: %d", have, want)
+	}
+
+	// nopush
+	store.pushed = nil
+	code, output = serveSelect(t, handler, `{`+command+`, "nopush": true, "selector": {"serial_numbers": ["SERIAL0001", "SERIAL0002"]}}`)
+	if code != http.StatusOK || !output.NoPush {
+		t.Errorf("unexpected response: %d: %+v", code, output)
+	}
+	if have, want := len(store.pushed), 0; have != want {
+		t.Errorf("push batches: have: %d, want: %d", have, want)
+	}
+}
+
+func TestRawEnqueueSelect(t *testing.T) {
+	store := newTestSelectStore(selectBatchSize + 1)
+	handler := RawCommandEnqueueHandler(store, store, log.NopLogger, WithEnrollmentLister(store))
+	command, err := mdm.NewCommand("", map[string]interface{}{"RequestType": "ProfileList"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// path is the (prefix stripped) comma-separated enrollment IDs
+	serve := func(path, query string) (int, *apiResult) {
+		req := httptest.NewRequest(http.MethodPut, "/?"+query, bytes.NewReader(command.Raw))
+		req.URL.Path = path
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		output := new(apiResult)
+		if rec.Code != http.StatusBadRequest {
+			if err := json.Unmarshal(rec.Body.Bytes(), output); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, output
+	}
+
+	for _, tc := range []struct {
+		path, query string
+	}{
+		{"", ""},
+		{"", "all=0"},
+		{"", "enabled=maybe"},
+		{"DEVICE-0001", "type=Device"},
+	} {
+		if code, _ := serve(tc.path, tc.query); code != http.StatusBadRequest {
+			t.Errorf("%q %q: status: have: %d, want: %d", tc.path, tc.query, code, http.StatusBadRequest)
+		}
+	}
+	if len(store.enqueued) > 0 {
+		t.Fatalf("enqueued: %v", store.enqueued)
+	}
+
+	code, output := serve("", "type=Device&priority=5")
+	if code != http.StatusOK || output.CommandUUID != command.CommandUUID {
+		t.Errorf("unexpected response: %d: %+v", code, output)
+	}
+	if have, want := len(store.enqueued), 2; have != want {
+		t.Fatalf("batches: have: %d, want: %d", have, want)
+	}
+	if have, want := len(store.enqueued[0])+len(store.enqueued[1]), selectBatchSize+1; have != want {
+		t.Errorf("enqueued: have: %d, want: %d", have, want)
+	}
+	if have, want := len(store.pushed), 2; have != want {
+		t.Errorf("push batches: have: %d, want: %d", have, want)
+	}
+
+	// enrollment IDs in the path
+	store.enqueued = nil
+	if code, _ = serve("DEVICE-0001,DEVICE-0002", "nopush=1"); code != http.StatusOK {
+		t.Errorf("status: have: %d, want: %d", code, http.StatusOK)
+	}
+	if len(store.enqueued) != 1 || len(store.enqueued[0]) != 2 {
+		t.Errorf("enqueued: have: %v, want: [[DEVICE-0001 DEVICE-0002]]", store.enqueued)
+	}
+}
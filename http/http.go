@@ -40,6 +40,18 @@ func BasicAuthMiddleware(next http.Handler, username, password, realm string) ht
 	}
 }
 
+// MethodHandler dispatches requests with HTTP method to h and all
+// other requests to next.
+func MethodHandler(next http.Handler, method string, h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == method {
+			h.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
 // VersionHandler returns a simple JSON response from a version string.
 func VersionHandler(version string) http.HandlerFunc {
 	bodyBytes := []byte(`{"version":"` + version + `"}`)
@@ -0,0 +1,239 @@
+package mdm
+
+import (
+	"crypto/rand"
+	"fmt"
+	"reflect"
+
+	"github.com/groob/plist"
+)
+
+// NewCommandUUID generates a random (version 4) UUID for use as a
+// CommandUUID.
+func NewCommandUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// NewCommand marshals the command dictionary cmd into an MDM command
+// with a CommandUUID of uuid. A CommandUUID is generated if uuid is
+// empty. The command dictionary is usually one of the typed commands
+// in this package but may be any value that marshals to a plist
+// dictionary that contains a RequestType.
+func NewCommand(uuid string, cmd interface{}) (*Command, error) {
+	if uuid == "" {
+		var err error
+		if uuid, err = NewCommandUUID(); err != nil {
+			return nil, err
+		}
+	}
+	// the plist encoder does not follow pointers held in interfaces
+	if v := reflect.ValueOf(cmd); v.Kind() == reflect.Ptr && !v.IsNil() {
+		cmd = v.Elem().Interface()
+	}
+	raw, err := plist.Marshal(&struct {
+		CommandUUID string
+		Command     interface{}
+	}{
+		CommandUUID: uuid,
+		Command:     cmd,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return DecodeCommand(raw)
+}
+
+// DeviceInformationCommand is a "DeviceInformation" command.
+// See https://developer.apple.com/documentation/devicemanagement/deviceinformationcommand/command
+type DeviceInformationCommand struct {
+	RequestType string
+	Queries     []string `plist:",omitempty"`
+}
+
+// NewDeviceInformationCommand creates a new DeviceInformation command
+// for queries. If no queries are given the device chooses which to return.
+func NewDeviceInformationCommand(queries ...string) *DeviceInformationCommand {
+	return &DeviceInformationCommand{RequestType: "DeviceInformation", Queries: queries}
+}
+
+// SecurityInfoCommand is a "SecurityInfo" command.
+// See https://developer.apple.com/documentation/devicemanagement/securityinfocommand/command
+type SecurityInfoCommand struct {
+	RequestType string
+}
+
+// NewSecurityInfoCommand creates a new SecurityInfo command.
+func NewSecurityInfoCommand() *SecurityInfoCommand {
+	return &SecurityInfoCommand{RequestType: "SecurityInfo"}
+}
+
+// InstallProfileCommand is an "InstallProfile" command.
+// See https://developer.apple.com/documentation/devicemanagement/installprofilecommand/command
+type InstallProfileCommand struct {
+	RequestType string
+	Payload     []byte
+}
+
+// NewInstallProfileCommand creates a new InstallProfile command for
+// the (possibly signed) profile in payload.
+func NewInstallProfileCommand(payload []byte) *InstallProfileCommand {
+	return &InstallProfileCommand{RequestType: "InstallProfile", Payload: payload}
+}
+
+// RemoveProfileCommand is a "RemoveProfile" command.
+// See https://developer.apple.com/documentation/devicemanagement/removeprofilecommand/command
+type RemoveProfileCommand struct {
+	RequestType string
+	Identifier  string
+}
+
+// NewRemoveProfileCommand creates a new RemoveProfile command for the
+// profile with identifier.
+func NewRemoveProfileCommand(identifier string) *RemoveProfileCommand {
+	return &RemoveProfileCommand{RequestType: "RemoveProfile", Identifier: identifier}
+}
+
+// InstallApplicationCommand is an "InstallApplication" command.
+// Exactly one of ITunesStoreID, Identifier, or ManifestURL should be set.
+// See https://developer.apple.com/documentation/devicemanagement/installapplicationcommand/command
+type InstallApplicationCommand struct {
+	RequestType           string
+	ITunesStoreID         int    `plist:"iTunesStoreID,omitempty"`
+	Identifier            string `plist:",omitempty"`
+	ManifestURL           string `plist:",omitempty"`
+	ManagementFlags       int    `plist:",omitempty"`
+	ChangeManagementState string `plist:",omitempty"`
+	InstallAsManaged      bool   `plist:",omitempty"`
+}
+
+// NewInstallApplicationCommand creates a new InstallApplication
+// command for the application manifest at manifestURL.
+func NewInstallApplicationCommand(manifestURL string) *InstallApplicationCommand {
+	return &InstallApplicationCommand{RequestType: "InstallApplication", ManifestURL: manifestURL}
+}
+
+// InstalledApplicationListCommand is an "InstalledApplicationList" command.
+// See https://developer.apple.com/documentation/devicemanagement/installedapplicationlistcommand/command
+type InstalledApplicationListCommand struct {
+	RequestType     string
+	Identifiers     []string `plist:",omitempty"`
+	ManagedAppsOnly bool     `plist:",omitempty"`
+}
+
+// NewInstalledApplicationListCommand creates a new
+// InstalledApplicationList command for the applications with bundle
+// identifiers. All applications are listed if none are given.
+func NewInstalledApplicationListCommand(identifiers ...string) *InstalledApplicationListCommand {
+	return &InstalledApplicationListCommand{RequestType: "InstalledApplicationList", Identifiers: identifiers}
+}
+
+// ProfileListCommand is a "ProfileList" command.
+// See https://developer.apple.com/documentation/devicemanagement/profilelistcommand/command
+type ProfileListCommand struct {
+	RequestType string
+}
+
+// NewProfileListCommand creates a new ProfileList command.
+func NewProfileListCommand() *ProfileListCommand {
+	return &ProfileListCommand{RequestType: "ProfileList"}
+}
+
+// DeviceLockCommand is a "DeviceLock" command.
+// See https://developer.apple.com/documentation/devicemanagement/devicelockcommand/command
+type DeviceLockCommand struct {
+	RequestType string
+	// PIN is required for macOS devices.
+	PIN         string `plist:",omitempty"`
+	Message     string `plist:",omitempty"`
+	PhoneNumber string `plist:",omitempty"`
+}
+
+// NewDeviceLockCommand creates a new DeviceLock command.
+func NewDeviceLockCommand(pin string) *DeviceLockCommand {
+	return &DeviceLockCommand{RequestType: "DeviceLock", PIN: pin}
+}
+
+// EraseDeviceCommand is an "EraseDevice" command.
+// See https://developer.apple.com/documentation/devicemanagement/erasedevicecommand/command
+type EraseDeviceCommand struct {
+	RequestType string
+	// PIN is required for some macOS devices.
+	PIN                    string `plist:",omitempty"`
+	PreserveDataPlan       bool   `plist:",omitempty"`
+	DisallowProximitySetup bool   `plist:",omitempty"`
+	ObliterationBehavior   string `plist:",omitempty"`
+}
+
+// NewEraseDeviceCommand creates a new EraseDevice command.
+func NewEraseDeviceCommand(pin string) *EraseDeviceCommand {
+	return &EraseDeviceCommand{RequestType: "EraseDevice", PIN: pin}
+}
+
+// ClearPasscodeCommand is a "ClearPasscode" command.
+// See https://developer.apple.com/documentation/devicemanagement/clearpasscodecommand/command
+type ClearPasscodeCommand struct {
+	RequestType string
+	UnlockToken []byte
+}
+
+// NewClearPasscodeCommand creates a new ClearPasscode command using
+// the device's unlockToken (from its TokenUpdate check-in).
+func NewClearPasscodeCommand(unlockToken []byte) *ClearPasscodeCommand {
+	return &ClearPasscodeCommand{RequestType: "ClearPasscode", UnlockToken: unlockToken}
+}
+
+// RestartDeviceCommand is a "RestartDevice" command.
+// See https://developer.apple.com/documentation/devicemanagement/restartdevicecommand/command
+type RestartDeviceCommand struct {
+	RequestType        string
+	NotifyUser         bool `plist:",omitempty"`
+	RebuildKernelCache bool `plist:",omitempty"`
+}
+
+// NewRestartDeviceCommand creates a new RestartDevice command.
+func NewRestartDeviceCommand() *RestartDeviceCommand {
+	return &RestartDeviceCommand{RequestType: "RestartDevice"}
+}
+
+// OSUpdate is an OS update to schedule.
+// See https://developer.apple.com/documentation/devicemanagement/scheduleosupdatecommand/command/updatesitem
+type OSUpdate struct {
+	ProductKey       string `plist:",omitempty"`
+	ProductVersion   string `plist:",omitempty"`
+	InstallAction    string
+	MaxUserDeferrals int    `plist:",omitempty"`
+	Priority         string `plist:",omitempty"`
+}
+
+// ScheduleOSUpdateCommand is a "ScheduleOSUpdate" command.
+// See https://developer.apple.com/documentation/devicemanagement/scheduleosupdatecommand/command
+type ScheduleOSUpdateCommand struct {
+	RequestType string
+	Updates     []OSUpdate `plist:",omitempty"`
+}
+
+// NewScheduleOSUpdateCommand creates a new ScheduleOSUpdate command
+// for updates.
+func NewScheduleOSUpdateCommand(updates ...OSUpdate) *ScheduleOSUpdateCommand {
+	return &ScheduleOSUpdateCommand{RequestType: "ScheduleOSUpdate", Updates: updates}
+}
+
+// SettingsCommand is a "Settings" command.
+// See https://developer.apple.com/documentation/devicemanagement/settingscommand/command
+type SettingsCommand struct {
+	RequestType string
+	// Settings are the setting items. Each should marshal to a plist
+	// dictionary with an Item key (e.g. a map[string]interface{}).
+	Settings []interface{}
+}
+
+// NewSettingsCommand creates a new Settings command for settings.
+func NewSettingsCommand(settings ...interface{}) *SettingsCommand {
+	return &SettingsCommand{RequestType: "Settings", Settings: settings}
+}
@@ -0,0 +1,88 @@
+package mdm
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/groob/plist"
+)
+
+func TestNewCommandUUID(t *testing.T) {
+	uuid, err := NewCommandUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !re.MatchString(uuid) {
+		t.Errorf("invalid UUID: %q", uuid)
+	}
+}
+
+func TestNewCommand(t *testing.T) {
+	for _, test := range []struct {
+		requestType string
+		cmd         interface{}
+	}{
+		{"DeviceInformation", NewDeviceInformationCommand("UDID", "SerialNumber")},
+		{"SecurityInfo", NewSecurityInfoCommand()},
+		{"InstallProfile", NewInstallProfileCommand([]byte("<plist/>"))},
+		{"RemoveProfile", NewRemoveProfileCommand("com.example.profile")},
+		{"InstallApplication", NewInstallApplicationCommand("https://example.com/manifest.plist")},
+		{"InstalledApplicationList", NewInstalledApplicationListCommand("com.example.app")},
+		{"ProfileList", NewProfileListCommand()},
+		{"DeviceLock", NewDeviceLockCommand("123456")},
+		{"EraseDevice", NewEraseDeviceCommand("123456")},
+		{"ClearPasscode", NewClearPasscodeCommand([]byte{1, 2, 3})},
+		{"RestartDevice", NewRestartDeviceCommand()},
+		{"ScheduleOSUpdate", NewScheduleOSUpdateCommand(OSUpdate{ProductKey: "key", InstallAction: "InstallASAP"})},
+		{"Settings", NewSettingsCommand(map[string]interface{}{"Item": "DeviceName", "DeviceName": "test"})},
+	} {
+		test := test
+		t.Run(test.requestType, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := NewCommand("", test.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cmd.CommandUUID == "" {
+				t.Error("empty CommandUUID")
+			}
+			if msg, have, want := "incorrect RequestType", cmd.Command.RequestType, test.requestType; have != want {
+				t.Errorf("%s: %q, want: %q", msg, have, want)
+			}
+			if len(cmd.Raw) < 1 {
+				t.Fatal("empty Raw")
+			}
+			if _, ok := test.cmd.(*SettingsCommand); ok {
+				// settings decode into a different type
+				return
+			}
+			// decode the command back into its own type
+			want := reflect.ValueOf(test.cmd).Elem()
+			decoded := reflect.New(reflect.StructOf([]reflect.StructField{
+				{Name: "Command", Type: want.Type()},
+			}))
+			if err = plist.Unmarshal(cmd.Raw, decoded.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			if have := decoded.Elem().Field(0); !reflect.DeepEqual(have.Interface(), want.Interface()) {
+				t.Errorf("decoded command: have: %+v, want: %+v", have, want)
+			}
+		})
+	}
+}
+
+func TestNewCommandOmitEmpty(t *testing.T) {
+	cmd, err := NewCommand("CMD1", NewDeviceInformationCommand())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, have, want := "incorrect CommandUUID", cmd.CommandUUID, "CMD1"; have != want {
+		t.Errorf("%s: %q, want: %q", msg, have, want)
+	}
+	if bytes.Contains(cmd.Raw, []byte("Queries")) {
+		t.Error("empty Queries should be omitted")
+	}
+}
@@ -0,0 +1,382 @@
+package mdm
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/groob/plist"
+)
+
+// ErrNoResultsDecoder is returned when no results decoder is registered
+// for a command's RequestType.
+var ErrNoResultsDecoder = errors.New("no results decoder for request type")
+
+// ResultsDecoder decodes raw command results into a typed structure.
+// The returned value should embed CommandResults.
+type ResultsDecoder func(rawResults []byte) (interface{}, error)
+
+var (
+	resultsDecodersMu sync.RWMutex
+	resultsDecoders   = map[string]ResultsDecoder{
+		"DeviceInformation": func(b []byte) (interface{}, error) {
+			return resultsOrNil(DecodeDeviceInformationResults(b))
+		},
+		"SecurityInfo": func(b []byte) (interface{}, error) {
+			return resultsOrNil(DecodeSecurityInfoResults(b))
+		},
+		"InstalledApplicationList": func(b []byte) (interface{}, error) {
+			return resultsOrNil(DecodeInstalledApplicationListResults(b))
+		},
+		"ProfileList": func(b []byte) (interface{}, error) {
+			return resultsOrNil(DecodeProfileListResults(b))
+		},
+		"CertificateList": func(b []byte) (interface{}, error) {
+			return resultsOrNil(DecodeCertificateListResults(b))
+		},
+		"AvailableOSUpdates": func(b []byte) (interface{}, error) {
+			return resultsOrNil(DecodeAvailableOSUpdatesResults(b))
+		},
+	}
+)
+
+// resultsOrNil avoids returning typed nil pointers inside of a non-nil
+// interface when decoding fails.
+func resultsOrNil(results interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// RegisterResultsDecoder registers decoder for command results of
+// requestType, replacing any existing decoder. A nil decoder removes
+// the registration.
+func RegisterResultsDecoder(requestType string, decoder ResultsDecoder) {
+	resultsDecodersMu.Lock()
+	defer resultsDecodersMu.Unlock()
+	if decoder == nil {
+		delete(resultsDecoders, requestType)
+		return
+	}
+	resultsDecoders[requestType] = decoder
+}
+
+// DecodeTypedCommandResults decodes rawResults using the results
+// decoder registered for requestType. Devices do not always include the
+// RequestType in their results so callers should supply it from the
+// originally queued command. If requestType is empty then the
+// RequestType in rawResults (if any) is used.
+func DecodeTypedCommandResults(requestType string, rawResults []byte) (interface{}, error) {
+	if requestType == "" {
+		results, err := DecodeCommandResults(rawResults)
+		if err != nil {
+			return nil, err
+		}
+		requestType = results.RequestType
+	}
+	resultsDecodersMu.RLock()
+	decoder, ok := resultsDecoders[requestType]
+	resultsDecodersMu.RUnlock()
+	if !ok {
+		return nil, ErrNoResultsDecoder
+	}
+	return decoder(rawResults)
+}
+
+// decodeResults unmarshals rawResults into v which embeds results.
+func decodeResults(rawResults []byte, v interface{}, results *CommandResults) error {
+	if err := plist.Unmarshal(rawResults, v); err != nil {
+		return &ParseError{Err: err, Content: rawResults}
+	}
+	results.Raw = rawResults
+	if results.Status == "" {
+		return ErrInvalidCommandResult
+	}
+	return nil
+}
+
+// Number is a plist number that may be encoded as either an integer
+// or a real. Devices are not consistent in which they send.
+type Number float64
+
+// UnmarshalPlist decodes a plist integer or real into n.
+func (n *Number) UnmarshalPlist(f func(interface{}) error) error {
+	var r float64
+	if err := f(&r); err == nil {
+		*n = Number(r)
+		return nil
+	}
+	var i int64
+	if err := f(&i); err != nil {
+		return err
+	}
+	*n = Number(i)
+	return nil
+}
+
+// QueryResponses are the commonly returned DeviceInformation queries.
+// Queries not represented here are still available in the raw results.
+// Booleans are pointers as devices only include the queries they were
+// asked for (or that apply to them).
+// See https://developer.apple.com/documentation/devicemanagement/deviceinformationresponse/queryresponses
+type QueryResponses struct {
+	UDID                          string `plist:",omitempty"`
+	DeviceName                    string `plist:",omitempty"`
+	HostName                      string `plist:",omitempty"`
+	LocalHostName                 string `plist:",omitempty"`
+	OSVersion                     string `plist:",omitempty"`
+	BuildVersion                  string `plist:",omitempty"`
+	SupplementalBuildVersion      string `plist:",omitempty"`
+	SupplementalOSVersionExtra    string `plist:",omitempty"`
+	ProductName                   string `plist:",omitempty"`
+	Model                         string `plist:",omitempty"`
+	ModelName                     string `plist:",omitempty"`
+	ModelNumber                   string `plist:",omitempty"`
+	SerialNumber                  string `plist:",omitempty"`
+	DeviceCapacity                Number `plist:",omitempty"`
+	AvailableDeviceCapacity       Number `plist:",omitempty"`
+	BatteryLevel                  Number `plist:",omitempty"`
+	IMEI                          string `plist:",omitempty"`
+	MEID                          string `plist:",omitempty"`
+	ICCID                         string `plist:",omitempty"`
+	WiFiMAC                       string `plist:",omitempty"`
+	BluetoothMAC                  string `plist:",omitempty"`
+	EASDeviceIdentifier           string `plist:",omitempty"`
+	TimeZone                      string `plist:",omitempty"`
+	IsSupervised                  *bool  `plist:",omitempty"`
+	IsAppleSilicon                *bool  `plist:",omitempty"`
+	IsActivationLockEnabled       *bool  `plist:",omitempty"`
+	IsDeviceLocatorServiceEnabled *bool  `plist:",omitempty"`
+	IsDoNotDisturbInEffect        *bool  `plist:",omitempty"`
+	IsCloudBackupEnabled          *bool  `plist:",omitempty"`
+	IsMDMLostModeEnabled          *bool  `plist:",omitempty"`
+}
+
+// DeviceInformationResults are the results of a "DeviceInformation" command.
+// See https://developer.apple.com/documentation/devicemanagement/deviceinformationresponse
+type DeviceInformationResults struct {
+	CommandResults
+	QueryResponses QueryResponses
+}
+
+// DecodeDeviceInformationResults unmarshals rawResults into results.
+func DecodeDeviceInformationResults(rawResults []byte) (*DeviceInformationResults, error) {
+	results := new(DeviceInformationResults)
+	if err := decodeResults(rawResults, results, &results.CommandResults); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// FirewallSettings are the macOS firewall settings of a device.
+type FirewallSettings struct {
+	FirewallEnabled  bool
+	BlockAllIncoming bool
+	StealthMode      bool
+}
+
+// FirmwarePasswordStatus is the macOS firmware password status of a device.
+type FirmwarePasswordStatus struct {
+	PasswordExists bool
+	ChangePending  bool
+	AllowOroms     bool
+}
+
+// ManagementStatus is the management status of a device.
+type ManagementStatus struct {
+	EnrolledViaDEP         bool
+	IsUserEnrollment       bool
+	UserApprovedEnrollment bool
+}
+
+// SecureBoot is the secure boot configuration of a device.
+type SecureBoot struct {
+	SecureBootLevel   string `plist:",omitempty"`
+	ExternalBootLevel string `plist:",omitempty"`
+}
+
+// SecurityInfo is the security information of a device. Booleans are
+// pointers as devices omit those that do not apply to their platform.
+// See https://developer.apple.com/documentation/devicemanagement/securityinforesponse/securityinfo
+type SecurityInfo struct {
+	HardwareEncryptionCaps                           int
+	PasscodePresent                                  *bool
+	PasscodeCompliant                                *bool
+	PasscodeCompliantWithProfiles                    *bool
+	PasscodeLockGracePeriodEnforced                  int
+	FDEEnabled                                       *bool `plist:"FDE_Enabled"`
+	FDEHasPersonalRecoveryKey                        *bool `plist:"FDE_HasPersonalRecoveryKey"`
+	FDEHasInstitutionalRecoveryKey                   *bool `plist:"FDE_HasInstitutionalRecoveryKey"`
+	SystemIntegrityProtectionEnabled                 *bool
+	AuthenticatedRootVolumeEnabled                   *bool
+	IsRecoveryLockEnabled                            *bool
+	BootstrapTokenAllowedForAuthentication           string
+	BootstrapTokenRequiredForKernelExtensionApproval *bool
+	BootstrapTokenRequiredForSoftwareUpdate          *bool
+	FirewallSettings                                 *FirewallSettings       `plist:",omitempty"`
+	FirmwarePasswordStatus                           *FirmwarePasswordStatus `plist:",omitempty"`
+	ManagementStatus                                 *ManagementStatus       `plist:",omitempty"`
+	SecureBoot                                       *SecureBoot             `plist:",omitempty"`
+}
+
+// SecurityInfoResults are the results of a "SecurityInfo" command.
+// See https://developer.apple.com/documentation/devicemanagement/securityinforesponse
+type SecurityInfoResults struct {
+	CommandResults
+	SecurityInfo SecurityInfo
+}
+
+// DecodeSecurityInfoResults unmarshals rawResults into results.
+func DecodeSecurityInfoResults(rawResults []byte) (*SecurityInfoResults, error) {
+	results := new(SecurityInfoResults)
+	if err := decodeResults(rawResults, results, &results.CommandResults); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// InstalledApplication is an application installed on a device.
+// See https://developer.apple.com/documentation/devicemanagement/installedapplicationlistresponse/installedapplicationlistitem
+type InstalledApplication struct {
+	Identifier                string
+	Name                      string
+	ShortVersion              string
+	Version                   string
+	BundleSize                int64
+	DynamicSize               int64
+	ExternalVersionIdentifier int64
+	IsValidated               bool
+	AdHocCodeSigned           bool
+	AppStoreVendable          bool
+	BetaApp                   bool
+	DeviceBasedVPP            bool
+	HasUpdateAvailable        bool
+	Installing                bool
+}
+
+// InstalledApplicationListResults are the results of an
+// "InstalledApplicationList" command.
+// See https://developer.apple.com/documentation/devicemanagement/installedapplicationlistresponse
+type InstalledApplicationListResults struct {
+	CommandResults
+	InstalledApplicationList []InstalledApplication
+}
+
+// DecodeInstalledApplicationListResults unmarshals rawResults into results.
+func DecodeInstalledApplicationListResults(rawResults []byte) (*InstalledApplicationListResults, error) {
+	results := new(InstalledApplicationListResults)
+	if err := decodeResults(rawResults, results, &results.CommandResults); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ProfilePayload is a payload within an installed profile.
+type ProfilePayload struct {
+	PayloadType         string
+	PayloadIdentifier   string
+	PayloadUUID         string `plist:",omitempty"`
+	PayloadDisplayName  string `plist:",omitempty"`
+	PayloadDescription  string `plist:",omitempty"`
+	PayloadOrganization string `plist:",omitempty"`
+	PayloadVersion      int
+}
+
+// Profile is a profile installed on a device.
+// See https://developer.apple.com/documentation/devicemanagement/profilelistresponse/profilelistitem
+type Profile struct {
+	PayloadIdentifier        string
+	PayloadUUID              string
+	PayloadDisplayName       string `plist:",omitempty"`
+	PayloadDescription       string `plist:",omitempty"`
+	PayloadOrganization      string `plist:",omitempty"`
+	PayloadVersion           int
+	PayloadRemovalDisallowed bool
+	HasRemovalPasscode       bool
+	IsEncrypted              bool
+	IsManaged                bool
+	SignerCertificates       [][]byte `plist:",omitempty"`
+	PayloadContent           []ProfilePayload
+}
+
+// ProfileListResults are the results of a "ProfileList" command.
+// See https://developer.apple.com/documentation/devicemanagement/profilelistresponse
+type ProfileListResults struct {
+	CommandResults
+	ProfileList []Profile
+}
+
+// DecodeProfileListResults unmarshals rawResults into results.
+func DecodeProfileListResults(rawResults []byte) (*ProfileListResults, error) {
+	results := new(ProfileListResults)
+	if err := decodeResults(rawResults, results, &results.CommandResults); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Certificate is a certificate installed on a device.
+// See https://developer.apple.com/documentation/devicemanagement/certificatelistresponse/certificatelistitem
+type Certificate struct {
+	CommonName string
+	// Data is the DER-encoded certificate.
+	Data       []byte
+	IsIdentity bool
+}
+
+// CertificateListResults are the results of a "CertificateList" command.
+// See https://developer.apple.com/documentation/devicemanagement/certificatelistresponse
+type CertificateListResults struct {
+	CommandResults
+	CertificateList []Certificate
+}
+
+// DecodeCertificateListResults unmarshals rawResults into results.
+func DecodeCertificateListResults(rawResults []byte) (*CertificateListResults, error) {
+	results := new(CertificateListResults)
+	if err := decodeResults(rawResults, results, &results.CommandResults); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// AvailableOSUpdate is an OS update available to a device.
+// See https://developer.apple.com/documentation/devicemanagement/availableosupdatesresponse/availableosupdate
+type AvailableOSUpdate struct {
+	ProductKey                 string
+	ProductName                string `plist:",omitempty"`
+	HumanReadableName          string
+	HumanReadableNameLocale    string `plist:",omitempty"`
+	Version                    string
+	Build                      string `plist:",omitempty"`
+	SupplementalBuildVersion   string `plist:",omitempty"`
+	SupplementalOSVersionExtra string `plist:",omitempty"`
+	MetadataURL                string `plist:",omitempty"`
+	DownloadSize               int64
+	InstallSize                int64
+	AppIdentifiersToClose      []string `plist:",omitempty"`
+	AllowsInstallLater         bool
+	IsConfigDataUpdate         bool
+	IsCritical                 bool
+	IsFirmwareUpdate           bool
+	IsMajorOSUpdate            bool
+	IsSecurityResponse         bool
+	RequiresBootstrapToken     bool
+	RestartRequired            bool
+}
+
+// AvailableOSUpdatesResults are the results of an "AvailableOSUpdates" command.
+// See https://developer.apple.com/documentation/devicemanagement/availableosupdatesresponse
+type AvailableOSUpdatesResults struct {
+	CommandResults
+	AvailableOSUpdates []AvailableOSUpdate
+}
+
+// DecodeAvailableOSUpdatesResults unmarshals rawResults into results.
+func DecodeAvailableOSUpdatesResults(rawResults []byte) (*AvailableOSUpdatesResults, error) {
+	results := new(AvailableOSUpdatesResults)
+	if err := decodeResults(rawResults, results, &results.CommandResults); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
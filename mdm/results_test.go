@@ -0,0 +1,210 @@
+package mdm
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDecodeTypedCommandResults(t *testing.T) {
+	for _, test := range []struct {
+		filename    string
+		requestType string
+		check       func(*testing.T, interface{})
+	}{
+		{
+			"testdata/DeviceInformation.1.plist",
+			"", // RequestType is in the results
+			func(t *testing.T, v interface{}) {
+				r := v.(*DeviceInformationResults)
+				if have, want := r.QueryResponses.HostName, "fruit.example.com"; have != want {
+					t.Errorf("HostName: %q, want: %q", have, want)
+				}
+				if have, want := r.QueryResponses.UDID, "66ADE930-5FDF-5EC4-8429-15640684C489"; have != want {
+					t.Errorf("UDID: %q, want: %q", have, want)
+				}
+			},
+		},
+		{
+			"testdata/DeviceInformation.2.plist",
+			"DeviceInformation",
+			func(t *testing.T, v interface{}) {
+				r := v.(*DeviceInformationResults)
+				q := r.QueryResponses
+				if have, want := q.OSVersion, "12.6"; have != want {
+					t.Errorf("OSVersion: %q, want: %q", have, want)
+				}
+				if have, want := q.DeviceCapacity, Number(500); have != want {
+					t.Errorf("DeviceCapacity: %v, want: %v", have, want)
+				}
+				if have, want := q.AvailableDeviceCapacity, Number(201.53); have != want {
+					t.Errorf("AvailableDeviceCapacity: %v, want: %v", have, want)
+				}
+				if q.IsSupervised == nil || !*q.IsSupervised {
+					t.Errorf("IsSupervised: %v, want: true", q.IsSupervised)
+				}
+				if q.IsActivationLockEnabled != nil {
+					t.Errorf("IsActivationLockEnabled: %v, want: nil", *q.IsActivationLockEnabled)
+				}
+			},
+		},
+		{
+			"testdata/SecurityInfo.1.plist",
+			"SecurityInfo",
+			func(t *testing.T, v interface{}) {
+				r := v.(*SecurityInfoResults)
+				s := r.SecurityInfo
+				if s.FDEEnabled == nil || !*s.FDEEnabled {
+					t.Errorf("FDEEnabled: %v, want: true", s.FDEEnabled)
+				}
+				if s.FDEHasInstitutionalRecoveryKey == nil || *s.FDEHasInstitutionalRecoveryKey {
+					t.Errorf("FDEHasInstitutionalRecoveryKey: %v, want: false", s.FDEHasInstitutionalRecoveryKey)
+				}
+				if s.PasscodePresent != nil {
+					t.Errorf("PasscodePresent: %v, want: nil", *s.PasscodePresent)
+				}
+				if s.FirewallSettings == nil || !s.FirewallSettings.FirewallEnabled {
+					t.Errorf("incorrect FirewallSettings: %+v", s.FirewallSettings)
+				}
+				if s.ManagementStatus == nil || !s.ManagementStatus.EnrolledViaDEP {
+					t.Errorf("incorrect ManagementStatus: %+v", s.ManagementStatus)
+				}
+				if s.SecureBoot != nil {
+					t.Errorf("SecureBoot: %+v, want: nil", s.SecureBoot)
+				}
+			},
+		},
+		{
+			"testdata/InstalledApplicationList.1.plist",
+			"InstalledApplicationList",
+			func(t *testing.T, v interface{}) {
+				r := v.(*InstalledApplicationListResults)
+				if have, want := len(r.InstalledApplicationList), 1; have != want {
+					t.Fatalf("len: %d, want: %d", have, want)
+				}
+				app := r.InstalledApplicationList[0]
+				if have, want := app.Identifier, "com.example.app"; have != want {
+					t.Errorf("Identifier: %q, want: %q", have, want)
+				}
+				if have, want := app.BundleSize, int64(4735221); have != want {
+					t.Errorf("BundleSize: %d, want: %d", have, want)
+				}
+			},
+		},
+		{
+			"testdata/ProfileList.1.plist",
+			"ProfileList",
+			func(t *testing.T, v interface{}) {
+				r := v.(*ProfileListResults)
+				if have, want := len(r.ProfileList), 1; have != want {
+					t.Fatalf("len: %d, want: %d", have, want)
+				}
+				p := r.ProfileList[0]
+				if have, want := p.PayloadIdentifier, "com.example.mdm"; have != want {
+					t.Errorf("PayloadIdentifier: %q, want: %q", have, want)
+				}
+				if !p.IsManaged || !p.PayloadRemovalDisallowed {
+					t.Errorf("incorrect profile flags: %+v", p)
+				}
+				if have, want := len(p.PayloadContent), 1; have != want {
+					t.Fatalf("payloads: %d, want: %d", have, want)
+				}
+				if have, want := p.PayloadContent[0].PayloadType, "com.apple.wifi.managed"; have != want {
+					t.Errorf("PayloadType: %q, want: %q", have, want)
+				}
+			},
+		},
+		{
+			"testdata/CertificateList.1.plist",
+			"CertificateList",
+			func(t *testing.T, v interface{}) {
+				r := v.(*CertificateListResults)
+				if have, want := len(r.CertificateList), 1; have != want {
+					t.Fatalf("len: %d, want: %d", have, want)
+				}
+				c := r.CertificateList[0]
+				if have, want := c.CommonName, "Example Identity"; have != want {
+					t.Errorf("CommonName: %q, want: %q", have, want)
+				}
+				if !c.IsIdentity || len(c.Data) == 0 {
+					t.Errorf("incorrect certificate: %+v", c)
+				}
+			},
+		},
+		{
+			"testdata/AvailableOSUpdates.1.plist",
+			"AvailableOSUpdates",
+			func(t *testing.T, v interface{}) {
+				r := v.(*AvailableOSUpdatesResults)
+				if have, want := len(r.AvailableOSUpdates), 1; have != want {
+					t.Fatalf("len: %d, want: %d", have, want)
+				}
+				u := r.AvailableOSUpdates[0]
+				if have, want := u.Version, "12.6.1"; have != want {
+					t.Errorf("Version: %q, want: %q", have, want)
+				}
+				if have, want := u.DownloadSize, int64(1027604480); have != want {
+					t.Errorf("DownloadSize: %d, want: %d", have, want)
+				}
+				if !u.RestartRequired || !u.AllowsInstallLater {
+					t.Errorf("incorrect update flags: %+v", u)
+				}
+			},
+		},
+	} {
+		test := test
+		t.Run(test.filename, func(t *testing.T) {
+			b, err := ioutil.ReadFile(test.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := DecodeTypedCommandResults(test.requestType, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v == nil {
+				t.Fatal("nil results")
+			}
+			test.check(t, v)
+		})
+	}
+}
+
+func TestDecodeTypedCommandResultsRegistry(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/DeviceInformation.1.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = DecodeTypedCommandResults("TestRequestType", b)
+	if !errors.Is(err, ErrNoResultsDecoder) {
+		t.Fatalf("expected ErrNoResultsDecoder, got: %v", err)
+	}
+
+	RegisterResultsDecoder("TestRequestType", func(b []byte) (interface{}, error) {
+		return DecodeCommandResults(b)
+	})
+	defer RegisterResultsDecoder("TestRequestType", nil)
+
+	v, err := DecodeTypedCommandResults("TestRequestType", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := v.(*CommandResults)
+	if !ok {
+		t.Fatalf("unexpected type: %T", v)
+	}
+	if have, want := r.Status, "Acknowledged"; have != want {
+		t.Errorf("Status: %q, want: %q", have, want)
+	}
+
+	// invalid (empty Status) results should not return a typed value
+	v, err = DecodeTypedCommandResults("SecurityInfo", []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>CommandUUID</key><string>x</string></dict></plist>`))
+	if !errors.Is(err, ErrInvalidCommandResult) {
+		t.Errorf("expected ErrInvalidCommandResult, got: %v", err)
+	}
+	if v != nil {
+		t.Errorf("expected nil results, got: %#v", v)
+	}
+}
@@ -0,0 +1,231 @@
+// Package apnstest provides an in-process APNs server for testing.
+// It implements the HTTP/2 "/3/device/{token}" push API over TLS,
+// records the pushes it receives, and responds to pushes as scripted
+// by the test.
+package apnstest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+	"golang.org/x/net/http2"
+)
+
+// Push is a push notification received by the Server.
+type Push struct {
+	// Token is the hex-encoded device token from the request path.
+	Token string
+	// Topic is the apns-topic header or, if missing, the APNs topic of
+	// the client certificate.
+	Topic string
+	// PushMagic is the "mdm" value of the push payload.
+	PushMagic string
+	// Expiration is the apns-expiration header. It is the zero time
+	// if the header was missing.
+	Expiration time.Time
+	// APNsID is the apns-id sent in the response, if any.
+	APNsID string
+}
+
+// Response is a scripted response to a push.
+type Response struct {
+	// StatusCode is the HTTP status code. Zero means 200.
+	StatusCode int
+	// Reason is the APNs failure reason sent in the JSON error body
+	// (e.g. "BadDeviceToken") for non-200 responses.
+	Reason string
+	// GoAway sends an HTTP/2 GOAWAY frame (with Reason as the debug
+	// data) and closes the connection instead of sending a response.
+	GoAway bool
+	// APNsID is the apns-id header sent. One is generated if empty.
+	APNsID string
+}
+
+// BadDeviceToken is a 400 BadDeviceToken response.
+func BadDeviceToken() *Response {
+	return &Response{StatusCode: http.StatusBadRequest, Reason: "BadDeviceToken"}
+}
+
+// Unregistered is a 410 Unregistered response.
+func Unregistered() *Response {
+	return &Response{StatusCode: http.StatusGone, Reason: "Unregistered"}
+}
+
+// TooManyRequests is a 429 TooManyRequests response.
+func TooManyRequests() *Response {
+	return &Response{StatusCode: http.StatusTooManyRequests, Reason: "TooManyRequests"}
+}
+
+// GoAway is an HTTP/2 GOAWAY with a Shutdown reason.
+func GoAway() *Response {
+	return &Response{GoAway: true, Reason: "Shutdown"}
+}
+
+type connContextKey struct{}
+
+// Server is an in-process HTTP/2 TLS APNs server.
+// Pushes to tokens without a scripted response succeed.
+type Server struct {
+	// URL is the base URL of the server for use as the APNs base URL.
+	URL string
+
+	server *httptest.Server
+
+	mu        sync.Mutex
+	pushes    []*Push
+	responses map[string]*Response
+}
+
+// NewServer starts and returns a new Server.
+// The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{responses: make(map[string]*Response)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/3/device/", s.handlePush)
+	s.server = httptest.NewUnstartedServer(mux)
+	s.server.EnableHTTP2 = true
+	s.server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	s.server.Config.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, connContextKey{}, c)
+	}
+	s.server.StartTLS()
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// NewHTTPClient returns a new HTTP client that trusts the server's
+// TLS certificate. Each client has its own transport, so it can be
+// configured (e.g. with nanopush.ClientWithCert) independently.
+func (s *Server) NewHTTPClient() *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.server.Certificate())
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+}
+
+// SetResponse scripts the response to pushes to token (hex-encoded).
+// The response is used for every push to token until changed.
+// A nil response restores the default (successful) response.
+func (s *Server) SetResponse(token string, resp *Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resp == nil {
+		delete(s.responses, token)
+	} else {
+		s.responses[token] = resp
+	}
+}
+
+// Pushes returns the pushes received so far, oldest first.
+func (s *Server) Pushes() []*Push {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Push(nil), s.pushes...)
+}
+
+// Reset clears the received pushes and scripted responses.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pushes = nil
+	s.responses = make(map[string]*Response)
+}
+
+func newAPNsID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
+}
+
+func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	p := &Push{
+		Token: strings.TrimPrefix(r.URL.Path, "/3/device/"),
+		Topic: r.Header.Get("apns-topic"),
+	}
+	if p.Topic == "" && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		p.Topic, _ = cryptoutil.TopicFromCert(r.TLS.PeerCertificates[0])
+	}
+	if exp := r.Header.Get("apns-expiration"); exp != "" {
+		if unix, err := strconv.ParseInt(exp, 10, 64); err == nil && unix > 0 {
+			p.Expiration = time.Unix(unix, 0)
+		}
+	}
+	var payload struct {
+		MDM string `json:"mdm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err == nil {
+		p.PushMagic = payload.MDM
+	}
+
+	s.mu.Lock()
+	resp := s.responses[p.Token]
+	if resp == nil {
+		resp = new(Response)
+	}
+	if !resp.GoAway {
+		p.APNsID = resp.APNsID
+		if p.APNsID == "" {
+			p.APNsID = newAPNsID()
+		}
+	}
+	s.pushes = append(s.pushes, p)
+	s.mu.Unlock()
+
+	if resp.GoAway {
+		s.goAway(r, resp.Reason)
+		return
+	}
+	w.Header().Set("apns-id", p.APNsID)
+	if resp.StatusCode == 0 || resp.StatusCode == http.StatusOK {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reason":    resp.Reason,
+		"timestamp": time.Now().UnixMilli(),
+	})
+}
+
+// goAway writes a GOAWAY frame directly to the connection of r and
+// closes it. The last stream ID is the maximum so that clients will
+// not retry the request on a new connection.
+func (s *Server) goAway(r *http.Request, reason string) {
+	conn, ok := r.Context().Value(connContextKey{}).(net.Conn)
+	if !ok {
+		return
+	}
+	var debugData []byte
+	if reason != "" {
+		debugData, _ = json.Marshal(map[string]string{"reason": reason})
+	}
+	http2.NewFramer(conn, nil).WriteGoAway(math.MaxInt32, http2.ErrCodeNo, debugData)
+	conn.Close()
+}
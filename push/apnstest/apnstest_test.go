@@ -0,0 +1,138 @@
+package apnstest_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/push/apnstest"
+	"github.com/micromdm/nanomdm/push/nanopush"
+)
+
+const topic = "com.apple.mgmt.External.1b9d3f6a-2c4e-4a8b-9d7f-0e2c4a6b8d1f"
+
+func newProvider(t *testing.T, srv *apnstest.Server, opts ...nanopush.Option) push.PushProvider {
+	certPEM, keyPEM := apnstest.NewPushCert(t, topic, 1, time.Now().Add(time.Hour))
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]nanopush.Option{
+		nanopush.WithBaseURL(srv.URL),
+		nanopush.WithNewClient(func(cert *tls.Certificate) (*http.Client, error) {
+			return nanopush.ClientWithCert(srv.NewHTTPClient(), cert)
+		}),
+	}, opts...)
+	prov, err := nanopush.NewFactory(opts...).NewPushProvider(&cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prov
+}
+
+func newPush(token, pushMagic string) *mdm.Push {
+	p := &mdm.Push{PushMagic: pushMagic, Topic: topic}
+	p.SetTokenString(token)
+	return p
+}
+
+func TestServer(t *testing.T) {
+	srv := apnstest.NewServer()
+	defer srv.Close()
+	prov := newProvider(t, srv, nanopush.WithExpiration(time.Hour))
+
+	const (
+		okToken     = "c2732227a1d8021cfaf781d71fb2f908c61f5861079a00954a5453f1d0281433"
+		badToken    = "7f1839ca30d5c6d36d6ae426258c4306c14eca90afd709a07375a85ad5a11c69"
+		goneToken   = "0a1b2c3d"
+		busyToken   = "4e5f6a7b"
+		goAwayToken = "8c9d0e1f"
+	)
+	srv.SetResponse(badToken, apnstest.BadDeviceToken())
+	srv.SetResponse(goneToken, apnstest.Unregistered())
+	srv.SetResponse(busyToken, apnstest.TooManyRequests())
+
+	resps, err := prov.Push(context.Background(), []*mdm.Push{
+		newPush(okToken, "magic-ok"),
+		newPush(badToken, "magic-bad"),
+		newPush(goneToken, "magic-gone"),
+		newPush(busyToken, "magic-busy"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := resps[okToken]; resp == nil || resp.Err != nil || resp.Id == "" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	for token, want := range map[string]int{
+		badToken:  http.StatusBadRequest,
+		goneToken: http.StatusGone,
+		busyToken: http.StatusTooManyRequests,
+	} {
+		var httpErr *nanopush.HTTPError
+		if resp := resps[token]; resp == nil || !errors.As(resp.Err, &httpErr) {
+			t.Errorf("%s: expected HTTPError: %+v", token, resp)
+		} else if have := httpErr.StatusCode; have != want {
+			t.Errorf("%s: status: have: %d, want: %d", token, have, want)
+		}
+	}
+	if have, want := push.BadTokenReason(resps[badToken].Err), push.ReasonBadDeviceToken; have != want {
+		t.Errorf("reason: have: %q, want: %q", have, want)
+	}
+
+	pushes := srv.Pushes()
+	if have, want := len(pushes), 4; have != want {
+		t.Fatalf("pushes: have: %d, want: %d", have, want)
+	}
+	for _, p := range pushes {
+		if have, want := p.Topic, topic; have != want {
+			t.Errorf("topic: have: %q, want: %q", have, want)
+		}
+		if p.Token == okToken {
+			if have, want := p.PushMagic, "magic-ok"; have != want {
+				t.Errorf("push magic: have: %q, want: %q", have, want)
+			}
+			if have, want := p.APNsID, resps[okToken].Id; have != want {
+				t.Errorf("apns-id: have: %q, want: %q", have, want)
+			}
+		}
+		if d := time.Until(p.Expiration); d < 58*time.Minute || d > time.Hour {
+			t.Errorf("unexpected expiration: %s", p.Expiration)
+		}
+	}
+
+	// a GOAWAY is reported as a temporary error and the provider
+	// recovers on a new connection
+	srv.Reset()
+	srv.SetResponse(goAwayToken, apnstest.GoAway())
+	resps, err = prov.Push(context.Background(), []*mdm.Push{newPush(goAwayToken, "magic")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var goAwayErr *nanopush.GoAwayError
+	if resp := resps[goAwayToken]; resp == nil || !errors.As(resp.Err, &goAwayErr) {
+		t.Fatalf("expected GoAwayError: %+v", resp)
+	}
+	if !goAwayErr.Temporary() {
+		t.Error("expected temporary error")
+	}
+	var jsonErr *nanopush.JSONPushError
+	if !errors.As(goAwayErr, &jsonErr) || jsonErr.Reason != "Shutdown" {
+		t.Errorf("reason: have: %v, want: Shutdown", goAwayErr)
+	}
+	resps, err = prov.Push(context.Background(), []*mdm.Push{newPush(okToken, "magic")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := resps[okToken]; resp == nil || resp.Err != nil {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if have, want := len(srv.Pushes()), 2; have != want {
+		t.Errorf("pushes: have: %d, want: %d", have, want)
+	}
+}
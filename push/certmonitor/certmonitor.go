@@ -0,0 +1,154 @@
+// Package certmonitor periodically checks stored APNs push
+// certificates for upcoming expiry.
+package certmonitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+const (
+	// DefaultInterval is the default interval between checks.
+	DefaultInterval = 12 * time.Hour
+
+	// DefaultWindow is the default window before expiry in which push
+	// certificates are reported.
+	DefaultWindow = 30 * 24 * time.Hour
+)
+
+// TopicPushCertExpiring is the event topic for push certificates that
+// expire within the window (or have expired).
+const TopicPushCertExpiring = "nanomdm.PushCertExpiring"
+
+// Event statuses.
+const (
+	StatusExpiring = "Expiring"
+	StatusExpired  = "Expired"
+)
+
+// Monitor periodically reports push certificates that expire within
+// the window.
+type Monitor struct {
+	store    storage.PushCertLister
+	notifier service.EventNotifier
+	logger   log.Logger
+	interval time.Duration
+	window   time.Duration
+	now      func() time.Time
+}
+
+type Option func(*Monitor)
+
+// WithLogger sets the logger.
+func WithLogger(logger log.Logger) Option {
+	return func(m *Monitor) {
+		m.logger = logger
+	}
+}
+
+// WithInterval sets the interval between checks.
+func WithInterval(interval time.Duration) Option {
+	return func(m *Monitor) {
+		m.interval = interval
+	}
+}
+
+// WithWindow sets the window before expiry in which push certificates
+// are reported.
+func WithWindow(window time.Duration) Option {
+	return func(m *Monitor) {
+		m.window = window
+	}
+}
+
+// WithNotifier sends an event to notifier for each push certificate
+// that expires within the window.
+func WithNotifier(notifier service.EventNotifier) Option {
+	return func(m *Monitor) {
+		m.notifier = notifier
+	}
+}
+
+// New creates a new Monitor.
+func New(store storage.PushCertLister, opts ...Option) *Monitor {
+	m := &Monitor{
+		store:    store,
+		logger:   log.NopLogger,
+		interval: DefaultInterval,
+		window:   DefaultWindow,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Run checks immediately and then every interval until ctx is done.
+func (m *Monitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Check(ctx); err != nil {
+			ctxlog.Logger(ctx, m.logger).Info(
+				"msg", "check push cert expiry",
+				"err", err,
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check logs and, if configured, sends an event to the notifier for
+// each push certificate that expires within the window.
+func (m *Monitor) Check(ctx context.Context) error {
+	infos, err := m.store.ListPushCerts(ctx)
+	if err != nil {
+		return err
+	}
+	logger := ctxlog.Logger(ctx, m.logger)
+	now := m.now()
+	for _, info := range infos {
+		if info.NotAfter.After(now.Add(m.window)) {
+			continue
+		}
+		status := StatusExpiring
+		if !info.NotAfter.After(now) {
+			status = StatusExpired
+		}
+		logger.Info(
+			"msg", "push cert expiring",
+			"topic", info.Topic,
+			"status", status,
+			"not_after", info.NotAfter.Format(time.RFC3339),
+			"days", int(info.NotAfter.Sub(now).Hours()/24),
+		)
+		if m.notifier == nil {
+			continue
+		}
+		err = m.notifier.NotifyEvent(ctx, &service.Event{
+			Topic:     TopicPushCertExpiring,
+			Status:    status,
+			PushTopic: info.Topic,
+			NotAfter:  info.NotAfter,
+		})
+		if err != nil {
+			logger.Info(
+				"msg", "notify push cert expiring",
+				"topic", info.Topic,
+				"err", err,
+			)
+		}
+	}
+	return nil
+}
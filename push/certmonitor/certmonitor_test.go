@@ -0,0 +1,58 @@
+package certmonitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+type testStore struct {
+	infos []*storage.PushCertInfo
+}
+
+func (s *testStore) ListPushCerts(_ context.Context) ([]*storage.PushCertInfo, error) {
+	return s.infos, nil
+}
+
+type testNotifier struct {
+	events []*service.Event
+}
+
+func (n *testNotifier) NotifyEvent(_ context.Context, ev *service.Event) error {
+	n.events = append(n.events, ev)
+	return nil
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Now()
+	store := &testStore{infos: []*storage.PushCertInfo{
+		{Topic: "topic.ok", NotAfter: now.Add(60 * 24 * time.Hour)},
+		{Topic: "topic.expiring", NotAfter: now.Add(10 * 24 * time.Hour)},
+		{Topic: "topic.expired", NotAfter: now.Add(-time.Hour)},
+	}}
+	notifier := new(testNotifier)
+	m := New(store, WithNotifier(notifier), WithWindow(30*24*time.Hour))
+	m.now = func() time.Time { return now }
+
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(notifier.events), 2; have != want {
+		t.Fatalf("events: have: %d, want: %d", have, want)
+	}
+	for i, want := range []struct {
+		topic  string
+		status string
+	}{
+		{"topic.expiring", StatusExpiring},
+		{"topic.expired", StatusExpired},
+	} {
+		ev := notifier.events[i]
+		if ev.Topic != TopicPushCertExpiring || ev.PushTopic != want.topic || ev.Status != want.status || ev.NotAfter.IsZero() {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	}
+}
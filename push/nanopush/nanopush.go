@@ -4,10 +4,13 @@ package nanopush
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/micromdm/nanomdm/cryptoutil"
 	"github.com/micromdm/nanomdm/push"
 	"golang.org/x/net/http2"
 )
@@ -16,6 +19,8 @@ import (
 type NewClient func(*tls.Certificate) (*http.Client, error)
 
 // ClientWithCert configures an mTLS client cert on the HTTP client.
+// Any existing TLS configuration of the client's transport (such as
+// custom root CAs) is kept.
 func ClientWithCert(client *http.Client, cert *tls.Certificate) (*http.Client, error) {
 	if cert == nil {
 		return client, errors.New("no cert provided")
@@ -24,13 +29,20 @@ func ClientWithCert(client *http.Client, cert *tls.Certificate) (*http.Client, e
 		clone := *http.DefaultClient
 		client = &clone
 	}
-	config := &tls.Config{
-		Certificates: []tls.Certificate{*cert},
-	}
 	if client.Transport == nil {
 		client.Transport = &http.Transport{}
 	}
-	transport := client.Transport.(*http.Transport)
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return client, errors.New("client transport is not an *http.Transport")
+	}
+	var config *tls.Config
+	if transport.TLSClientConfig != nil {
+		config = transport.TLSClientConfig.Clone()
+	} else {
+		config = new(tls.Config)
+	}
+	config.Certificates = []tls.Certificate{*cert}
 	transport.TLSClientConfig = config
 	// force HTTP/2
 	err := http2.ConfigureTransport(transport)
@@ -46,6 +58,8 @@ type Factory struct {
 	newClient  NewClient
 	expiration time.Duration
 	workers    int
+	baseURL    string
+	topicURLs  map[string]string
 }
 
 type Option func(*Factory)
@@ -72,11 +86,52 @@ func WithWorkers(workers int) Option {
 	}
 }
 
+// WithBaseURL sets the APNs base URL (e.g. Development). The default
+// is Production.
+func WithBaseURL(baseURL string) Option {
+	return func(f *Factory) {
+		f.baseURL = baseURL
+	}
+}
+
+// WithTopicBaseURL sets the APNs base URL for the push certificate
+// topic. This overrides the base URL set with WithBaseURL and allows
+// e.g. Development and Production topics to be used side by side.
+func WithTopicBaseURL(topic, baseURL string) Option {
+	return func(f *Factory) {
+		if f.topicURLs == nil {
+			f.topicURLs = make(map[string]string)
+		}
+		f.topicURLs[topic] = baseURL
+	}
+}
+
+// BaseURL returns the APNs base URL for the named environment:
+// "production" or "development". If port2197 is true the base URL for
+// the alternate APNs port 2197 is returned instead.
+func BaseURL(env string, port2197 bool) (string, error) {
+	switch env {
+	case "production":
+		if port2197 {
+			return Production2197, nil
+		}
+		return Production, nil
+	case "development":
+		if port2197 {
+			return Development2197, nil
+		}
+		return Development, nil
+	default:
+		return "", fmt.Errorf("invalid APNs environment: %q", env)
+	}
+}
+
 // NewFactory creates a new Factory.
 func NewFactory(opts ...Option) *Factory {
 	f := &Factory{
 		newClient: defaultNewClient,
 		workers:   5,
+		baseURL:   Production,
 	}
 	for _, opt := range opts {
 		opt(f)
@@ -89,9 +144,37 @@ func (f *Factory) NewPushProvider(cert *tls.Certificate) (push.PushProvider, err
 	p := &Provider{
 		expiration: f.expiration,
 		workers:    f.workers,
-		baseURL:    Production,
+		baseURL:    f.baseURL,
+	}
+	if len(f.topicURLs) > 0 {
+		topic, err := certTopic(cert)
+		if err != nil {
+			return nil, fmt.Errorf("push cert topic: %w", err)
+		}
+		if baseURL, ok := f.topicURLs[topic]; ok {
+			p.baseURL = baseURL
+		}
 	}
 	var err error
 	p.client, err = f.newClient(cert)
 	return p, err
 }
+
+// certTopic returns the APNs topic of cert.
+func certTopic(cert *tls.Certificate) (string, error) {
+	if cert == nil {
+		return "", errors.New("no cert provided")
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) < 1 {
+			return "", errors.New("no certificate data")
+		}
+		var err error
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return "", err
+		}
+	}
+	return cryptoutil.TopicFromCert(leaf)
+}
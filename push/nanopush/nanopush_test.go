@@ -0,0 +1,65 @@
+package nanopush
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/push/apnstest"
+)
+
+func TestBaseURL(t *testing.T) {
+	for _, tc := range []struct {
+		env      string
+		port2197 bool
+		url      string
+	}{
+		{"production", false, Production},
+		{"production", true, Production2197},
+		{"development", false, Development},
+		{"development", true, Development2197},
+		{"sandbox", false, ""},
+	} {
+		url, err := BaseURL(tc.env, tc.port2197)
+		if have, want := url, tc.url; have != want {
+			t.Errorf("%s: have: %q, want: %q", tc.env, have, want)
+		}
+		if have, want := err != nil, tc.url == ""; have != want {
+			t.Errorf("%s: err: %v", tc.env, err)
+		}
+	}
+}
+
+func TestTopicBaseURL(t *testing.T) {
+	const (
+		topic1 = "com.apple.mgmt.External.1b9d3f6a-2c4e-4a8b-9d7f-0e2c4a6b8d1f"
+		topic2 = "com.apple.mgmt.External.5e7a9c1b-3d5f-4b7a-8c9e-1f3a5c7e9b2d"
+	)
+	f := NewFactory(
+		WithBaseURL(Production2197),
+		WithTopicBaseURL(topic1, Development2197),
+		WithWorkers(3),
+		WithExpiration(time.Hour),
+	)
+	for topic, want := range map[string]string{
+		topic1: Development2197,
+		topic2: Production2197,
+	} {
+		certPEM, keyPEM := apnstest.NewPushCert(t, topic, 1, time.Now().Add(time.Hour))
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prov, err := f.NewPushProvider(&cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := prov.(*Provider)
+		if have := p.baseURL; have != want {
+			t.Errorf("%s: base URL: have: %q, want: %q", topic, have, want)
+		}
+		if p.workers != 3 || p.expiration != time.Hour {
+			t.Errorf("%s: unexpected provider: %+v", topic, p)
+		}
+	}
+}
@@ -58,12 +58,75 @@ func (e *JSONPushError) Error() string {
 	return s
 }
 
-func newError(body io.Reader, statusCode int) error {
+// HTTPError is an unsuccessful HTTP response from the APNs service.
+type HTTPError struct {
+	StatusCode int
+	// Err is usually a *JSONPushError.
+	Err error
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("push HTTP status: %d: %v", e.StatusCode, e.Err)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// FailureStatusCode returns the HTTP status code.
+func (e *HTTPError) FailureStatusCode() int {
+	return e.StatusCode
+}
+
+// FailureReason returns the APNs failure reason, if any.
+func (e *HTTPError) FailureReason() string {
+	var jsonErr *JSONPushError
+	if errors.As(e.Err, &jsonErr) && jsonErr != nil {
+		return jsonErr.Reason
+	}
+	return ""
+}
+
+// Temporary reports whether the push may succeed if retried later.
+// This is true for throttling (429) and server (5xx) errors. Other
+// errors such as a bad device token (400) or an unregistered device
+// token (410) are permanent.
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// GoAwayError is an HTTP/2 GOAWAY frame received from the APNs service.
+// APNs sends these when it closes a connection (for example during
+// maintenance) and the push may succeed if retried on a new connection.
+type GoAwayError struct {
+	http2.GoAwayError
+	// Err is the decoded debug data, usually a *JSONPushError.
+	Err error
+}
+
+func (e *GoAwayError) Error() string {
+	return fmt.Sprintf("push HTTP/2 GOAWAY: %s: %v", e.ErrCode, e.Err)
+}
+
+func (e *GoAwayError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary always reports true for GOAWAY errors.
+func (e *GoAwayError) Temporary() bool {
+	return true
+}
+
+func decodeError(body io.Reader) error {
 	var err error = new(JSONPushError)
 	if decodeErr := json.NewDecoder(body).Decode(err); decodeErr != nil {
 		err = fmt.Errorf("decoding JSON push error: %w", decodeErr)
 	}
-	return fmt.Errorf("push HTTP status: %d: %w", statusCode, err)
+	return err
+}
+
+func newError(body io.Reader, statusCode int) error {
+	return &HTTPError{StatusCode: statusCode, Err: decodeError(body)}
 }
 
 // do performs the HTTP push request
@@ -85,8 +148,9 @@ func (p *Provider) do(ctx context.Context, pushInfo *mdm.Push) *push.Response {
 	r, err := p.client.Do(req)
 	var goAwayErr http2.GoAwayError
 	if errors.As(err, &goAwayErr) {
+		// there is no HTTP response (r is nil) with a GOAWAY
 		body := strings.NewReader(goAwayErr.DebugData)
-		return &push.Response{Err: newError(body, r.StatusCode)}
+		return &push.Response{Err: &GoAwayError{GoAwayError: goAwayErr, Err: decodeError(body)}}
 	} else if err != nil {
 		return &push.Response{Err: err}
 	}
@@ -116,9 +180,12 @@ func (p *Provider) pushSerial(ctx context.Context, pushInfos []*mdm.Push) (map[s
 func (p *Provider) pushConcurrent(ctx context.Context, pushInfos []*mdm.Push) (map[string]*push.Response, error) {
 	// don't start more workers than we have pushes to send
 	workers := p.workers
-	if len(pushInfos) > workers {
+	if workers > len(pushInfos) {
 		workers = len(pushInfos)
 	}
+	if workers < 1 {
+		workers = 1
+	}
 
 	// response associates push.Response with token
 	type response struct {
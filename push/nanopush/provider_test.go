@@ -3,12 +3,18 @@ package nanopush
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"golang.org/x/net/http2"
 )
 
 func TestPush(t *testing.T) {
@@ -108,3 +114,129 @@ func testPushDevices(t *testing.T, input [][]string) {
 	}
 
 }
+
+func TestPushErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status    int
+		reason    string
+		temporary bool
+		badToken  bool
+	}{
+		{http.StatusBadRequest, "BadDeviceToken", false, true},
+		{http.StatusBadRequest, "BadTopic", false, false},
+		{http.StatusGone, "Unregistered", false, true},
+		{http.StatusTooManyRequests, "TooManyRequests", true, false},
+		{http.StatusServiceUnavailable, "ServiceUnavailable", true, false},
+	} {
+		t.Run(tc.reason, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(`{"reason":"` + tc.reason + `"}`))
+			}))
+			defer server.Close()
+
+			prov := &Provider{
+				baseURL: server.URL,
+				client:  http.DefaultClient,
+			}
+			pushInfo := &mdm.Push{PushMagic: "47250C9C-1B37-4381-98A9-0B8315A441C7"}
+			pushInfo.SetTokenString("c2732227a1d8021cfaf781d71fb2f908c61f5861079a00954a5453f1d0281433")
+
+			resp := prov.do(context.Background(), pushInfo)
+			var httpErr *HTTPError
+			if !errors.As(resp.Err, &httpErr) {
+				t.Fatalf("expected HTTPError, got: %v", resp.Err)
+			}
+			if have, want := httpErr.StatusCode, tc.status; have != want {
+				t.Errorf("status: have: %d, want: %d", have, want)
+			}
+			if have, want := httpErr.Temporary(), tc.temporary; have != want {
+				t.Errorf("temporary: have: %v, want: %v", have, want)
+			}
+			var jsonErr *JSONPushError
+			if !errors.As(resp.Err, &jsonErr) || jsonErr.Reason != tc.reason {
+				t.Errorf("reason: have: %v, want: %s", resp.Err, tc.reason)
+			}
+			if have, want := push.BadTokenReason(resp.Err) != "", tc.badToken; have != want {
+				t.Errorf("bad token: have: %v, want: %v", have, want)
+			}
+		})
+	}
+}
+
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestPushGoAway(t *testing.T) {
+	prov := &Provider{
+		baseURL: "https://example.com",
+		client: doerFunc(func(r *http.Request) (*http.Response, error) {
+			return nil, &url.Error{Op: "Post", URL: r.URL.String(), Err: http2.GoAwayError{
+				ErrCode:   http2.ErrCodeNo,
+				DebugData: `{"reason":"Shutdown"}`,
+			}}
+		}),
+	}
+	pushInfo := &mdm.Push{PushMagic: "47250C9C-1B37-4381-98A9-0B8315A441C7"}
+	pushInfo.SetTokenString("c2732227a1d8021cfaf781d71fb2f908c61f5861079a00954a5453f1d0281433")
+
+	resp := prov.do(context.Background(), pushInfo)
+	var goAwayErr *GoAwayError
+	if !errors.As(resp.Err, &goAwayErr) {
+		t.Fatalf("expected GoAwayError, got: %v", resp.Err)
+	}
+	if !goAwayErr.Temporary() {
+		t.Error("expected temporary error")
+	}
+	var jsonErr *JSONPushError
+	if !errors.As(resp.Err, &jsonErr) || jsonErr.Reason != "Shutdown" {
+		t.Errorf("reason: have: %v, want: Shutdown", resp.Err)
+	}
+}
+
+func TestPushWorkers(t *testing.T) {
+	var mu sync.Mutex
+	var active, maxActive, ct int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		ct++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	prov := &Provider{
+		baseURL: server.URL,
+		client:  http.DefaultClient,
+		workers: 3,
+	}
+	var pushInfos []*mdm.Push
+	for i := 0; i < 12; i++ {
+		pushInfo := &mdm.Push{PushMagic: "47250C9C-1B37-4381-98A9-0B8315A441C7"}
+		pushInfo.Token = []byte{byte(i)}
+		pushInfos = append(pushInfos, pushInfo)
+	}
+	resps, err := prov.Push(context.Background(), pushInfos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(resps), 12; have != want {
+		t.Errorf("responses: have: %d, want: %d", have, want)
+	}
+	if have, want := ct, 12; have != want {
+		t.Errorf("requests: have: %d, want: %d", have, want)
+	}
+	if maxActive > 3 {
+		t.Errorf("concurrent requests: have: %d, want at most: 3", maxActive)
+	}
+}
@@ -5,13 +5,55 @@ package push
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"net/http"
 
 	"github.com/micromdm/nanomdm/mdm"
 )
 
+// APNs failure reasons for push tokens that are no longer valid.
+const (
+	ReasonBadDeviceToken = "BadDeviceToken"
+	ReasonUnregistered   = "Unregistered"
+)
+
+// FailureError is an unsuccessful push response from APNs.
+type FailureError interface {
+	error
+	// FailureStatusCode returns the HTTP status code of the response.
+	FailureStatusCode() int
+	// FailureReason returns the APNs failure reason, if any.
+	FailureReason() string
+}
+
+// BadTokenReason returns the APNs failure reason if err indicates that
+// the push token is no longer valid. That is, APNs replied with HTTP
+// status 410 (the token is no longer active for the topic) or a
+// BadDeviceToken reason. Otherwise an empty string is returned.
+func BadTokenReason(err error) string {
+	var failErr FailureError
+	if !errors.As(err, &failErr) {
+		return ""
+	}
+	reason := failErr.FailureReason()
+	if failErr.FailureStatusCode() == http.StatusGone {
+		if reason == "" {
+			reason = ReasonUnregistered
+		}
+		return reason
+	} else if reason == ReasonBadDeviceToken {
+		return reason
+	}
+	return ""
+}
+
 type Response struct {
 	Id  string
 	Err error
+
+	// Coalesced is true if the push was not sent because the
+	// enrollment was recently pushed to.
+	Coalesced bool
 }
 
 // Pusher sends MDM APNs notifications to enrollments identified by a string.
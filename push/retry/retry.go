@@ -0,0 +1,291 @@
+// Package retry provides an APNs pusher that retries transient push
+// failures using a persisted retry queue.
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+const (
+	// DefaultInterval is the default interval between checks for due
+	// push retries.
+	DefaultInterval = 30 * time.Second
+
+	// DefaultMinBackoff is the default backoff after the first failed push.
+	DefaultMinBackoff = 30 * time.Second
+
+	// DefaultMaxBackoff is the default maximum backoff between retries.
+	DefaultMaxBackoff = time.Hour
+
+	// DefaultMaxAttempts is the default maximum number of push attempts.
+	DefaultMaxAttempts = 10
+
+	// DefaultBatchSize is the default maximum number of push retries
+	// sent at each interval.
+	DefaultBatchSize = 500
+)
+
+// Temporary reports whether err is a transient push error that may
+// succeed if retried. Errors that report themselves as temporary (such
+// as APNs throttling, server errors, and HTTP/2 GOAWAYs), connection
+// resets, and network timeouts are temporary. All other errors (such as
+// a bad or unregistered device token) are permanent.
+func Temporary(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var tempErr interface{ Temporary() bool }
+	if errors.As(err, &tempErr) && tempErr.Temporary() {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// noResponseError is the error of a retried push that has no response,
+// for example because of a partial push error. It is temporary as the
+// push may never have been sent.
+type noResponseError struct {
+	err error
+}
+
+func (e *noResponseError) Error() string {
+	if e.err == nil {
+		return "no push response"
+	}
+	return "no push response: " + e.err.Error()
+}
+
+func (e *noResponseError) Temporary() bool {
+	return true
+}
+
+// Retrier is a push.Pusher that records transient push failures in a
+// retry queue. The queued pushes are retried with exponential backoff
+// (and jitter) by Run until they succeed, permanently fail, or reach
+// the maximum number of attempts.
+type Retrier struct {
+	pusher push.Pusher
+	store  storage.PushRetryStore
+	logger log.Logger
+
+	interval    time.Duration
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int
+	batchSize   int
+
+	now    func() time.Time
+	jitter func(time.Duration) time.Duration
+}
+
+type Option func(*Retrier)
+
+// WithLogger sets the logger.
+func WithLogger(logger log.Logger) Option {
+	return func(r *Retrier) {
+		r.logger = logger
+	}
+}
+
+// WithInterval sets the interval between checks for due push retries.
+func WithInterval(interval time.Duration) Option {
+	return func(r *Retrier) {
+		r.interval = interval
+	}
+}
+
+// WithBackoff sets the backoff after the first failed push and the
+// maximum backoff between retries. The backoff doubles for each
+// failed attempt.
+func WithBackoff(min, max time.Duration) Option {
+	return func(r *Retrier) {
+		r.minBackoff = min
+		r.maxBackoff = max
+	}
+}
+
+// WithMaxAttempts sets the maximum number of push attempts (including
+// the first) before giving up.
+func WithMaxAttempts(attempts int) Option {
+	return func(r *Retrier) {
+		r.maxAttempts = attempts
+	}
+}
+
+// WithBatchSize sets the maximum number of push retries sent at each interval.
+func WithBatchSize(size int) Option {
+	return func(r *Retrier) {
+		r.batchSize = size
+	}
+}
+
+// New creates a new Retrier that sends pushes with pusher and queues
+// push retries in store.
+func New(pusher push.Pusher, store storage.PushRetryStore, opts ...Option) *Retrier {
+	r := &Retrier{
+		pusher:      pusher,
+		store:       store,
+		logger:      log.NopLogger,
+		interval:    DefaultInterval,
+		minBackoff:  DefaultMinBackoff,
+		maxBackoff:  DefaultMaxBackoff,
+		maxAttempts: DefaultMaxAttempts,
+		batchSize:   DefaultBatchSize,
+		now:         time.Now,
+		jitter: func(d time.Duration) time.Duration {
+			return time.Duration(rand.Int63n(int64(d) + 1))
+		},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// backoff returns the (jittered) delay before the next push attempt
+// after attempts failed attempts.
+func (r *Retrier) backoff(attempts int) time.Duration {
+	d := r.minBackoff
+	for i := 1; i < attempts && d < r.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.maxBackoff {
+		d = r.maxBackoff
+	}
+	// "equal jitter": somewhere between half and all of the backoff
+	return d/2 + r.jitter(d/2)
+}
+
+// schedule queues a retry of id after attempts failed attempts.
+// It reports false if the maximum attempts were reached instead.
+func (r *Retrier) schedule(ctx context.Context, id string, attempts int, err error) (bool, error) {
+	if attempts >= r.maxAttempts {
+		return false, nil
+	}
+	retry := &storage.PushRetry{
+		ID:        id,
+		Attempts:  attempts,
+		NextAt:    r.now().Add(r.backoff(attempts)),
+		LastError: err.Error(),
+	}
+	return true, r.store.StorePushRetry(ctx, retry)
+}
+
+// Push sends APNs pushes to ids. Pushes to ids that fail with a
+// transient error are queued to be retried. The responses returned are
+// those of the initial push attempt.
+func (r *Retrier) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	resps, err := r.pusher.Push(ctx, ids)
+	logger := ctxlog.Logger(ctx, r.logger)
+	var ct int
+	for id, resp := range resps {
+		if resp == nil || !Temporary(resp.Err) {
+			continue
+		}
+		scheduled, storeErr := r.schedule(ctx, id, 1, resp.Err)
+		if storeErr != nil {
+			logger.Info("msg", "storing push retry", "id", id, "err", storeErr)
+		} else if scheduled {
+			ct++
+		}
+	}
+	if ct > 0 {
+		logger.Debug("msg", "queued push retries", "count", ct)
+	}
+	return resps, err
+}
+
+// RetryDue retries the pushes in the retry queue that are due.
+func (r *Retrier) RetryDue(ctx context.Context) error {
+	retries, err := r.store.RetrieveDuePushRetries(ctx, r.now(), r.batchSize)
+	if err != nil || len(retries) < 1 {
+		return err
+	}
+	logger := ctxlog.Logger(ctx, r.logger)
+	ids := make([]string, len(retries))
+	for i, retry := range retries {
+		ids[i] = retry.ID
+	}
+	resps, err := r.pusher.Push(ctx, ids)
+	if err != nil && len(resps) < 1 {
+		// leave the retries queued to try again at the next interval
+		return err
+	}
+	var done []string
+	var okCt, failCt, retryCt int
+	for _, retry := range retries {
+		resp := resps[retry.ID]
+		if resp == nil {
+			resp = &push.Response{Err: &noResponseError{err: err}}
+		}
+		if Temporary(resp.Err) {
+			scheduled, err := r.schedule(ctx, retry.ID, retry.Attempts+1, resp.Err)
+			if err != nil {
+				return err
+			} else if scheduled {
+				retryCt++
+				continue
+			}
+			logger.Info(
+				"msg", "giving up push retry",
+				"id", retry.ID,
+				"attempts", retry.Attempts+1,
+				"err", resp.Err,
+			)
+			failCt++
+		} else if resp.Err != nil {
+			logger.Info(
+				"msg", "push retry failed",
+				"id", retry.ID,
+				"attempts", retry.Attempts+1,
+				"err", resp.Err,
+			)
+			failCt++
+		} else {
+			okCt++
+		}
+		done = append(done, retry.ID)
+	}
+	logger.Debug(
+		"msg", "retried pushes",
+		"count", okCt,
+		"failed", failCt,
+		"requeued", retryCt,
+	)
+	return r.store.DeletePushRetries(ctx, done)
+}
+
+// Run retries due pushes every interval until ctx is done.
+func (r *Retrier) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := r.RetryDue(ctx); err != nil {
+				ctxlog.Logger(ctx, r.logger).Info(
+					"msg", "retry due pushes",
+					"err", err,
+				)
+			}
+		}
+	}
+}
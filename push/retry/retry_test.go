@@ -0,0 +1,217 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/push/nanopush"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+type testStore struct {
+	retries map[string]*storage.PushRetry
+}
+
+func (s *testStore) StorePushRetry(_ context.Context, retry *storage.PushRetry) error {
+	if s.retries == nil {
+		s.retries = make(map[string]*storage.PushRetry)
+	}
+	r := *retry
+	s.retries[retry.ID] = &r
+	return nil
+}
+
+func (s *testStore) RetrieveDuePushRetries(_ context.Context, before time.Time, limit int) ([]*storage.PushRetry, error) {
+	var retries []*storage.PushRetry
+	for _, retry := range s.retries {
+		if !retry.NextAt.After(before) {
+			r := *retry
+			retries = append(retries, &r)
+		}
+	}
+	sort.Slice(retries, func(i, j int) bool { return retries[i].ID < retries[j].ID })
+	if limit > 0 && len(retries) > limit {
+		retries = retries[:limit]
+	}
+	return retries, nil
+}
+
+func (s *testStore) DeletePushRetries(_ context.Context, ids []string) error {
+	for _, id := range ids {
+		delete(s.retries, id)
+	}
+	return nil
+}
+
+// testPusher returns the configured error for each id. Ids in
+// noResps get no response and a partial push error is returned.
+type testPusher struct {
+	errs    map[string]error
+	noResps map[string]bool
+	pushed  []string
+}
+
+func (p *testPusher) Push(_ context.Context, ids []string) (map[string]*push.Response, error) {
+	resps := make(map[string]*push.Response)
+	var err error
+	for _, id := range ids {
+		if p.noResps[id] {
+			err = errors.New("partial push error")
+			continue
+		}
+		p.pushed = append(p.pushed, id)
+		resps[id] = &push.Response{Err: p.errs[id]}
+	}
+	return resps, err
+}
+
+func TestTemporary(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		temp bool
+	}{
+		{nil, false},
+		{errors.New("push failed"), false},
+		{&nanopush.HTTPError{StatusCode: 400, Err: errors.New("BadDeviceToken")}, false},
+		{&nanopush.HTTPError{StatusCode: 410, Err: errors.New("Unregistered")}, false},
+		{&nanopush.HTTPError{StatusCode: 429, Err: errors.New("TooManyRequests")}, true},
+		{fmt.Errorf("wrapped: %w", &nanopush.HTTPError{StatusCode: 503}), true},
+		{&nanopush.GoAwayError{}, true},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{io.ErrUnexpectedEOF, true},
+		{context.Canceled, false},
+	} {
+		if have, want := Temporary(test.err), test.temp; have != want {
+			t.Errorf("%v: have: %v, want: %v", test.err, have, want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := New(nil, nil, WithBackoff(time.Second, 10*time.Second))
+	r.jitter = func(d time.Duration) time.Duration { return d }
+	for attempts, want := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 8 * time.Second,
+		5: 10 * time.Second,
+		9: 10 * time.Second,
+	} {
+		if have := r.backoff(attempts); have != want {
+			t.Errorf("attempts %d: have: %s, want: %s", attempts, have, want)
+		}
+	}
+	r.jitter = func(time.Duration) time.Duration { return 0 }
+	if have, want := r.backoff(1), 500*time.Millisecond; have != want {
+		t.Errorf("have: %s, want: %s", have, want)
+	}
+}
+
+func TestRetrier(t *testing.T) {
+	ctx := context.Background()
+	store := new(testStore)
+	pusher := &testPusher{errs: map[string]error{
+		"temp": &nanopush.HTTPError{StatusCode: 503},
+		"perm": &nanopush.HTTPError{StatusCode: 410},
+	}}
+	r := New(pusher, store, WithBackoff(time.Minute, time.Hour), WithMaxAttempts(3))
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	r.jitter = func(d time.Duration) time.Duration { return d }
+
+	resps, err := r.Push(ctx, []string{"ok", "temp", "perm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(resps), 3; have != want {
+		t.Errorf("responses: have: %d, want: %d", have, want)
+	}
+	if have, want := len(store.retries), 1; have != want {
+		t.Fatalf("retries: have: %d, want: %d", have, want)
+	}
+	retry := store.retries["temp"]
+	if retry == nil || retry.Attempts != 1 || !retry.NextAt.Equal(now.Add(time.Minute)) || retry.LastError == "" {
+		t.Fatalf("unexpected retry: %+v", retry)
+	}
+
+	// not yet due
+	pusher.pushed = nil
+	if err = r.RetryDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(pusher.pushed), 0; have != want {
+		t.Errorf("pushed: have: %d, want: %d", have, want)
+	}
+
+	// due and fails again: rescheduled with a longer backoff
+	now = now.Add(time.Minute)
+	if err = r.RetryDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	retry = store.retries["temp"]
+	if retry == nil || retry.Attempts != 2 || !retry.NextAt.Equal(now.Add(2*time.Minute)) {
+		t.Fatalf("unexpected retry: %+v", retry)
+	}
+
+	// due and fails at the maximum attempts: given up
+	now = now.Add(2 * time.Minute)
+	if err = r.RetryDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(store.retries), 0; have != want {
+		t.Errorf("retries: have: %d, want: %d", have, want)
+	}
+
+	// a retry that succeeds is removed
+	if _, err = r.Push(ctx, []string{"temp"}); err != nil {
+		t.Fatal(err)
+	}
+	delete(pusher.errs, "temp")
+	now = now.Add(time.Minute)
+	pusher.pushed = nil
+	if err = r.RetryDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(pusher.pushed), 1; have != want {
+		t.Errorf("pushed: have: %d, want: %d", have, want)
+	}
+	if have, want := len(store.retries), 0; have != want {
+		t.Errorf("retries: have: %d, want: %d", have, want)
+	}
+}
+
+func TestRetryDueNoResponse(t *testing.T) {
+	ctx := context.Background()
+	store := new(testStore)
+	pusher := &testPusher{noResps: map[string]bool{"noresp": true}}
+	r := New(pusher, store, WithBackoff(time.Minute, time.Hour))
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	r.jitter = func(d time.Duration) time.Duration { return d }
+
+	for _, id := range []string{"ok", "noresp"} {
+		if err := store.StorePushRetry(ctx, &storage.PushRetry{ID: id, Attempts: 1, NextAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.RetryDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a push without a response may never have been sent
+	if have, want := len(store.retries), 1; have != want {
+		t.Fatalf("retries: have: %d, want: %d", have, want)
+	}
+	retry := store.retries["noresp"]
+	if retry == nil || retry.Attempts != 2 || !retry.NextAt.Equal(now.Add(2*time.Minute)) || retry.LastError == "" {
+		t.Errorf("unexpected retry: %+v", retry)
+	}
+}
@@ -0,0 +1,132 @@
+// Package scheduler sends APNs push notifications to enrollments when
+// their scheduled (not-before) commands become deliverable.
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// DefaultInterval is the default interval between checks for
+// deliverable scheduled commands.
+const DefaultInterval = time.Minute
+
+// DefaultLookBack is the default period before startup that is checked
+// for scheduled commands which became deliverable while not running.
+const DefaultLookBack = 24 * time.Hour
+
+// Scheduler periodically pushes to enrollments whose scheduled commands
+// have become deliverable.
+type Scheduler struct {
+	store    storage.ScheduledCommandRetriever
+	pusher   push.Pusher
+	logger   log.Logger
+	interval time.Duration
+	lookBack time.Duration
+}
+
+type Option func(*Scheduler)
+
+// WithLogger sets the logger.
+func WithLogger(logger log.Logger) Option {
+	return func(s *Scheduler) {
+		s.logger = logger
+	}
+}
+
+// WithInterval sets the interval between checks for deliverable
+// scheduled commands.
+func WithInterval(interval time.Duration) Option {
+	return func(s *Scheduler) {
+		s.interval = interval
+	}
+}
+
+// WithLookBack sets the period before startup that is checked for
+// scheduled commands which became deliverable while not running.
+func WithLookBack(lookBack time.Duration) Option {
+	return func(s *Scheduler) {
+		s.lookBack = lookBack
+	}
+}
+
+// New creates a new Scheduler.
+func New(store storage.ScheduledCommandRetriever, pusher push.Pusher, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		store:    store,
+		pusher:   pusher,
+		logger:   log.NopLogger,
+		interval: DefaultInterval,
+		lookBack: DefaultLookBack,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run checks for deliverable scheduled commands at startup and then
+// every interval until ctx is done. The first check covers the look
+// back period so that commands which became deliverable before startup
+// are not missed. Each later check covers the period since the last
+// successful check so that no scheduled commands are missed if a check
+// fails.
+func (s *Scheduler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	last := time.Now().Add(-s.lookBack)
+	check := func(now time.Time) {
+		if err := s.PushScheduled(ctx, last, now); err != nil {
+			ctxlog.Logger(ctx, s.logger).Info(
+				"msg", "push scheduled",
+				"err", err,
+			)
+			return
+		}
+		last = now
+	}
+	check(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			check(now)
+		}
+	}
+}
+
+// PushScheduled sends push notifications to enrollments with scheduled
+// commands that became deliverable after after and at or before before.
+func (s *Scheduler) PushScheduled(ctx context.Context, after, before time.Time) error {
+	ids, err := s.store.RetrieveScheduledIDs(ctx, after, before)
+	if err != nil || len(ids) < 1 {
+		return err
+	}
+	logger := ctxlog.Logger(ctx, s.logger)
+	resps, err := s.pusher.Push(ctx, ids)
+	if err != nil {
+		return err
+	}
+	var errCt int
+	for id, resp := range resps {
+		if resp != nil && resp.Err != nil {
+			logger.Debug("msg", "push scheduled", "id", id, "err", resp.Err)
+			errCt++
+		}
+	}
+	logs := []interface{}{"msg", "push scheduled", "count", len(ids)}
+	if errCt > 0 {
+		logs = append(logs, "errs", errCt)
+		logger.Info(logs...)
+	} else {
+		logger.Debug(logs...)
+	}
+	return nil
+}
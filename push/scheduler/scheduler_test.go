@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+)
+
+type testStore struct {
+	after, before time.Time
+	ids           []string
+	err           error
+}
+
+func (s *testStore) RetrieveScheduledIDs(_ context.Context, after, before time.Time) ([]string, error) {
+	s.after, s.before = after, before
+	return s.ids, s.err
+}
+
+type testPusher struct {
+	ids []string
+}
+
+func (p *testPusher) Push(_ context.Context, ids []string) (map[string]*push.Response, error) {
+	p.ids = append(p.ids, ids...)
+	resps := make(map[string]*push.Response)
+	for _, id := range ids {
+		resps[id] = &push.Response{Id: "push-" + id}
+	}
+	return resps, nil
+}
+
+func TestPushScheduled(t *testing.T) {
+	ctx := context.Background()
+	store := &testStore{ids: []string{"ID1", "ID2"}}
+	pusher := new(testPusher)
+	s := New(store, pusher)
+
+	after := time.Now().Add(-time.Minute)
+	before := time.Now()
+	if err := s.PushScheduled(ctx, after, before); err != nil {
+		t.Fatal(err)
+	}
+	if !store.after.Equal(after) || !store.before.Equal(before) {
+		t.Errorf("period: have: %v-%v, want: %v-%v", store.after, store.before, after, before)
+	}
+	if len(pusher.ids) != 2 {
+		t.Errorf("pushed: have: %v, want: %v", pusher.ids, store.ids)
+	}
+
+	pusher.ids = nil
+	store.ids = nil
+	if err := s.PushScheduled(ctx, after, before); err != nil {
+		t.Fatal(err)
+	}
+	if len(pusher.ids) != 0 {
+		t.Errorf("pushed: have: %v, want: none", pusher.ids)
+	}
+
+	store.err = errors.New("test error")
+	if err := s.PushScheduled(ctx, after, before); err == nil {
+		t.Error("expected error")
+	}
+}
+
+type afterStore struct {
+	afters chan time.Time
+}
+
+func (s *afterStore) RetrieveScheduledIDs(_ context.Context, after, _ time.Time) ([]string, error) {
+	s.afters <- after
+	return nil, nil
+}
+
+func TestRunLookBack(t *testing.T) {
+	store := &afterStore{afters: make(chan time.Time, 1)}
+	s := New(store, new(testPusher), WithInterval(time.Hour), WithLookBack(2*time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	go s.Run(ctx)
+
+	select {
+	case after := <-store.afters:
+		// commands that became deliverable before startup are covered
+		if after.Before(start.Add(-2*time.Hour)) || after.After(time.Now().Add(-2*time.Hour)) {
+			t.Errorf("after: have: %v, want: about %v", after, start.Add(-2*time.Hour))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no check at startup")
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	mdmservice "github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// TopicBadPushToken is the event topic for push tokens that APNs
+// reported as no longer valid. The event Status is the APNs reason.
+const TopicBadPushToken = "nanomdm.BadPushToken"
+
+// ErrBadToken is returned for enrollments that were not pushed to
+// because their push token was previously reported as no longer valid.
+var ErrBadToken = errors.New("push token no longer valid")
+
+// EnrollmentDisabler disables device channel enrollments. Enrollments
+// are listed to tell device channel enrollments from user channel
+// enrollments, which can't be disabled on their own.
+type EnrollmentDisabler interface {
+	Disable(r *mdm.Request) error
+	storage.EnrollmentLister
+}
+
+// WithBadTokenStore records push tokens that APNs reports as no
+// longer valid (that is, HTTP status 410 or a BadDeviceToken reason)
+// in store.
+func WithBadTokenStore(store storage.BadPushTokenStore) Option {
+	return func(s *PushService) {
+		s.badTokens = store
+	}
+}
+
+// WithSkipBadTokens stops pushing to enrollments whose current push
+// token was recorded as no longer valid. Pushing resumes once a
+// TokenUpdate brings a new push token. Requires WithBadTokenStore.
+func WithSkipBadTokens() Option {
+	return func(s *PushService) {
+		s.skipBadTokens = true
+	}
+}
+
+// WithBadTokenDisabler disables enrollments whose push token APNs
+// reports as no longer valid. Note only device channel enrollments
+// can be disabled: user channel enrollments are skipped.
+func WithBadTokenDisabler(disabler EnrollmentDisabler) Option {
+	return func(s *PushService) {
+		s.disabler = disabler
+	}
+}
+
+// WithNotifier sends an event to notifier for each push token that
+// APNs reports as no longer valid.
+func WithNotifier(notifier mdmservice.EventNotifier) Option {
+	return func(s *PushService) {
+		s.notifier = notifier
+	}
+}
+
+// skipBad removes the push infos of idToPushInfo whose push tokens
+// were recorded as no longer valid. A response is returned for each
+// removed id.
+func (s *PushService) skipBad(ctx context.Context, idToPushInfo map[string]*mdm.Push) (map[string]*push.Response, error) {
+	if !s.skipBadTokens || s.badTokens == nil || len(idToPushInfo) < 1 {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(idToPushInfo))
+	for id := range idToPushInfo {
+		ids = append(ids, id)
+	}
+	badTokens, err := s.badTokens.RetrieveBadPushTokens(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	idToResponse := make(map[string]*push.Response)
+	for id, badToken := range badTokens {
+		pushInfo := idToPushInfo[id]
+		if badToken == nil || pushInfo == nil || badToken.Token != pushInfo.Token.String() {
+			// a TokenUpdate has since brought a new token
+			continue
+		}
+		delete(idToPushInfo, id)
+		idToResponse[id] = &push.Response{Err: fmt.Errorf("%w: %s", ErrBadToken, badToken.Reason)}
+	}
+	return idToResponse, nil
+}
+
+// handleBadToken records, disables, and notifies about the push token
+// of id that APNs reported as no longer valid for reason.
+// Errors are logged rather than returned.
+func (s *PushService) handleBadToken(ctx context.Context, id string, pushInfo *mdm.Push, reason string) {
+	logger := ctxlog.Logger(ctx, s.logger)
+	logger.Info(
+		"msg", "bad push token",
+		"id", id,
+		"reason", reason,
+	)
+	if s.badTokens != nil {
+		err := s.badTokens.StoreBadPushToken(ctx, &storage.BadPushToken{
+			ID:     id,
+			Token:  pushInfo.Token.String(),
+			Reason: reason,
+		})
+		if err != nil {
+			logger.Info("msg", "storing bad push token", "id", id, "err", err)
+		}
+	}
+	if s.disabler != nil {
+		if err := s.disable(ctx, id); err != nil {
+			logger.Info("msg", "disabling enrollment", "id", id, "err", err)
+		}
+	}
+	if s.notifier != nil {
+		err := s.notifier.NotifyEvent(ctx, &mdmservice.Event{
+			Topic:  TopicBadPushToken,
+			ID:     id,
+			Status: reason,
+		})
+		if err != nil {
+			logger.Info("msg", "notify bad push token", "id", id, "err", err)
+		}
+	}
+}
+
+// disable disables the device channel enrollment id. User channel
+// enrollments are logged and skipped rather than disabled.
+func (s *PushService) disable(ctx context.Context, id string) error {
+	enrollments, err := s.disabler.ListEnrollments(ctx, &storage.EnrollmentFilter{IDs: []string{id}}, "", 1)
+	if err != nil {
+		return err
+	}
+	if len(enrollments) < 1 {
+		return errors.New("enrollment not found")
+	}
+	if parentID := enrollments[0].ParentID; parentID != "" {
+		ctxlog.Logger(ctx, s.logger).Info(
+			"msg", "not disabling user channel enrollment",
+			"id", id,
+			"parent_id", parentID,
+		)
+		return nil
+	}
+	return s.disabler.Disable(&mdm.Request{
+		Context:  ctx,
+		EnrollID: &mdm.EnrollID{ID: id},
+	})
+}
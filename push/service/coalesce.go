@@ -0,0 +1,67 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// coalescer tracks recent pushes to enrollment IDs so that duplicate
+// pushes within a window can be suppressed.
+type coalescer struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	last   map[string]time.Time
+	lastGC time.Time
+}
+
+func newCoalescer(window time.Duration) *coalescer {
+	return &coalescer{
+		window: window,
+		now:    time.Now,
+		last:   make(map[string]time.Time),
+	}
+}
+
+// reserve splits ids into those that should be pushed and those that
+// were pushed to (or are being pushed to) within the window. The push
+// time of the returned push IDs is recorded immediately so that
+// concurrent callers coalesce with in-flight pushes.
+func (c *coalescer) reserve(ids []string) (push []string, coalesced []string) {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastGC) > c.window {
+		for id, t := range c.last {
+			if now.Sub(t) >= c.window {
+				delete(c.last, id)
+			}
+		}
+		c.lastGC = now
+	}
+	seen := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		if t, ok := c.last[id]; ok && now.Sub(t) < c.window {
+			coalesced = append(coalesced, id)
+			continue
+		}
+		c.last[id] = now
+		push = append(push, id)
+	}
+	return
+}
+
+// release forgets the push time of ids. Used when pushes fail so that
+// subsequent pushes are not suppressed.
+func (c *coalescer) release(ids []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		delete(c.last, id)
+	}
+}
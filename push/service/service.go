@@ -7,9 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
 	"github.com/micromdm/nanomdm/push"
+	mdmservice "github.com/micromdm/nanomdm/service"
 	"github.com/micromdm/nanomdm/storage"
 
 	"github.com/micromdm/nanolib/log"
@@ -30,17 +32,55 @@ type PushService struct {
 	providersMu     sync.RWMutex
 	logger          log.Logger
 	providerFactory push.PushProviderFactory
+	coalescer       *coalescer
+
+	badTokens     storage.BadPushTokenStore
+	skipBadTokens bool
+	disabler      EnrollmentDisabler
+	notifier      mdmservice.EventNotifier
+
+	history     storage.PushHistoryStore
+	historyKeep int
+}
+
+// Option configures a PushService.
+type Option func(*PushService)
+
+// WithCoalesceWindow suppresses duplicate pushes to the same enrollment
+// ID within window. Suppressed pushes are marked as coalesced in the
+// push responses. A window of zero (the default) disables coalescing.
+func WithCoalesceWindow(window time.Duration) Option {
+	return func(s *PushService) {
+		if window > 0 {
+			s.coalescer = newCoalescer(window)
+		} else {
+			s.coalescer = nil
+		}
+	}
+}
+
+// WithPushHistory stores the outcome of each push sent in store
+// keeping the keep most recent push results of each enrollment.
+func WithPushHistory(store storage.PushHistoryStore, keep int) Option {
+	return func(s *PushService) {
+		s.history = store
+		s.historyKeep = keep
+	}
 }
 
 // NewPushService creates a new PushService.
-func New(store storage.PushStore, certStore storage.PushCertStore, providerFactory push.PushProviderFactory, logger log.Logger) *PushService {
-	return &PushService{
+func New(store storage.PushStore, certStore storage.PushCertStore, providerFactory push.PushProviderFactory, logger log.Logger, opts ...Option) *PushService {
+	s := &PushService{
 		logger:          logger,
 		store:           store,
 		certStore:       certStore,
 		providers:       make(map[string]*provider),
 		providerFactory: providerFactory,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // getProvider returns a PushProvider if it exists and is not stale.
@@ -152,20 +192,65 @@ func (s *PushService) pushMulti(ctx context.Context, pushInfos []*mdm.Push) (map
 	return responses, finalErr
 }
 
-// Push sends an APNs push notification to MDM enrollment id
+// Push sends an APNs push notification to MDM enrollment id.
+// If coalescing is enabled then ids pushed to within the coalescing
+// window are not pushed to again and their responses are marked as
+// coalesced instead.
 func (s *PushService) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	if s.coalescer == nil {
+		return s.push(ctx, ids)
+	}
+	ids, coalesced := s.coalescer.reserve(ids)
+	var idToResponse map[string]*push.Response
+	var err error
+	if len(ids) > 0 {
+		idToResponse, err = s.push(ctx, ids)
+	}
+	if idToResponse == nil {
+		idToResponse = make(map[string]*push.Response)
+	}
+	// forget failed pushes so that they may be retried right away
+	var failed []string
+	for _, id := range ids {
+		if resp, ok := idToResponse[id]; !ok || resp == nil || resp.Err != nil {
+			failed = append(failed, id)
+		}
+	}
+	s.coalescer.release(failed)
+	for _, id := range coalesced {
+		idToResponse[id] = &push.Response{Coalesced: true}
+	}
+	if len(coalesced) > 0 {
+		ctxlog.Logger(ctx, s.logger).Debug(
+			"msg", "coalesced pushes",
+			"count", len(coalesced),
+		)
+	}
+	return idToResponse, err
+}
+
+// push sends APNs push notifications to MDM enrollment ids.
+func (s *PushService) push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
 	idToPushInfo, err := s.store.RetrievePushInfo(ctx, ids)
 	if err != nil {
 		return nil, fmt.Errorf("push storage: %w", err)
 	}
-	idToResponse := make(map[string]*push.Response)
+	idToResponse, err := s.skipBad(ctx, idToPushInfo)
+	if err != nil {
+		return nil, fmt.Errorf("bad push token storage: %w", err)
+	}
+	if idToResponse == nil {
+		idToResponse = make(map[string]*push.Response)
+	}
 
 	// create mappings between tokens and enrollment IDs. Push providers
 	// don't know about IDs and instead deal with Tokens as identifiers.
 	tokenToId := make(map[string]string)
 	pushInfos := make([]*mdm.Push, 0) // gather all pushInfos
 	for _, id := range ids {
-		if _, found := idToPushInfo[id]; found {
+		if _, skipped := idToResponse[id]; skipped {
+			continue
+		} else if _, found := idToPushInfo[id]; found {
 			pushInfo := idToPushInfo[id]
 			pushInfos = append(pushInfos, pushInfo)
 			// map token string back to id (Push Providers only know
@@ -182,6 +267,7 @@ func (s *PushService) Push(ctx context.Context, ids []string) (map[string]*push.
 	}
 
 	// perform actual pushes. we're dealing with maps keyed by token.
+	pushedAt := time.Now()
 	var tokenToResponse map[string]*push.Response
 	if len(pushInfos) == 1 {
 		// some environments may heavily utilize individual pushes.
@@ -192,6 +278,7 @@ func (s *PushService) Push(ctx context.Context, ids []string) (map[string]*push.
 	}
 
 	// re-associate token responses with ids
+	var history []*storage.PushResult
 	for token, resp := range tokenToResponse {
 		id, ok := tokenToId[token]
 		if !ok {
@@ -201,7 +288,43 @@ func (s *PushService) Push(ctx context.Context, ids []string) (map[string]*push.
 			continue
 		}
 		idToResponse[id] = resp
+		if resp == nil {
+			continue
+		}
+		if reason := push.BadTokenReason(resp.Err); reason != "" {
+			s.handleBadToken(ctx, id, idToPushInfo[id], reason)
+		}
+		if s.history != nil {
+			history = append(history, newPushResult(id, idToPushInfo[id].Topic, pushedAt, resp))
+		}
+	}
+
+	if len(history) > 0 {
+		if histErr := s.history.StorePushResults(ctx, history, s.historyKeep); histErr != nil {
+			ctxlog.Logger(ctx, s.logger).Info(
+				"msg", "storing push history",
+				"err", histErr,
+			)
+		}
 	}
 
 	return idToResponse, err
 }
+
+// newPushResult creates a push history result from resp.
+func newPushResult(id, topic string, pushedAt time.Time, resp *push.Response) *storage.PushResult {
+	result := &storage.PushResult{
+		ID:       id,
+		PushedAt: pushedAt,
+		APNsID:   resp.Id,
+		Topic:    topic,
+	}
+	if resp.Err != nil {
+		result.Error = resp.Err.Error()
+		var failErr push.FailureError
+		if errors.As(resp.Err, &failErr) {
+			result.Reason = failErr.FailureReason()
+		}
+	}
+	return result
+}
@@ -0,0 +1,543 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/push/apnstest"
+	"github.com/micromdm/nanomdm/push/nanopush"
+	mdmservice "github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+type testStore struct{}
+
+func (s *testStore) RetrievePushInfo(_ context.Context, ids []string) (map[string]*mdm.Push, error) {
+	infos := make(map[string]*mdm.Push)
+	for _, id := range ids {
+		if id == "unknown" {
+			continue
+		}
+		infos[id] = &mdm.Push{Topic: "topic", Token: []byte(id)}
+	}
+	return infos, nil
+}
+
+func (s *testStore) IsPushCertStale(context.Context, string, string) (bool, error) {
+	return false, nil
+}
+
+func (s *testStore) RetrievePushCert(context.Context, string) (*tls.Certificate, string, error) {
+	return new(tls.Certificate), "stale", nil
+}
+
+func (s *testStore) StorePushCert(context.Context, []byte, []byte) error {
+	return nil
+}
+
+type testProvider struct {
+	mu     sync.Mutex
+	tokens []string
+	fail   bool
+	errs   map[string]error // keyed by token
+}
+
+func (p *testProvider) Push(_ context.Context, pushInfos []*mdm.Push) (map[string]*push.Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	resps := make(map[string]*push.Response)
+	for _, pushInfo := range pushInfos {
+		p.tokens = append(p.tokens, pushInfo.Token.String())
+		resp := &push.Response{Id: "apns-id"}
+		if p.fail {
+			resp.Err = errors.New("push failed")
+		} else if err := p.errs[pushInfo.Token.String()]; err != nil {
+			resp.Err = err
+		}
+		resps[pushInfo.Token.String()] = resp
+	}
+	return resps, nil
+}
+
+func (p *testProvider) NewPushProvider(*tls.Certificate) (push.PushProvider, error) {
+	return p, nil
+}
+
+func (p *testProvider) pushed() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ct := len(p.tokens)
+	p.tokens = nil
+	return ct
+}
+
+func TestPushCoalesce(t *testing.T) {
+	ctx := context.Background()
+	store := new(testStore)
+	prov := new(testProvider)
+	svc := New(store, store, prov, log.NopLogger, WithCoalesceWindow(time.Minute))
+	now := time.Now()
+	svc.coalescer.now = func() time.Time { return now }
+
+	resps, err := svc.Push(ctx, []string{"ID1", "ID2", "ID1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := prov.pushed(), 2; have != want {
+		t.Errorf("pushed: have: %d, want: %d", have, want)
+	}
+	for _, id := range []string{"ID1", "ID2"} {
+		if resps[id] == nil || resps[id].Coalesced || resps[id].Err != nil {
+			t.Errorf("%s: unexpected response: %+v", id, resps[id])
+		}
+	}
+
+	// second push within the window is coalesced
+	now = now.Add(30 * time.Second)
+	resps, err = svc.Push(ctx, []string{"ID1", "ID3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := prov.pushed(), 1; have != want {
+		t.Errorf("pushed: have: %d, want: %d", have, want)
+	}
+	if resps["ID1"] == nil || !resps["ID1"].Coalesced {
+		t.Errorf("ID1: expected coalesced response: %+v", resps["ID1"])
+	}
+	if resps["ID3"] == nil || resps["ID3"].Coalesced {
+		t.Errorf("ID3: unexpected response: %+v", resps["ID3"])
+	}
+
+	// everything coalesced
+	resps, err = svc.Push(ctx, []string{"ID1", "ID2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := prov.pushed(), 0; have != want {
+		t.Errorf("pushed: have: %d, want: %d", have, want)
+	}
+	if have, want := len(resps), 2; have != want {
+		t.Errorf("responses: have: %d, want: %d", have, want)
+	}
+
+	// after the window pushes are sent again
+	now = now.Add(time.Minute)
+	if _, err = svc.Push(ctx, []string{"ID1", "ID2"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := prov.pushed(), 2; have != want {
+		t.Errorf("pushed: have: %d, want: %d", have, want)
+	}
+
+	// failed and unknown pushes are not coalesced
+	prov.fail = true
+	now = now.Add(time.Minute)
+	if _, err = svc.Push(ctx, []string{"ID4", "unknown"}); err != nil {
+		t.Fatal(err)
+	}
+	prov.fail = false
+	resps, err = svc.Push(ctx, []string{"ID4", "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := prov.pushed(), 2; have != want {
+		t.Errorf("pushed: have: %d, want: %d", have, want)
+	}
+	if resps["ID4"] == nil || resps["ID4"].Coalesced || resps["ID4"].Err != nil {
+		t.Errorf("ID4: unexpected response: %+v", resps["ID4"])
+	}
+	if resps["unknown"] == nil || !errors.Is(resps["unknown"].Err, ErrIdNotFound) {
+		t.Errorf("unknown: unexpected response: %+v", resps["unknown"])
+	}
+}
+
+func TestPushNoCoalesce(t *testing.T) {
+	ctx := context.Background()
+	store := new(testStore)
+	prov := new(testProvider)
+	svc := New(store, store, prov, log.NopLogger)
+
+	for i := 0; i < 2; i++ {
+		resps, err := svc.Push(ctx, []string{"ID1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resps["ID1"] == nil || resps["ID1"].Coalesced {
+			t.Errorf("unexpected response: %+v", resps["ID1"])
+		}
+	}
+	if have, want := prov.pushed(), 2; have != want {
+		t.Errorf("pushed: have: %d, want: %d", have, want)
+	}
+}
+
+type testBadTokenStore struct {
+	tokens map[string]*storage.BadPushToken
+}
+
+func (s *testBadTokenStore) StoreBadPushToken(_ context.Context, token *storage.BadPushToken) error {
+	if s.tokens == nil {
+		s.tokens = make(map[string]*storage.BadPushToken)
+	}
+	s.tokens[token.ID] = token
+	return nil
+}
+
+func (s *testBadTokenStore) RetrieveBadPushTokens(_ context.Context, ids []string) (map[string]*storage.BadPushToken, error) {
+	tokens := make(map[string]*storage.BadPushToken)
+	for _, id := range ids {
+		if token, ok := s.tokens[id]; ok {
+			tokens[id] = token
+		}
+	}
+	return tokens, nil
+}
+
+type testDisabler struct {
+	ids []string
+	// parents are the parent IDs of user channel enrollments
+	parents map[string]string
+}
+
+func (d *testDisabler) Disable(r *mdm.Request) error {
+	d.ids = append(d.ids, r.ID)
+	return nil
+}
+
+func (d *testDisabler) ListEnrollments(_ context.Context, filter *storage.EnrollmentFilter, _ string, _ int) ([]*storage.Enrollment, error) {
+	var enrollments []*storage.Enrollment
+	for _, id := range filter.IDs {
+		enrollments = append(enrollments, &storage.Enrollment{ID: id, ParentID: d.parents[id]})
+	}
+	return enrollments, nil
+}
+
+type testNotifier struct {
+	events []*mdmservice.Event
+}
+
+func (n *testNotifier) NotifyEvent(_ context.Context, e *mdmservice.Event) error {
+	n.events = append(n.events, e)
+	return nil
+}
+
+func TestBadToken(t *testing.T) {
+	ctx := context.Background()
+	store := new(testStore)
+	// testStore uses the id as the push token
+	tokenID1 := mdm.Push{Token: []byte("ID1")}
+	tokenID2 := mdm.Push{Token: []byte("ID2")}
+	prov := &testProvider{errs: map[string]error{
+		tokenID1.Token.String(): &nanopush.HTTPError{
+			StatusCode: http.StatusGone,
+			Err:        &nanopush.JSONPushError{Reason: push.ReasonUnregistered},
+		},
+		tokenID2.Token.String(): &nanopush.HTTPError{
+			StatusCode: http.StatusTooManyRequests,
+			Err:        &nanopush.JSONPushError{Reason: "TooManyRequests"},
+		},
+	}}
+	badTokens := new(testBadTokenStore)
+	disabler := new(testDisabler)
+	notifier := new(testNotifier)
+	svc := New(
+		store, store, prov, log.NopLogger,
+		WithBadTokenStore(badTokens),
+		WithSkipBadTokens(),
+		WithBadTokenDisabler(disabler),
+		WithNotifier(notifier),
+	)
+
+	resps, err := svc.Push(ctx, []string{"ID1", "ID2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resps["ID1"] == nil || resps["ID1"].Err == nil {
+		t.Fatalf("ID1: expected error: %+v", resps["ID1"])
+	}
+	token := badTokens.tokens["ID1"]
+	if token == nil || token.Token != tokenID1.Token.String() || token.Reason != push.ReasonUnregistered {
+		t.Errorf("unexpected bad push token: %+v", token)
+	}
+	if _, ok := badTokens.tokens["ID2"]; ok {
+		t.Error("ID2: throttling is not a bad push token")
+	}
+	if have, want := disabler.ids, []string{"ID1"}; len(have) != 1 || have[0] != want[0] {
+		t.Errorf("disabled: have: %v, want: %v", have, want)
+	}
+	if have, want := len(notifier.events), 1; have != want {
+		t.Fatalf("events: have: %d, want: %d", have, want)
+	}
+	if ev := notifier.events[0]; ev.Topic != TopicBadPushToken || ev.ID != "ID1" || ev.Status != push.ReasonUnregistered {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	// the bad push token is no longer pushed to
+	prov.pushed()
+	resps, err = svc.Push(ctx, []string{"ID1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := prov.pushed(), 0; have != want {
+		t.Errorf("pushed: have: %d, want: %d", have, want)
+	}
+	if resps["ID1"] == nil || !errors.Is(resps["ID1"].Err, ErrBadToken) {
+		t.Errorf("ID1: expected bad token error: %+v", resps["ID1"])
+	}
+
+	// until a TokenUpdate brings a new push token
+	badTokens.tokens["ID1"].Token = "00"
+	delete(prov.errs, tokenID1.Token.String())
+	resps, err = svc.Push(ctx, []string{"ID1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := prov.pushed(), 1; have != want {
+		t.Errorf("pushed: have: %d, want: %d", have, want)
+	}
+	if resps["ID1"] == nil || resps["ID1"].Err != nil {
+		t.Errorf("ID1: unexpected response: %+v", resps["ID1"])
+	}
+}
+
+func TestBadTokenUserChannel(t *testing.T) {
+	ctx := context.Background()
+	store := new(testStore)
+	// testStore uses the id as the push token
+	prov := &testProvider{errs: map[string]error{
+		mdm.Push{Token: []byte("USER1")}.Token.String(): &nanopush.HTTPError{
+			StatusCode: http.StatusGone,
+			Err:        &nanopush.JSONPushError{Reason: push.ReasonUnregistered},
+		},
+	}}
+	disabler := &testDisabler{parents: map[string]string{"USER1": "ID1"}}
+	svc := New(store, store, prov, log.NopLogger, WithBadTokenDisabler(disabler))
+
+	if _, err := svc.Push(ctx, []string{"USER1"}); err != nil {
+		t.Fatal(err)
+	}
+	// user channels can't be disabled on their own
+	if len(disabler.ids) > 0 {
+		t.Errorf("disabled: have: %v, want: none", disabler.ids)
+	}
+}
+
+type testHistoryStore struct {
+	results []*storage.PushResult
+	keep    int
+}
+
+func (s *testHistoryStore) StorePushResults(_ context.Context, results []*storage.PushResult, keep int) error {
+	s.results = append(s.results, results...)
+	s.keep = keep
+	return nil
+}
+
+func TestPushHistory(t *testing.T) {
+	ctx := context.Background()
+	store := new(testStore)
+	tokenID2 := mdm.Push{Token: []byte("ID2")}
+	prov := &testProvider{errs: map[string]error{
+		tokenID2.Token.String(): &nanopush.HTTPError{
+			StatusCode: http.StatusBadRequest,
+			Err:        &nanopush.JSONPushError{Reason: "BadTopic"},
+		},
+	}}
+	history := new(testHistoryStore)
+	svc := New(store, store, prov, log.NopLogger, WithPushHistory(history, 10))
+
+	if _, err := svc.Push(ctx, []string{"ID1", "ID2", "unknown"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := history.keep, 10; have != want {
+		t.Errorf("keep: have: %d, want: %d", have, want)
+	}
+	// unknown enrollments were not pushed to
+	if have, want := len(history.results), 2; have != want {
+		t.Fatalf("push results: have: %d, want: %d", have, want)
+	}
+	for _, result := range history.results {
+		if result.Topic != "topic" || result.PushedAt.IsZero() {
+			t.Errorf("unexpected push result: %+v", result)
+		}
+		switch result.ID {
+		case "ID1":
+			if result.APNsID != "apns-id" || result.Error != "" || result.Reason != "" {
+				t.Errorf("unexpected push result: %+v", result)
+			}
+		case "ID2":
+			if result.Error == "" || result.Reason != "BadTopic" {
+				t.Errorf("unexpected push result: %+v", result)
+			}
+		default:
+			t.Errorf("unexpected push result ID: %s", result.ID)
+		}
+	}
+}
+
+// certStore is a push cert store of real push certs.
+type certStore struct {
+	pushInfos map[string]*mdm.Push
+	certs     map[string]*tls.Certificate
+	tokens    map[string]int
+}
+
+func (s *certStore) RetrievePushInfo(_ context.Context, ids []string) (map[string]*mdm.Push, error) {
+	infos := make(map[string]*mdm.Push)
+	for _, id := range ids {
+		if info, ok := s.pushInfos[id]; ok {
+			infos[id] = info
+		}
+	}
+	return infos, nil
+}
+
+func (s *certStore) IsPushCertStale(_ context.Context, topic, staleToken string) (bool, error) {
+	return strconv.Itoa(s.tokens[topic]) != staleToken, nil
+}
+
+func (s *certStore) RetrievePushCert(_ context.Context, topic string) (*tls.Certificate, string, error) {
+	cert, ok := s.certs[topic]
+	if !ok {
+		return nil, "", errors.New("no push cert")
+	}
+	return cert, strconv.Itoa(s.tokens[topic]), nil
+}
+
+func (s *certStore) StorePushCert(_ context.Context, certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	topic, err := cryptoutil.TopicFromPEMCert(certPEM)
+	if err != nil {
+		return err
+	}
+	if s.certs == nil {
+		s.certs = make(map[string]*tls.Certificate)
+		s.tokens = make(map[string]int)
+	}
+	s.certs[topic] = &cert
+	s.tokens[topic]++
+	return nil
+}
+
+// countingFactory counts the push providers created.
+type countingFactory struct {
+	push.PushProviderFactory
+	mu sync.Mutex
+	ct int
+}
+
+func (f *countingFactory) NewPushProvider(cert *tls.Certificate) (push.PushProvider, error) {
+	f.mu.Lock()
+	f.ct++
+	f.mu.Unlock()
+	return f.PushProviderFactory.NewPushProvider(cert)
+}
+
+func TestPushAPNs(t *testing.T) {
+	ctx := context.Background()
+	srv := apnstest.NewServer()
+	defer srv.Close()
+
+	const (
+		topic1 = "com.apple.mgmt.External.1b9d3f6a-2c4e-4a8b-9d7f-0e2c4a6b8d1f"
+		topic2 = "com.apple.mgmt.External.5e7a9c1b-3d5f-4b7a-8c9e-1f3a5c7e9b2d"
+	)
+	store := &certStore{pushInfos: make(map[string]*mdm.Push)}
+	for i, topic := range []string{topic1, topic2} {
+		certPEM, keyPEM := apnstest.NewPushCert(t, topic, int64(i+1), time.Now().Add(time.Hour))
+		if err := store.StorePushCert(ctx, certPEM, keyPEM); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, topic := range map[string]string{"ID1": topic1, "ID2": topic1, "ID3": topic2} {
+		info := &mdm.Push{PushMagic: "magic-" + id, Topic: topic}
+		info.Token = []byte(id)
+		store.pushInfos[id] = info
+	}
+	srv.SetResponse(store.pushInfos["ID2"].Token.String(), apnstest.Unregistered())
+
+	factory := &countingFactory{PushProviderFactory: nanopush.NewFactory(
+		nanopush.WithBaseURL(srv.URL),
+		nanopush.WithNewClient(func(cert *tls.Certificate) (*http.Client, error) {
+			return nanopush.ClientWithCert(srv.NewHTTPClient(), cert)
+		}),
+	)}
+	svc := New(store, store, factory, log.NopLogger)
+
+	resps, err := svc.Push(ctx, []string{"ID1", "ID2", "ID3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"ID1", "ID3"} {
+		if resp := resps[id]; resp == nil || resp.Err != nil || resp.Id == "" {
+			t.Errorf("%s: unexpected response: %+v", id, resp)
+		}
+	}
+	if have, want := push.BadTokenReason(resps["ID2"].Err), push.ReasonUnregistered; have != want {
+		t.Errorf("ID2: reason: have: %q, want: %q", have, want)
+	}
+	pushes := srv.Pushes()
+	if have, want := len(pushes), 3; have != want {
+		t.Fatalf("pushes: have: %d, want: %d", have, want)
+	}
+	for _, p := range pushes {
+		id := string(mustDecodeHex(t, p.Token))
+		// the topic comes from the client certificate of the provider
+		if have, want := p.Topic, store.pushInfos[id].Topic; have != want {
+			t.Errorf("%s: topic: have: %q, want: %q", id, have, want)
+		}
+		if have, want := p.PushMagic, "magic-"+id; have != want {
+			t.Errorf("%s: push magic: have: %q, want: %q", id, have, want)
+		}
+	}
+	if have, want := factory.ct, 2; have != want {
+		t.Errorf("providers: have: %d, want: %d", have, want)
+	}
+
+	// cached providers are used while the push certs are not stale
+	if _, err = svc.Push(ctx, []string{"ID1", "ID3"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := factory.ct, 2; have != want {
+		t.Errorf("providers: have: %d, want: %d", have, want)
+	}
+
+	// a new push cert replaces the cached provider
+	certPEM, keyPEM := apnstest.NewPushCert(t, topic1, 3, time.Now().Add(time.Hour))
+	if err = store.StorePushCert(ctx, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = svc.Push(ctx, []string{"ID1"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := factory.ct, 3; have != want {
+		t.Errorf("providers: have: %d, want: %d", have, want)
+	}
+	if have, want := len(srv.Pushes()), 6; have != want {
+		t.Errorf("pushes: have: %d, want: %d", have, want)
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
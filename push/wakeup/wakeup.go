@@ -0,0 +1,207 @@
+// Package wakeup sends APNs push notifications to enrollments that
+// appear to have missed earlier pushes: enrollments that have not been
+// seen in a while and enrollments with long-pending queued commands.
+package wakeup
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+const (
+	// DefaultInterval is the default interval between wake-up pushes.
+	DefaultInterval = 15 * time.Minute
+
+	// DefaultMaxPushes is the default maximum number of enrollments
+	// pushed to at each interval.
+	DefaultMaxPushes = 500
+
+	// listBatchSize is the page size for listing inactive enrollments.
+	listBatchSize = 1000
+)
+
+// Store lists inactive enrollments and retrieves enrollments with
+// pending commands.
+type Store interface {
+	storage.EnrollmentLister
+	storage.PendingCommandRetriever
+}
+
+// Waker periodically pushes to enabled enrollments that have not been
+// seen within the inactivity period or that have commands pending for
+// longer than the pending age. At most the maximum number of pushes are
+// sent at each interval. When more enrollments need waking they are
+// pushed to in turn over the following intervals.
+type Waker struct {
+	store  Store
+	pusher push.Pusher
+	logger log.Logger
+
+	interval   time.Duration
+	inactivity time.Duration
+	pendingAge time.Duration
+	maxPushes  int
+
+	// cursor is the last enrollment ID pushed to
+	cursor string
+	now    func() time.Time
+}
+
+type Option func(*Waker)
+
+// WithLogger sets the logger.
+func WithLogger(logger log.Logger) Option {
+	return func(w *Waker) {
+		w.logger = logger
+	}
+}
+
+// WithInterval sets the interval between wake-up pushes.
+func WithInterval(interval time.Duration) Option {
+	return func(w *Waker) {
+		w.interval = interval
+	}
+}
+
+// WithInactivity pushes to enabled enrollments that have not been seen
+// for inactivity. Zero (the default) disables pushing to inactive
+// enrollments.
+func WithInactivity(inactivity time.Duration) Option {
+	return func(w *Waker) {
+		w.inactivity = inactivity
+	}
+}
+
+// WithPendingAge pushes to enabled enrollments with deliverable commands
+// that were enqueued more than age ago and not yet responded to. Zero
+// (the default) disables pushing to enrollments with pending commands.
+func WithPendingAge(age time.Duration) Option {
+	return func(w *Waker) {
+		w.pendingAge = age
+	}
+}
+
+// WithMaxPushes sets the maximum number of enrollments pushed to at
+// each interval.
+func WithMaxPushes(max int) Option {
+	return func(w *Waker) {
+		w.maxPushes = max
+	}
+}
+
+// New creates a new Waker.
+func New(store Store, pusher push.Pusher, opts ...Option) *Waker {
+	w := &Waker{
+		store:     store,
+		pusher:    pusher,
+		logger:    log.NopLogger,
+		interval:  DefaultInterval,
+		maxPushes: DefaultMaxPushes,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// candidates returns the sorted IDs of the enrollments to wake.
+func (w *Waker) candidates(ctx context.Context) ([]string, error) {
+	now := w.now()
+	idMap := make(map[string]struct{})
+	if w.inactivity > 0 {
+		enabled := true
+		filter := &storage.EnrollmentFilter{
+			Enabled:        &enabled,
+			LastSeenBefore: now.Add(-w.inactivity),
+		}
+		err := storage.ListEnrollmentIDs(ctx, w.store, filter, listBatchSize, func(ids []string) error {
+			for _, id := range ids {
+				idMap[id] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if w.pendingAge > 0 {
+		ids, err := w.store.RetrievePendingIDs(ctx, now.Add(-w.pendingAge))
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			idMap[id] = struct{}{}
+		}
+	}
+	ids := make([]string, 0, len(idMap))
+	for id := range idMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Wake sends push notifications to the enrollments that need waking.
+// If there are more than the maximum number of pushes then those
+// after the enrollment last pushed to (in ID order) are pushed to first.
+func (w *Waker) Wake(ctx context.Context) error {
+	ids, err := w.candidates(ctx)
+	if err != nil || len(ids) < 1 {
+		return err
+	}
+	if w.maxPushes > 0 && len(ids) > w.maxPushes {
+		// rotate the enrollments after the cursor to the front
+		i := sort.Search(len(ids), func(i int) bool { return ids[i] > w.cursor })
+		rotated := make([]string, 0, len(ids))
+		rotated = append(rotated, ids[i:]...)
+		ids = append(rotated, ids[:i]...)[:w.maxPushes]
+	}
+	w.cursor = ids[len(ids)-1]
+	logger := ctxlog.Logger(ctx, w.logger)
+	resps, err := w.pusher.Push(ctx, ids)
+	if err != nil {
+		return err
+	}
+	var errCt int
+	for id, resp := range resps {
+		if resp != nil && resp.Err != nil {
+			logger.Debug("msg", "wake-up push", "id", id, "err", resp.Err)
+			errCt++
+		}
+	}
+	logs := []interface{}{"msg", "wake-up push", "count", len(ids)}
+	if errCt > 0 {
+		logs = append(logs, "errs", errCt)
+		logger.Info(logs...)
+	} else {
+		logger.Debug(logs...)
+	}
+	return nil
+}
+
+// Run sends wake-up pushes every interval until ctx is done.
+func (w *Waker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := w.Wake(ctx); err != nil {
+				ctxlog.Logger(ctx, w.logger).Info(
+					"msg", "wake-up push",
+					"err", err,
+				)
+			}
+		}
+	}
+}
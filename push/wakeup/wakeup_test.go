@@ -0,0 +1,116 @@
+package wakeup
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+type testStore struct {
+	enrollments []*storage.Enrollment
+	pending     []string
+	before      time.Time
+}
+
+func (s *testStore) ListEnrollments(_ context.Context, filter *storage.EnrollmentFilter, cursor string, limit int) ([]*storage.Enrollment, error) {
+	var enrollments []*storage.Enrollment
+	for _, e := range s.enrollments {
+		if e.ID > cursor && filter.Match(e) {
+			enrollments = append(enrollments, e)
+		}
+		if limit > 0 && len(enrollments) >= limit {
+			break
+		}
+	}
+	return enrollments, nil
+}
+
+func (s *testStore) RetrievePendingIDs(_ context.Context, before time.Time) ([]string, error) {
+	s.before = before
+	return s.pending, nil
+}
+
+type testPusher struct {
+	ids []string
+}
+
+func (p *testPusher) Push(_ context.Context, ids []string) (map[string]*push.Response, error) {
+	p.ids = append(p.ids, ids...)
+	resps := make(map[string]*push.Response)
+	for _, id := range ids {
+		resps[id] = &push.Response{Id: "push-" + id}
+	}
+	return resps, nil
+}
+
+func (p *testPusher) pushed() []string {
+	ids := p.ids
+	p.ids = nil
+	sort.Strings(ids)
+	return ids
+}
+
+func TestWake(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := &testStore{
+		enrollments: []*storage.Enrollment{
+			{ID: "ID1", Enabled: true, LastSeenAt: now.Add(-48 * time.Hour)},
+			{ID: "ID2", Enabled: true, LastSeenAt: now},
+			{ID: "ID3", Enabled: false, LastSeenAt: now.Add(-48 * time.Hour)},
+			{ID: "ID4", Enabled: true, LastSeenAt: now.Add(-25 * time.Hour)},
+		},
+		pending: []string{"ID2", "ID4"},
+	}
+	pusher := new(testPusher)
+
+	// nothing enabled
+	w := New(store, pusher)
+	if err := w.Wake(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have := pusher.pushed(); len(have) != 0 {
+		t.Errorf("pushed: have: %v, want: none", have)
+	}
+
+	w = New(store, pusher, WithInactivity(24*time.Hour))
+	w.now = func() time.Time { return now }
+	if err := w.Wake(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := pusher.pushed(), []string{"ID1", "ID4"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("pushed: have: %v, want: %v", have, want)
+	}
+
+	w = New(store, pusher, WithInactivity(24*time.Hour), WithPendingAge(30*time.Minute))
+	w.now = func() time.Time { return now }
+	if err := w.Wake(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := pusher.pushed(), []string{"ID1", "ID2", "ID4"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("pushed: have: %v, want: %v", have, want)
+	}
+	if have, want := store.before, now.Add(-30*time.Minute); !have.Equal(want) {
+		t.Errorf("pending before: have: %v, want: %v", have, want)
+	}
+
+	// pushes are limited and taken in turn
+	w = New(store, pusher, WithInactivity(24*time.Hour), WithPendingAge(30*time.Minute), WithMaxPushes(2))
+	for _, want := range [][]string{
+		{"ID1", "ID2"},
+		{"ID1", "ID4"},
+		{"ID2", "ID4"},
+	} {
+		if err := w.Wake(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if have := pusher.pushed(); !reflect.DeepEqual(have, want) {
+			t.Errorf("pushed: have: %v, want: %v", have, want)
+		}
+	}
+}
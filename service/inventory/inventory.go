@@ -0,0 +1,127 @@
+// Package inventory is a NanoMDM service that records device attributes
+// reported in command results.
+package inventory
+
+import (
+	"errors"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Inventory attribute names.
+const (
+	SerialNumber            = "serial_number"
+	DeviceName              = "device_name"
+	Model                   = "model"
+	ModelName               = "model_name"
+	ProductName             = "product_name"
+	OSVersion               = "os_version"
+	BuildVersion            = "build_version"
+	DeviceCapacity          = "device_capacity"
+	AvailableDeviceCapacity = "available_device_capacity"
+	Supervised              = "supervised"
+	ActivationLockEnabled   = "activation_lock_enabled"
+	PasscodePresent         = "passcode_present"
+	PasscodeCompliant       = "passcode_compliant"
+	FDEEnabled              = "fde_enabled"
+	SIPEnabled              = "sip_enabled"
+	EnrolledViaDEP          = "enrolled_via_dep"
+	UserApprovedEnrollment  = "user_approved_enrollment"
+)
+
+// Inventory is a service middleware that stores device attributes from
+// DeviceInformation and SecurityInfo command results. It is intended
+// to be used alongside the main NanoMDM service (e.g. in a multi
+// service) and does not dispatch to any other service.
+type Inventory struct {
+	logger log.Logger
+	store  storage.InventoryStore
+}
+
+type Option func(*Inventory)
+
+func WithLogger(logger log.Logger) Option {
+	return func(s *Inventory) {
+		s.logger = logger
+	}
+}
+
+// New creates a new inventory service.
+func New(store storage.InventoryStore, opts ...Option) *Inventory {
+	s := &Inventory{
+		logger: log.NopLogger,
+		store:  store,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Inventory) Authenticate(*mdm.Request, *mdm.Authenticate) error {
+	return nil
+}
+
+func (s *Inventory) TokenUpdate(*mdm.Request, *mdm.TokenUpdate) error {
+	return nil
+}
+
+func (s *Inventory) CheckOut(*mdm.Request, *mdm.CheckOut) error {
+	return nil
+}
+
+func (s *Inventory) UserAuthenticate(*mdm.Request, *mdm.UserAuthenticate) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *Inventory) SetBootstrapToken(*mdm.Request, *mdm.SetBootstrapToken) error {
+	return nil
+}
+
+func (s *Inventory) GetBootstrapToken(*mdm.Request, *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error) {
+	return nil, nil
+}
+
+func (s *Inventory) DeclarativeManagement(*mdm.Request, *mdm.DeclarativeManagement) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *Inventory) GetToken(*mdm.Request, *mdm.GetToken) (*mdm.GetTokenResponse, error) {
+	return nil, nil
+}
+
+// CommandAndReportResults stores inventory attributes from
+// acknowledged DeviceInformation and SecurityInfo command results.
+func (s *Inventory) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status != "Acknowledged" {
+		return nil, nil
+	}
+	typedResults, err := mdm.DecodeTypedCommandResults(results.RequestType, results.Raw)
+	if errors.Is(err, mdm.ErrNoResultsDecoder) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var values storage.InventoryValues
+	switch typedResults := typedResults.(type) {
+	case *mdm.DeviceInformationResults:
+		values = deviceInformationValues(&typedResults.QueryResponses)
+	case *mdm.SecurityInfoResults:
+		values = securityInfoValues(&typedResults.SecurityInfo)
+	default:
+		return nil, nil
+	}
+	if len(values) < 1 {
+		return nil, nil
+	}
+	ctxlog.Logger(r.Context, s.logger).Debug(
+		"msg", "storing inventory",
+		"request_type", results.RequestType,
+		"count", len(values),
+	)
+	return nil, s.store.StoreInventoryValues(r.Context, r.ID, values)
+}
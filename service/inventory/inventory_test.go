@@ -0,0 +1,138 @@
+package inventory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+type testStore struct {
+	values map[string]storage.InventoryValues
+}
+
+func (s *testStore) StoreInventoryValues(_ context.Context, id string, values storage.InventoryValues) error {
+	if s.values == nil {
+		s.values = make(map[string]storage.InventoryValues)
+	}
+	if s.values[id] == nil {
+		s.values[id] = make(storage.InventoryValues)
+	}
+	for k, v := range values {
+		s.values[id][k] = v
+	}
+	return nil
+}
+
+const deviceInformationResults = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>0c3e2e2d-0b8f-4c1b-9e52-4a1f4e2c8a11</string>
+	<key>QueryResponses</key>
+	<dict>
+		<key>DeviceCapacity</key>
+		<real>500.5</real>
+		<key>IsSupervised</key>
+		<false/>
+		<key>OSVersion</key>
+		<string>12.6</string>
+	</dict>
+	<key>RequestType</key>
+	<string>DeviceInformation</string>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>66ADE930-5FDF-5EC4-8429-15640684C489</string>
+</dict>
+</plist>
+`
+
+const securityInfoResults = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>5d7d1b8e-3c1c-4a4d-8f0e-2d7b0b6e4c21</string>
+	<key>SecurityInfo</key>
+	<dict>
+		<key>FDE_Enabled</key>
+		<true/>
+		<key>PasscodePresent</key>
+		<false/>
+	</dict>
+	<key>RequestType</key>
+	<string>SecurityInfo</string>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>66ADE930-5FDF-5EC4-8429-15640684C489</string>
+</dict>
+</plist>
+`
+
+func TestInventory(t *testing.T) {
+	store := new(testStore)
+	svc := New(store)
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{ID: "66ADE930-5FDF-5EC4-8429-15640684C489"},
+		Context:  context.Background(),
+	}
+
+	for _, raw := range []string{deviceInformationResults, securityInfoResults} {
+		results, err := mdm.DecodeCommandResults([]byte(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = svc.CommandAndReportResults(r, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	values := store.values[r.ID]
+	for name, want := range map[string]interface{}{
+		OSVersion:       "12.6",
+		DeviceCapacity:  500.5,
+		Supervised:      false,
+		FDEEnabled:      true,
+		PasscodePresent: false,
+	} {
+		have, ok := values[name]
+		if !ok {
+			t.Errorf("missing inventory value: %s", name)
+		} else if have != want {
+			t.Errorf("%s: have: %v, want: %v", name, have, want)
+		}
+	}
+	for _, name := range []string{ActivationLockEnabled, SIPEnabled, DeviceName} {
+		if have, ok := values[name]; ok {
+			t.Errorf("unexpected inventory value: %s: %v", name, have)
+		}
+	}
+
+	// non-acknowledged results should not touch the inventory
+	store.values = nil
+	results, err := mdm.DecodeCommandResults([]byte(deviceInformationResults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results.Status = "Error"
+	if _, err = svc.CommandAndReportResults(r, results); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.values) > 0 {
+		t.Errorf("unexpected inventory: %v", store.values)
+	}
+
+	// results without a registered decoder are ignored
+	results.Status = "Acknowledged"
+	results.RequestType = "RestartDevice"
+	if _, err = svc.CommandAndReportResults(r, results); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.values) > 0 {
+		t.Errorf("unexpected inventory: %v", store.values)
+	}
+}
@@ -0,0 +1,55 @@
+package inventory
+
+import (
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// setString sets name to s if s is not empty.
+func setString(values storage.InventoryValues, name, s string) {
+	if s != "" {
+		values[name] = s
+	}
+}
+
+// setBool sets name to b if b is not nil.
+func setBool(values storage.InventoryValues, name string, b *bool) {
+	if b != nil {
+		values[name] = *b
+	}
+}
+
+// deviceInformationValues extracts inventory attributes from q.
+func deviceInformationValues(q *mdm.QueryResponses) storage.InventoryValues {
+	values := make(storage.InventoryValues)
+	setString(values, SerialNumber, q.SerialNumber)
+	setString(values, DeviceName, q.DeviceName)
+	setString(values, Model, q.Model)
+	setString(values, ModelName, q.ModelName)
+	setString(values, ProductName, q.ProductName)
+	setString(values, OSVersion, q.OSVersion)
+	setString(values, BuildVersion, q.BuildVersion)
+	if q.DeviceCapacity > 0 {
+		values[DeviceCapacity] = float64(q.DeviceCapacity)
+	}
+	if q.AvailableDeviceCapacity > 0 {
+		values[AvailableDeviceCapacity] = float64(q.AvailableDeviceCapacity)
+	}
+	setBool(values, Supervised, q.IsSupervised)
+	setBool(values, ActivationLockEnabled, q.IsActivationLockEnabled)
+	return values
+}
+
+// securityInfoValues extracts inventory attributes from si.
+func securityInfoValues(si *mdm.SecurityInfo) storage.InventoryValues {
+	values := make(storage.InventoryValues)
+	setBool(values, PasscodePresent, si.PasscodePresent)
+	setBool(values, PasscodeCompliant, si.PasscodeCompliant)
+	setBool(values, FDEEnabled, si.FDEEnabled)
+	setBool(values, SIPEnabled, si.SystemIntegrityProtectionEnabled)
+	if si.ManagementStatus != nil {
+		values[EnrolledViaDEP] = si.ManagementStatus.EnrolledViaDEP
+		values[UserApprovedEnrollment] = si.ManagementStatus.UserApprovedEnrollment
+	}
+	return values
+}
@@ -9,6 +9,7 @@ type Event struct {
 
 	AcknowledgeEvent *AcknowledgeEvent `json:"acknowledge_event,omitempty"`
 	CheckinEvent     *CheckinEvent     `json:"checkin_event,omitempty"`
+	ServerEvent      *ServerEvent      `json:"server_event,omitempty"`
 }
 
 type AcknowledgeEvent struct {
@@ -30,3 +31,14 @@ type CheckinEvent struct {
 	// is the initial enrollment vs. a following tokenupdate
 	TokenUpdateTally *int `json:"token_update_tally,omitempty"`
 }
+
+// ServerEvent is an event that occurred outside of an MDM request.
+type ServerEvent struct {
+	ID          string `json:"id"`
+	CommandUUID string `json:"command_uuid,omitempty"`
+	Status      string `json:"status,omitempty"`
+	RawPayload  []byte `json:"raw_payload,omitempty"`
+
+	PushTopic string     `json:"push_topic,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+}
@@ -2,10 +2,12 @@
 package microwebhook
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
 	"github.com/micromdm/nanomdm/storage"
 )
 
@@ -157,3 +159,22 @@ func (w *MicroWebhook) GetToken(r *mdm.Request, m *mdm.GetToken) (*mdm.GetTokenR
 	}
 	return nil, postWebhookEvent(r.Context, w.client, w.url, ev)
 }
+
+// NotifyEvent sends events that occur outside of MDM requests.
+func (w *MicroWebhook) NotifyEvent(ctx context.Context, e *service.Event) error {
+	ev := &Event{
+		Topic:     e.Topic,
+		CreatedAt: time.Now(),
+		ServerEvent: &ServerEvent{
+			ID:          e.ID,
+			CommandUUID: e.CommandUUID,
+			Status:      e.Status,
+			RawPayload:  e.Raw,
+			PushTopic:   e.PushTopic,
+		},
+	}
+	if !e.NotAfter.IsZero() {
+		ev.ServerEvent.NotAfter = &e.NotAfter
+	}
+	return postWebhookEvent(ctx, w.client, w.url, ev)
+}
@@ -0,0 +1,64 @@
+package nanomdm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// NotNowPolicy limits how long enrollments may defer commands by
+// replying NotNow. Commands that exceed the policy are dead-lettered.
+type NotNowPolicy struct {
+	// MaxCount is the number of NotNow replies after which a command is
+	// dead-lettered. Zero means no limit.
+	MaxCount int
+	// MaxAge is the time since the first NotNow reply after which a
+	// command is dead-lettered. Zero means no limit.
+	MaxAge time.Duration
+}
+
+// exceeded reports whether a command with tally NotNow replies, the
+// first of which was at first, exceeds the policy as of now.
+func (p *NotNowPolicy) exceeded(tally int, first, now time.Time) bool {
+	if p.MaxCount > 0 && tally >= p.MaxCount {
+		return true
+	}
+	return p.MaxAge > 0 && !first.IsZero() && now.Sub(first) >= p.MaxAge
+}
+
+// WithNotNowPolicy dead-letters commands in store that are deferred
+// with NotNow for longer than policy allows.
+func WithNotNowPolicy(store storage.DeadLetterStore, policy NotNowPolicy) Option {
+	return func(s *Service) {
+		s.dlStore = store
+		s.notNowPolicy = &policy
+	}
+}
+
+// enforceNotNowPolicy dead-letters command uuid if it has exceeded the
+// NotNow policy.
+func (s *Service) enforceNotNowPolicy(r *mdm.Request, uuid string) error {
+	if s.dlStore == nil || s.notNowPolicy == nil {
+		return nil
+	}
+	tally, first, err := s.dlStore.RetrieveNotNow(r.Context, r.ID, uuid)
+	if err != nil {
+		return fmt.Errorf("retrieving NotNow: %w", err)
+	}
+	if !s.notNowPolicy.exceeded(tally, first, time.Now()) {
+		return nil
+	}
+	if err = s.dlStore.DeadLetterCommand(r.Context, r.ID, uuid); err != nil {
+		return fmt.Errorf("dead-lettering command: %w", err)
+	}
+	ctxlog.Logger(r.Context, s.logger).Info(
+		"msg", "command dead-lettered",
+		"command_uuid", uuid,
+		"not_now_tally", tally,
+	)
+	return nil
+}
@@ -0,0 +1,31 @@
+package nanomdm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotNowPolicyExceeded(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name     string
+		policy   NotNowPolicy
+		tally    int
+		first    time.Time
+		exceeded bool
+	}{
+		{"no limits", NotNowPolicy{}, 100, now.Add(-time.Hour * 24 * 365), false},
+		{"under count", NotNowPolicy{MaxCount: 3}, 2, now, false},
+		{"at count", NotNowPolicy{MaxCount: 3}, 3, now, true},
+		{"under age", NotNowPolicy{MaxAge: time.Hour}, 1, now.Add(-time.Minute), false},
+		{"over age", NotNowPolicy{MaxAge: time.Hour}, 1, now.Add(-2 * time.Hour), true},
+		{"no first NotNow", NotNowPolicy{MaxAge: time.Hour}, 0, time.Time{}, false},
+		{"count or age", NotNowPolicy{MaxCount: 3, MaxAge: time.Hour}, 1, now.Add(-2 * time.Hour), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if have, want := tc.policy.exceeded(tc.tally, tc.first, now), tc.exceeded; have != want {
+				t.Errorf("exceeded: have: %v, want: %v", have, want)
+			}
+		})
+	}
+}
@@ -27,6 +27,10 @@ type Service struct {
 
 	// GetToken handler
 	gt service.GetToken
+
+	// NotNow policy dead-lettering
+	dlStore      storage.DeadLetterStore
+	notNowPolicy *NotNowPolicy
 }
 
 // normalize generates enrollment IDs that are used by other
@@ -231,6 +235,11 @@ func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandRe
 	if err != nil {
 		return nil, fmt.Errorf("storing command report: %w", err)
 	}
+	if results.Status == "NotNow" {
+		if err = s.enforceNotNowPolicy(r, results.CommandUUID); err != nil {
+			return nil, err
+		}
+	}
 	cmd, err := s.store.RetrieveNextCommand(r, results.Status == "NotNow")
 	if err != nil {
 		return nil, fmt.Errorf("retrieving next command: %w", err)
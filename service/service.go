@@ -2,6 +2,9 @@
 package service
 
 import (
+	"context"
+	"time"
+
 	"github.com/micromdm/nanomdm/mdm"
 )
 
@@ -46,3 +49,24 @@ type CheckinAndCommandService interface {
 	Checkin
 	CommandAndReportResults
 }
+
+// Event is an event that occurs outside of an MDM request. For example
+// a queued command expiring.
+type Event struct {
+	Topic string
+	// ID is the enrollment ID the event pertains to.
+	ID          string
+	CommandUUID string
+	Status      string
+	// Raw is the raw payload related to the event (e.g. a synthetic
+	// command result), if any.
+	Raw []byte
+	// PushTopic and NotAfter pertain to APNs push certificate events.
+	PushTopic string
+	NotAfter  time.Time
+}
+
+// EventNotifier is notified of events that occur outside of MDM requests.
+type EventNotifier interface {
+	NotifyEvent(context.Context, *Event) error
+}
@@ -6,8 +6,25 @@ type AllStorage interface {
 	PushStore
 	PushCertStore
 	CommandEnqueuer
+	CommandDequeuer
 	CertAuthStore
 	CertAuthRetriever
 	StoreMigrator
 	TokenUpdateTallyStore
+	EnrollmentLister
+	QueueRetriever
+	CommandResultsRetriever
+	ScheduledCommandRetriever
+	PendingCommandRetriever
+	CommandExpirer
+	DeadLetterStore
+	DeadLetterRetriever
+	InventoryStore
+	InventoryRetriever
+	PushRetryStore
+	BadPushTokenStore
+	PushHistoryStore
+	PushHistoryRetriever
+	PushCertLister
+	PushCertDeleter
 }
@@ -0,0 +1,21 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreBadPushToken(ctx context.Context, token *storage.BadPushToken) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return nil, s.StoreBadPushToken(ctx, token)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveBadPushTokens(ctx context.Context, ids []string) (map[string]*storage.BadPushToken, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrieveBadPushTokens(ctx, ids)
+	})
+	return val.(map[string]*storage.BadPushToken), err
+}
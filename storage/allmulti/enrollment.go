@@ -0,0 +1,14 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) ListEnrollments(ctx context.Context, filter *storage.EnrollmentFilter, cursor string, limit int) ([]*storage.Enrollment, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.ListEnrollments(ctx, filter, cursor, limit)
+	})
+	return val.([]*storage.Enrollment), err
+}
@@ -0,0 +1,21 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreInventoryValues(ctx context.Context, id string, values storage.InventoryValues) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return nil, s.StoreInventoryValues(ctx, id, values)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveInventory(ctx context.Context, id string) (storage.InventoryValues, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrieveInventory(ctx, id)
+	})
+	return val.(storage.InventoryValues), err
+}
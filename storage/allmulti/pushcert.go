@@ -36,3 +36,17 @@ func (ms *MultiAllStorage) StorePushCert(ctx context.Context, pemCert, pemKey []
 	})
 	return err
 }
+
+func (ms *MultiAllStorage) ListPushCerts(ctx context.Context) ([]*storage.PushCertInfo, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.ListPushCerts(ctx)
+	})
+	return val.([]*storage.PushCertInfo), err
+}
+
+func (ms *MultiAllStorage) DeletePushCert(ctx context.Context, topic string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return nil, s.DeletePushCert(ctx, topic)
+	})
+	return err
+}
@@ -0,0 +1,21 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StorePushResults(ctx context.Context, results []*storage.PushResult, keep int) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return nil, s.StorePushResults(ctx, results, keep)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrievePushHistory(ctx context.Context, id string, limit int) ([]*storage.PushResult, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrievePushHistory(ctx, id, limit)
+	})
+	return val.([]*storage.PushResult), err
+}
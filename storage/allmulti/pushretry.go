@@ -0,0 +1,29 @@
+package allmulti
+
+import (
+	"context"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StorePushRetry(ctx context.Context, retry *storage.PushRetry) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return nil, s.StorePushRetry(ctx, retry)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveDuePushRetries(ctx context.Context, before time.Time, limit int) ([]*storage.PushRetry, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrieveDuePushRetries(ctx, before, limit)
+	})
+	return val.([]*storage.PushRetry), err
+}
+
+func (ms *MultiAllStorage) DeletePushRetries(ctx context.Context, ids []string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return nil, s.DeletePushRetries(ctx, ids)
+	})
+	return err
+}
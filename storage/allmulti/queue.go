@@ -2,6 +2,7 @@ package allmulti
 
 import (
 	"context"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
 	"github.com/micromdm/nanomdm/storage"
@@ -28,9 +29,77 @@ func (ms *MultiAllStorage) ClearQueue(r *mdm.Request) error {
 	return err
 }
 
-func (ms *MultiAllStorage) EnqueueCommand(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+func (ms *MultiAllStorage) EnqueueCommand(ctx context.Context, id []string, cmd *mdm.Command, opts ...storage.EnqueueOption) (map[string]error, error) {
 	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
-		return s.EnqueueCommand(ctx, id, cmd)
+		return s.EnqueueCommand(ctx, id, cmd, opts...)
 	})
 	return val.(map[string]error), err
 }
+
+func (ms *MultiAllStorage) RetrieveQueue(ctx context.Context, id string) ([]*storage.QueueItem, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrieveQueue(ctx, id)
+	})
+	return val.([]*storage.QueueItem), err
+}
+
+func (ms *MultiAllStorage) RetrieveCommandResults(ctx context.Context, uuid string) ([]*storage.CommandResult, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrieveCommandResults(ctx, uuid)
+	})
+	return val.([]*storage.CommandResult), err
+}
+
+func (ms *MultiAllStorage) DequeueCommand(ctx context.Context, ids []string, uuid string) (int, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.DequeueCommand(ctx, ids, uuid)
+	})
+	return val.(int), err
+}
+
+func (ms *MultiAllStorage) RetrieveScheduledIDs(ctx context.Context, after, before time.Time) ([]string, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrieveScheduledIDs(ctx, after, before)
+	})
+	return val.([]string), err
+}
+
+func (ms *MultiAllStorage) RetrievePendingIDs(ctx context.Context, before time.Time) ([]string, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrievePendingIDs(ctx, before)
+	})
+	return val.([]string), err
+}
+
+func (ms *MultiAllStorage) ExpireCommands(ctx context.Context) ([]*storage.ExpiredCommand, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.ExpireCommands(ctx)
+	})
+	return val.([]*storage.ExpiredCommand), err
+}
+
+func (ms *MultiAllStorage) RetrieveNotNow(ctx context.Context, id, uuid string) (int, time.Time, error) {
+	type notNow struct {
+		tally int
+		first time.Time
+	}
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		tally, first, err := s.RetrieveNotNow(ctx, id, uuid)
+		return notNow{tally: tally, first: first}, err
+	})
+	return val.(notNow).tally, val.(notNow).first, err
+}
+
+func (ms *MultiAllStorage) DeadLetterCommand(ctx context.Context, id, uuid string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return nil, s.DeadLetterCommand(ctx, id, uuid)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveDeadLetters(ctx context.Context, ids []string) ([]*storage.DeadLetter, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrieveDeadLetters(ctx, ids)
+	})
+	return val.([]*storage.DeadLetter), err
+}
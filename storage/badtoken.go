@@ -0,0 +1,23 @@
+package storage
+
+import "context"
+
+// BadPushToken is an APNs push token of an enrollment that APNs
+// reported as no longer valid.
+type BadPushToken struct {
+	ID string
+	// Token is the hex-encoded push token.
+	Token string
+	// Reason is the APNs failure reason (e.g. "BadDeviceToken" or "Unregistered").
+	Reason string
+}
+
+// BadPushTokenStore records push tokens that APNs reported as no longer valid.
+type BadPushTokenStore interface {
+	// StoreBadPushToken stores (or replaces) the bad push token for token.ID.
+	StoreBadPushToken(ctx context.Context, token *BadPushToken) error
+
+	// RetrieveBadPushTokens retrieves the bad push tokens of ids.
+	// Ids without a bad push token are not included in the result.
+	RetrieveBadPushTokens(ctx context.Context, ids []string) (map[string]*BadPushToken, error)
+}
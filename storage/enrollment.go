@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Enrollment is a summary of an MDM enrollment.
+type Enrollment struct {
+	ID string `json:"id"`
+	// ParentID is the device channel enrollment ID of a user channel
+	// enrollment. It is empty for device channel enrollments.
+	ParentID string `json:"parent_id,omitempty"`
+	// Type is the textual representation of the enrollment type.
+	// For example "Device" or "User Enrollment".
+	Type             string    `json:"type"`
+	Topic            string    `json:"topic"`
+	SerialNumber     string    `json:"serial_number,omitempty"`
+	Enabled          bool      `json:"enabled"`
+	TokenUpdateTally int       `json:"token_update_tally"`
+	LastSeenAt       time.Time `json:"last_seen_at"`
+}
+
+// EnrollmentFilter restricts the enrollments returned by an EnrollmentLister.
+// Zero-valued fields are ignored. Multiple values within the same
+// field match any of those values while separate fields must all match.
+type EnrollmentFilter struct {
+	IDs []string
+	// Types are the textual enrollment types (see Enrollment).
+	Types         []string
+	Topics        []string
+	SerialNumbers []string
+	// ParentIDs matches user channel enrollments of these device
+	// channel enrollment IDs.
+	ParentIDs []string
+	// DeviceChannel, if set, matches only device channel (true) or
+	// only user channel (false) enrollments.
+	DeviceChannel  *bool
+	Enabled        *bool
+	LastSeenAfter  time.Time
+	LastSeenBefore time.Time
+}
+
+func matchString(values []string, s string) bool {
+	if len(values) < 1 {
+		return true
+	}
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether e satisfies the filter.
+// A nil filter matches every enrollment.
+func (f *EnrollmentFilter) Match(e *Enrollment) bool {
+	if f == nil {
+		return true
+	}
+	if e == nil {
+		return false
+	}
+	if len(f.ParentIDs) > 0 && e.ParentID == "" {
+		return false
+	}
+	if !matchString(f.IDs, e.ID) ||
+		!matchString(f.Types, e.Type) ||
+		!matchString(f.Topics, e.Topic) ||
+		!matchString(f.SerialNumbers, e.SerialNumber) ||
+		!matchString(f.ParentIDs, e.ParentID) {
+		return false
+	}
+	if f.DeviceChannel != nil && *f.DeviceChannel != (e.ParentID == "") {
+		return false
+	}
+	if f.Enabled != nil && *f.Enabled != e.Enabled {
+		return false
+	}
+	if !f.LastSeenAfter.IsZero() && e.LastSeenAt.Before(f.LastSeenAfter) {
+		return false
+	}
+	if !f.LastSeenBefore.IsZero() && !e.LastSeenAt.Before(f.LastSeenBefore) {
+		return false
+	}
+	return true
+}
+
+// EnrollmentLister lists MDM enrollments.
+type EnrollmentLister interface {
+	// ListEnrollments returns enrollments matching filter ordered by
+	// enrollment ID. Only enrollments with an ID greater than cursor
+	// are returned which allows paging through results by supplying
+	// the last ID of the previous page. At most limit enrollments are
+	// returned; a limit less than one means no limit.
+	//
+	// Only enrollments which have sent a TokenUpdate are returned.
+	ListEnrollments(ctx context.Context, filter *EnrollmentFilter, cursor string, limit int) ([]*Enrollment, error)
+}
+
+// ListEnrollmentIDs pages through the enrollments of lister matching
+// filter and calls fn with the IDs of each page of at most batchSize
+// enrollments. It stops at the first error returned by fn.
+func ListEnrollmentIDs(ctx context.Context, lister EnrollmentLister, filter *EnrollmentFilter, batchSize int, fn func([]string) error) error {
+	if batchSize < 1 {
+		return errors.New("invalid batch size")
+	}
+	var cursor string
+	for {
+		enrollments, err := lister.ListEnrollments(ctx, filter, cursor, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(enrollments) < 1 {
+			return nil
+		}
+		ids := make([]string, len(enrollments))
+		for i, e := range enrollments {
+			ids[i] = e.ID
+		}
+		if err = fn(ids); err != nil {
+			return err
+		}
+		if len(enrollments) < batchSize {
+			return nil
+		}
+		cursor = ids[len(ids)-1]
+	}
+}
@@ -0,0 +1,38 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StoreBadPushToken writes the bad push token to the enrollment's bad push token file.
+func (s *FileStorage) StoreBadPushToken(_ context.Context, token *storage.BadPushToken) error {
+	tokenBytes, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return s.newEnrollment(token.ID).writeFile(BadPushTokenFilename, tokenBytes)
+}
+
+// RetrieveBadPushTokens reads the bad push token files of ids.
+func (s *FileStorage) RetrieveBadPushTokens(_ context.Context, ids []string) (map[string]*storage.BadPushToken, error) {
+	tokens := make(map[string]*storage.BadPushToken)
+	for _, id := range ids {
+		tokenBytes, err := s.newEnrollment(id).readFile(BadPushTokenFilename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		token := new(storage.BadPushToken)
+		if err = json.Unmarshal(tokenBytes, token); err != nil {
+			return nil, err
+		}
+		tokens[id] = token
+	}
+	return tokens, nil
+}
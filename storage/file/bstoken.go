@@ -1,25 +1,34 @@
 package file
 
 import (
-	"errors"
-	"os"
-
 	"github.com/micromdm/nanomdm/mdm"
 )
 
 func (s *FileStorage) StoreBootstrapToken(r *mdm.Request, msg *mdm.SetBootstrapToken) error {
+	unlock, err := s.lock(r.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	e := s.newEnrollment(r.ID)
 	if len(msg.BootstrapToken.BootstrapToken) > 0 {
-		return e.writeFile(BootstrapTokenFile, msg.BootstrapToken.BootstrapToken)
+		if err := e.writeFile(BootstrapTokenFile, msg.BootstrapToken.BootstrapToken); err != nil {
+			return err
+		}
 	} else {
-		if err := os.Remove(e.dirPrefix(BootstrapTokenFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		if err := removeFile(e.dirPrefix(BootstrapTokenFile)); err != nil {
 			return err
 		}
 	}
-	return nil
+	return e.updateLastSeen()
 }
 
 func (s *FileStorage) RetrieveBootstrapToken(r *mdm.Request, _ *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error) {
+	unlock, err := s.lock(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	e := s.newEnrollment(r.ID)
 	bsTokenRaw, err := e.readFile(BootstrapTokenFile)
 	if err != nil {
@@ -28,5 +37,5 @@ func (s *FileStorage) RetrieveBootstrapToken(r *mdm.Request, _ *mdm.GetBootstrap
 	bsToken := &mdm.BootstrapToken{
 		BootstrapToken: bsTokenRaw,
 	}
-	return bsToken, nil
+	return bsToken, e.updateLastSeen()
 }
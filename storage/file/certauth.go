@@ -54,6 +54,19 @@ func (s *FileStorage) IsCertHashAssociated(r *mdm.Request, hash string) (bool, e
 }
 
 func (s *FileStorage) AssociateCertHash(r *mdm.Request, hash string) error {
+	err := s.withSharedLock(CertAuthAssociationsFilename, func() error {
+		return s.appendCertHash(r.ID, hash)
+	})
+	if err != nil {
+		return err
+	}
+	e := s.newEnrollment(r.ID)
+	return e.writeFile(CertAuthFilename, []byte(hash))
+}
+
+// appendCertHash appends the association of id and hash to the
+// associations file. The caller must hold the associations file lock.
+func (s *FileStorage) appendCertHash(id, hash string) error {
 	f, err := os.OpenFile(
 		path.Join(s.path, CertAuthAssociationsFilename),
 		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
@@ -63,11 +76,13 @@ func (s *FileStorage) AssociateCertHash(r *mdm.Request, hash string) error {
 		return err
 	}
 	defer f.Close()
-	if _, err := f.WriteString(r.ID + "," + hash + "\n"); err != nil {
+	if _, err = f.WriteString(id + "," + hash + "\n"); err != nil {
 		return err
 	}
-	e := s.newEnrollment(r.ID)
-	return e.writeFile(CertAuthFilename, []byte(hash))
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	return syncDir(s.path)
 }
 
 func (s *FileStorage) EnrollmentFromHash(_ context.Context, hash string) (string, error) {
@@ -0,0 +1,99 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// readTokenUpdate reads and decodes the saved TokenUpdate message.
+func (e *enrollment) readTokenUpdate() (*mdm.TokenUpdate, error) {
+	tokenUpdate, err := e.readFile(TokenUpdateFilename)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := mdm.DecodeCheckin(tokenUpdate)
+	if err != nil {
+		return nil, err
+	}
+	message, ok := msg.(*mdm.TokenUpdate)
+	if !ok {
+		return nil, errors.New("saved TokenUpdate is not a TokenUpdate")
+	}
+	return message, nil
+}
+
+// summary assembles a storage enrollment summary from the files of e.
+// A nil enrollment is returned if the enrollment has no TokenUpdate.
+func (e *enrollment) summary() (*storage.Enrollment, error) {
+	msg, err := e.readTokenUpdate()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	resolved := msg.Enrollment.Resolved()
+	if err = resolved.Validate(); err != nil {
+		return nil, err
+	}
+	enr := &storage.Enrollment{
+		ID:    e.id,
+		Type:  resolved.Type.String(),
+		Topic: msg.Topic,
+	}
+	device := e
+	if resolved.IsUserChannel {
+		enr.ParentID = resolved.DeviceChannelID
+		device = e.fs.newEnrollment(resolved.DeviceChannelID)
+	}
+	serial, err := device.readFile(SerialNumberFilename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	enr.SerialNumber = strings.TrimSpace(string(serial))
+	disabled, err := e.fileExists(DisabledFilename)
+	if err != nil {
+		return nil, err
+	}
+	enr.Enabled = !disabled
+	if enr.TokenUpdateTally, err = e.readNumericFile(TokenUpdateTallyFilename); err != nil {
+		return nil, err
+	}
+	enr.LastSeenAt, err = e.readLastSeen()
+	return enr, err
+}
+
+// ListEnrollments lists enrollments by reading each enrollment directory.
+func (s *FileStorage) ListEnrollments(ctx context.Context, filter *storage.EnrollmentFilter, cursor string, limit int) ([]*storage.Enrollment, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var enrollments []*storage.Enrollment
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() <= cursor {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		// the enrollment is not locked: its files are written
+		// atomically and the summary is only a snapshot anyway.
+		enr, err := s.newEnrollment(entry.Name()).summary()
+		if err != nil {
+			return nil, err
+		}
+		if enr == nil || !filter.Match(enr) {
+			continue
+		}
+		enrollments = append(enrollments, enr)
+		if limit > 0 && len(enrollments) >= limit {
+			break
+		}
+	}
+	return enrollments, nil
+}
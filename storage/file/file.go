@@ -8,6 +8,8 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/micromdm/nanomdm/cryptoutil"
 	"github.com/micromdm/nanomdm/mdm"
@@ -21,8 +23,14 @@ const (
 	IdentityCertFilename = "Identity.pem"
 	DisabledFilename     = "Disabled"
 	BootstrapTokenFile   = "BootstrapToken.dat"
+	LastSeenFilename     = "LastSeen.txt"
+	InventoryFilename    = "Inventory.json"
+	PushRetryFilename    = "PushRetry.json"
+	BadPushTokenFilename = "BadPushToken.json"
+	PushHistoryFilename  = "PushHistory.json"
 
 	TokenUpdateTallyFilename = "TokenUpdate.tally.txt"
+	QueueSequenceFilename    = "Queue.seq.txt"
 
 	UserAuthFilename       = "UserAuthenticate.plist"
 	UserAuthDigestFilename = "UserAuthenticate.Digest.plist"
@@ -36,9 +44,18 @@ const (
 	SubEnrollmentPathname = "SubEnrollments"
 )
 
-// FileStorage implements filesystem-based storage for MDM services
+// FileStorage implements filesystem-based storage for MDM services.
+//
+// Files are written atomically and the files of each enrollment are
+// only modified while holding that enrollment's lock, a lock file in
+// the enrollment directory. This makes it safe to share the storage
+// path between multiple processes on platforms that support advisory
+// file locking.
 type FileStorage struct {
 	path string
+
+	locksMu sync.Mutex
+	locks   map[string]*lock
 }
 
 // New creates a new FileStorage backend
@@ -47,7 +64,7 @@ func New(path string) (*FileStorage, error) {
 	if err != nil && !errors.Is(err, os.ErrExist) {
 		return nil, err
 	}
-	return &FileStorage{path: path}, nil
+	return &FileStorage{path: path, locks: make(map[string]*lock)}, nil
 }
 
 type enrollment struct {
@@ -64,7 +81,7 @@ func (e *enrollment) dir() string {
 }
 
 func (e *enrollment) mkdir() error {
-	return os.MkdirAll(e.dir(), 0755)
+	return mkdirAll(e.dir())
 }
 
 func (e *enrollment) dirPrefix(name string) string {
@@ -78,7 +95,7 @@ func (e *enrollment) writeFile(name string, bytes []byte) error {
 	if err := e.mkdir(); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(e.dirPrefix(name), bytes, 0644)
+	return writeFileAtomic(e.dirPrefix(name), bytes, 0644)
 }
 
 func (e *enrollment) readFile(name string) ([]byte, error) {
@@ -98,6 +115,8 @@ func (e *enrollment) fileExists(name string) (bool, error) {
 	return true, nil
 }
 
+// bumpNumericFile increments the number in file name.
+// The caller must hold the enrollment's lock.
 func (e *enrollment) bumpNumericFile(name string) error {
 	ctr, err := e.readNumericFile(name)
 	if err != nil {
@@ -107,6 +126,17 @@ func (e *enrollment) bumpNumericFile(name string) error {
 	return e.writeFile(name, []byte(strconv.Itoa(ctr)))
 }
 
+// nextSequence increments and returns the enqueue sequence.
+// The caller must hold the enrollment's lock.
+func (e *enrollment) nextSequence() (int, error) {
+	seq, err := e.readNumericFile(QueueSequenceFilename)
+	if err != nil {
+		return 0, err
+	}
+	seq += 1
+	return seq, e.writeFile(QueueSequenceFilename, []byte(strconv.Itoa(seq)))
+}
+
 func (e *enrollment) resetNumericFile(name string) error {
 	return e.writeFile(name, []byte{48})
 }
@@ -120,17 +150,31 @@ func (e *enrollment) readNumericFile(name string) (int, error) {
 	return ctr, nil
 }
 
+// updateLastSeen records the current time as the last time we heard
+// from the enrollment.
+func (e *enrollment) updateLastSeen() error {
+	return e.writeFile(LastSeenFilename, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// readLastSeen returns the last time we heard from the enrollment.
+// A zero time is returned if the enrollment has never been seen.
+func (e *enrollment) readLastSeen() (time.Time, error) {
+	val, err := e.readFile(LastSeenFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, string(val))
+}
+
 // assocSubEnrollment writes an empty file of the sub (user) enrollment for tracking.
 func (e *enrollment) assocSubEnrollment(id string) error {
 	subPath := e.dirPrefix(SubEnrollmentPathname)
-	if err := os.MkdirAll(subPath, 0755); err != nil {
+	if err := mkdirAll(subPath); err != nil {
 		return err
 	}
-	f, err := os.Create(path.Join(subPath, id))
-	if err != nil {
-		return err
-	}
-	return f.Close()
+	return writeFileAtomic(path.Join(subPath, id), nil, 0644)
 }
 
 // listSubEnrollments returns an array of the sub-enrollment IDs
@@ -148,11 +192,19 @@ func (e *enrollment) listSubEnrollments() (ids []string) {
 }
 
 func (e *enrollment) removeSubEnrollments() error {
-	return os.RemoveAll(e.dirPrefix(SubEnrollmentPathname))
+	if err := os.RemoveAll(e.dirPrefix(SubEnrollmentPathname)); err != nil {
+		return err
+	}
+	return syncDir(e.dir())
 }
 
 // StoreAuthenticate stores the Authenticate message
 func (s *FileStorage) StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate) error {
+	unlock, err := s.lock(r.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	e := s.newEnrollment(r.ID)
 	if r.Certificate != nil {
 		if err := e.writeFile(IdentityCertFilename, cryptoutil.PEMCertificate(r.Certificate.Raw)); err != nil {
@@ -171,6 +223,21 @@ func (s *FileStorage) StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate) e
 
 // StoreTokenUpdate stores the TokenUpdate message
 func (s *FileStorage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	if r.ParentID != "" {
+		// the parent lock is released before taking our own to keep
+		// to the device channel then user channel lock order.
+		err := s.withLock(r.ParentID, func() error {
+			return s.newEnrollment(r.ParentID).assocSubEnrollment(r.ID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	unlock, err := s.lock(r.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	e := s.newEnrollment(r.ID)
 	// the UnlockToken should be saved separately in case future
 	// TokenUpdates do not contain it and it gets overwritten
@@ -179,12 +246,6 @@ func (s *FileStorage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) err
 			return err
 		}
 	}
-	if r.ParentID != "" {
-		parentEnrollment := s.newEnrollment(r.ParentID)
-		if err := parentEnrollment.assocSubEnrollment(r.ID); err != nil {
-			return err
-		}
-	}
 	if err := e.writeFile(TokenUpdateFilename, []byte(msg.Raw)); err != nil {
 		return err
 	}
@@ -192,10 +253,10 @@ func (s *FileStorage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) err
 		return err
 	}
 	// delete the disabled flag to let signify this enrollment is enabled
-	if err := os.Remove(e.dirPrefix(DisabledFilename)); err != nil && !errors.Is(err, os.ErrNotExist) {
+	if err := removeFile(e.dirPrefix(DisabledFilename)); err != nil {
 		return err
 	}
-	return nil
+	return e.updateLastSeen()
 }
 
 func (s *FileStorage) RetrieveTokenUpdateTally(_ context.Context, id string) (int, error) {
@@ -204,6 +265,11 @@ func (s *FileStorage) RetrieveTokenUpdateTally(_ context.Context, id string) (in
 }
 
 func (s *FileStorage) StoreUserAuthenticate(r *mdm.Request, msg *mdm.UserAuthenticate) error {
+	unlock, err := s.lock(r.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	e := s.newEnrollment(r.ID)
 	filename := UserAuthFilename
 	// if the DigestResponse is empty then this is the first (of two)
@@ -211,27 +277,42 @@ func (s *FileStorage) StoreUserAuthenticate(r *mdm.Request, msg *mdm.UserAuthent
 	if msg.DigestResponse != "" {
 		filename = UserAuthDigestFilename
 	}
-	return e.writeFile(filename, msg.Raw)
+	if err := e.writeFile(filename, msg.Raw); err != nil {
+		return err
+	}
+	return e.updateLastSeen()
 }
 
 func (s *FileStorage) Disable(r *mdm.Request) error {
 	if r.ParentID != "" {
 		return errors.New("can only disable a device channel")
 	}
-	// assemble list of IDs for which to disable
-	e := s.newEnrollment(r.ID)
-	disableIDs := e.listSubEnrollments()
-	disableIDs = append(disableIDs, r.ID)
-	// disable all of the ids
-	for _, id := range disableIDs {
-		e := s.newEnrollment(id)
-		// write zero-byte disabled marker
-		if err := e.writeFile(DisabledFilename, nil); err != nil {
-			return err
+	// hold the device lock throughout so that no sub-enrollments
+	// are associated while we disable them. the lock files are
+	// removed as they're recreated when the enrollment next checks in.
+	return s.withLockRemoved(r.ID, func() error {
+		e := s.newEnrollment(r.ID)
+		for _, id := range e.listSubEnrollments() {
+			if err := s.withLockRemoved(id, s.newEnrollment(id).disable); err != nil {
+				return err
+			}
 		}
-		if err := e.resetNumericFile(TokenUpdateTallyFilename); err != nil {
+		if err := e.disable(); err != nil {
 			return err
 		}
+		return e.removeSubEnrollments()
+	})
+}
+
+// disable marks the enrollment as disabled.
+// The caller must hold the enrollment's lock.
+func (e *enrollment) disable() error {
+	// write zero-byte disabled marker
+	if err := e.writeFile(DisabledFilename, nil); err != nil {
+		return err
+	}
+	if err := e.resetNumericFile(TokenUpdateTallyFilename); err != nil {
+		return err
 	}
-	return e.removeSubEnrollments()
+	return e.updateLastSeen()
 }
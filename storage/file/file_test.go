@@ -2,8 +2,14 @@ package file
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/micromdm/nanomdm/mdm"
 	"github.com/micromdm/nanomdm/storage/test"
 )
 
@@ -15,4 +21,235 @@ func TestFileStorage(t *testing.T) {
 
 	test.TestQueue(t, "EA4E19F1-7F8B-493D-BEAB-264B33BCF4E6", s)
 	test.TestRetrievePushInfo(t, context.Background(), s)
+	test.TestRetrieveQueue(t, "2BD3F5D4-1F0A-4F5E-9A39-3C7B1B6D4E11", s)
+	test.TestRetrieveCommandResults(t, "7C1E2A5B-93D4-4B8F-A2E6-0F5D8C3B9A47", s)
+	test.TestDequeueCommand(t, "D4A1C7E2-5B3F-4E9A-8C6D-1F2B3A4C5D6E", s)
+	test.TestScheduledCommands(t, "5E8B2C1A-7D4F-4A3B-9E6C-2B1D0F8A7C53", s)
+	test.TestPendingCommands(t, "B2D4F6A8-0C1E-4A3B-8D5F-7E9A1C3B5D46", s)
+	test.TestExpireCommands(t, "9A3D6F1B-2C8E-4B7A-A5D4-6E0C1F9B8D72", s)
+	test.TestDeadLetters(t, "3F7C9E2D-8B1A-4D6E-B2C5-7A0E4F1D9C36", s)
+	test.TestInventory(t, "6B2E8D4F-1A9C-4E7B-8D3F-5C0A2E6B9F14", s)
+	test.TestPushRetries(t, "8C4A1E7D-3F2B-4D9A-B6E5-0A7C9D2F1B48", "E1F3B5D7-9A2C-4E6B-8D0F-2A4C6E8B0D13", s)
+	test.TestBadPushTokens(t, "5D9B3F1A-7E2C-4A8D-9B6F-1C3E5A7D9B20", s)
+	test.TestPushHistory(t, "A7E1C3F5-2B4D-4F6A-8C0E-3D5F7B9A1C24", "4F8B2D6E-0C3A-4E1F-9A7D-6B2C8E4F0A35", s)
+	test.TestPushCerts(t, s)
+
+	s, err = New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.TestEnrollmentLister(t, context.Background(), s)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	const id = "0E6C1A5F-2B7D-4C3E-9F8A-1D2B3C4D5E6F"
+
+	dir := t.TempDir()
+	s1, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a second FileStorage of the same path stands in for another
+	// process, relying on advisory locks.
+	s2 := s1
+	if advisoryLocking {
+		if s2, err = New(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := test.EnrollDevice(t, s1, ctx, id)
+	msg, err := mdm.DecodeCheckin([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>TokenUpdate</string>
+	<key>UDID</key>
+	<string>` + id + `</string>
+	<key>Topic</key>
+	<string>com.example.test</string>
+	<key>PushMagic</key>
+	<string>MAGIC</string>
+	<key>Token</key>
+	<data>VE9LRU4=</data>
+</dict>
+</plist>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenUpdate := msg.(*mdm.TokenUpdate)
+
+	cmd := &mdm.Command{CommandUUID: "CONCURRENT-CMD", Raw: []byte("CONCURRENT-CMD")}
+	if _, err = s1.EnqueueCommand(ctx, []string{id}, cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers, iterations = 4, 25
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		s := s1
+		if i%2 == 1 {
+			s = s2
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				if err := s.StoreTokenUpdate(r, tokenUpdate); err != nil {
+					t.Error(err)
+					return
+				}
+				err := s.StoreCommandReport(r, &mdm.CommandResults{
+					CommandUUID: cmd.CommandUUID,
+					Status:      "NotNow",
+					Raw:         []byte("NotNow"),
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	tally, err := s1.RetrieveTokenUpdateTally(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := tally, workers*iterations+1; have != want {
+		t.Errorf("token update tally: have: %d, want: %d", have, want)
+	}
+	tally, _, err = s1.RetrieveNotNow(ctx, id, cmd.CommandUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := tally, workers*iterations; have != want {
+		t.Errorf("NotNow tally: have: %d, want: %d", have, want)
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	ctx := context.Background()
+	const id = "4C2A8E6F-1B3D-4F5A-9C7E-2D4B6F8A0C13"
+
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := test.EnrollDevice(t, s, ctx, id)
+
+	// enqueued back to back and in reverse of their directory order
+	uuids := []string{"FIFO-CMD5", "FIFO-CMD4", "FIFO-CMD3", "FIFO-CMD2", "FIFO-CMD1"}
+	for _, uuid := range uuids {
+		cmd := &mdm.Command{CommandUUID: uuid, Raw: []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>` + uuid + `</string>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>DeviceInformation</string>
+	</dict>
+</dict>
+</plist>`)}
+		if _, err = s.EnqueueCommand(ctx, []string{id}, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// simulate a filesystem with coarse modification times
+	mtime := time.Now()
+	for _, uuid := range uuids {
+		if err = os.Chtimes(path.Join(dir, id, subQueue, uuid+".plist"), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err := s.RetrieveQueue(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(items), len(uuids); have != want {
+		t.Fatalf("queue length: have: %d, want: %d", have, want)
+	}
+	for i, uuid := range uuids {
+		if have := items[i].CommandUUID; have != uuid {
+			t.Errorf("queue item %d: have: %q, want: %q", i, have, uuid)
+		}
+	}
+
+	for _, uuid := range uuids {
+		cmd, err := s.RetrieveNextCommand(r, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd == nil {
+			t.Fatalf("no command, want: %q", uuid)
+		}
+		if have := cmd.CommandUUID; have != uuid {
+			t.Errorf("next command: have: %q, want: %q", have, uuid)
+		}
+		err = s.StoreCommandReport(r, &mdm.CommandResults{CommandUUID: cmd.CommandUUID, Status: "Acknowledged"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLockFiles(t *testing.T) {
+	ctx := context.Background()
+	const id = "7D3B9F1E-5A2C-4E8D-B6F4-0C2A4E6B8D19"
+
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := test.EnrollDevice(t, s, ctx, id)
+
+	// read-only calls of unknown enrollments must not create anything
+	const unknownID = "UNKNOWN-ID"
+	if _, err = s.RetrieveQueue(ctx, unknownID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.RetrieveNotNow(ctx, unknownID, "CMD"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.RetrieveDeadLetters(ctx, []string{unknownID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.DequeueCommand(ctx, []string{unknownID}, "CMD"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeadLetterCommand(ctx, unknownID, "CMD"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.RetrievePushCert(ctx, "com.example.unknown"); err == nil {
+		t.Error("expected error retrieving unknown push cert")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != id && entry.Name() != "."+pushCertLockName+".lock" {
+			t.Errorf("unexpected file in storage path: %s", entry.Name())
+		}
+	}
+
+	lockPath := path.Join(dir, id, lockFilename)
+	if _, err = os.Stat(lockPath); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Disable(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(lockPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock file after disable: have: %v, want: %v", err, os.ErrNotExist)
+	}
 }
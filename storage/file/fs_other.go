@@ -0,0 +1,31 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package file
+
+import "os"
+
+// advisoryLocking is true if locks are also held against other processes.
+// On this platform locks are only held within this process.
+const advisoryLocking = false
+
+// lockFile opens (creating if needed) the file at name.
+// The file is not locked.
+func lockFile(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+}
+
+// releaseLockFile closes f from lockFile. If remove is true the file
+// is then removed.
+func releaseLockFile(f *os.File, remove bool) {
+	f.Close()
+	if remove {
+		// a failed removal only leaves the lock file behind
+		os.Remove(f.Name())
+	}
+}
+
+// syncDir does nothing: directories can't be synced on this platform.
+func syncDir(_ string) error {
+	return nil
+}
@@ -0,0 +1,70 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package file
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// advisoryLocking is true if locks are also held against other processes.
+const advisoryLocking = true
+
+// lockFile opens (creating if needed) and exclusively flocks the file
+// at name, waiting for any other holder. Closing the file releases the lock.
+func lockFile(name string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+			if !errors.Is(err, syscall.EINTR) {
+				break
+			}
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		// the previous holder may have removed the file while we
+		// waited. then our lock is of a file no one else will open.
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		nameFi, err := os.Stat(name)
+		if err == nil && os.SameFile(fi, nameFi) {
+			return f, nil
+		}
+		f.Close()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+}
+
+// releaseLockFile releases the lock of f from lockFile. If remove is
+// true the file is removed while still locked.
+func releaseLockFile(f *os.File, remove bool) {
+	if remove {
+		// a failed removal only leaves the lock file behind
+		os.Remove(f.Name())
+	}
+	f.Close()
+}
+
+// syncDir flushes directory dir so that the files created, renamed,
+// or removed within it survive a crash.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
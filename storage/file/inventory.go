@@ -0,0 +1,53 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StoreInventoryValues merges values into the enrollment's inventory JSON file.
+func (s *FileStorage) StoreInventoryValues(_ context.Context, id string, values storage.InventoryValues) error {
+	if len(values) < 1 {
+		return nil
+	}
+	unlock, err := s.lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	e := s.newEnrollment(id)
+	inv, err := e.readInventory()
+	if err != nil {
+		return err
+	}
+	for name, value := range values {
+		inv[name] = value
+	}
+	invBytes, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return e.writeFile(InventoryFilename, invBytes)
+}
+
+// RetrieveInventory reads the enrollment's inventory JSON file.
+func (s *FileStorage) RetrieveInventory(_ context.Context, id string) (storage.InventoryValues, error) {
+	return s.newEnrollment(id).readInventory()
+}
+
+// readInventory reads and decodes the inventory JSON file.
+// An empty inventory is returned if the file does not exist.
+func (e *enrollment) readInventory() (storage.InventoryValues, error) {
+	inv := make(storage.InventoryValues)
+	invBytes, err := e.readFile(InventoryFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return inv, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return inv, json.Unmarshal(invBytes, &inv)
+}
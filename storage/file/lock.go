@@ -0,0 +1,121 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sync"
+)
+
+// lockFilename is the lock file within each enrollment directory.
+// Lock files are hidden so that they're not mistaken for commands or
+// other enrollment files.
+const lockFilename = ".lock"
+
+// lock is an in-process lock of a lock file.
+type lock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lockPath waits for and takes the exclusive lock of the lock file at
+// lockPath. The lock is held against other goroutines and, with
+// advisory locking, against other processes using the same storage
+// path. Locks are not reentrant. The returned function releases the
+// lock, first removing the lock file if remove is true.
+func (s *FileStorage) lockPath(lockPath string) (func(remove bool), error) {
+	s.locksMu.Lock()
+	l, ok := s.locks[lockPath]
+	if !ok {
+		l = new(lock)
+		s.locks[lockPath] = l
+	}
+	l.refs++
+	s.locksMu.Unlock()
+
+	l.mu.Lock()
+	unlock := func() {
+		l.mu.Unlock()
+		s.locksMu.Lock()
+		l.refs--
+		if l.refs < 1 {
+			delete(s.locks, lockPath)
+		}
+		s.locksMu.Unlock()
+	}
+	f, err := lockFile(lockPath)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return func(remove bool) {
+		releaseLockFile(f, remove)
+		unlock()
+	}, nil
+}
+
+// lock waits for and takes the exclusive lock of enrollment id,
+// creating the enrollment directory if needed. The returned function
+// releases the lock.
+func (s *FileStorage) lock(id string) (func(), error) {
+	e := s.newEnrollment(id)
+	if err := e.mkdir(); err != nil {
+		return nil, err
+	}
+	release, err := s.lockPath(e.dirPrefix(lockFilename))
+	if err != nil {
+		return nil, err
+	}
+	return func() { release(false) }, nil
+}
+
+// withLock calls fn while holding the lock of enrollment id.
+func (s *FileStorage) withLock(id string, fn func() error) error {
+	unlock, err := s.lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
+
+// withLockIfExists calls fn while holding the lock of enrollment id.
+// If the enrollment does not exist fn is not called and nothing is
+// created, so it is safe to use with arbitrary IDs.
+func (s *FileStorage) withLockIfExists(id string, fn func() error) error {
+	e := s.newEnrollment(id)
+	if _, err := os.Stat(e.dir()); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return s.withLock(id, fn)
+}
+
+// withLockRemoved calls fn while holding the lock of enrollment id.
+// The lock file is removed when the lock is released.
+func (s *FileStorage) withLockRemoved(id string, fn func() error) error {
+	e := s.newEnrollment(id)
+	if err := e.mkdir(); err != nil {
+		return err
+	}
+	release, err := s.lockPath(e.dirPrefix(lockFilename))
+	if err != nil {
+		return err
+	}
+	defer release(true)
+	return fn()
+}
+
+// withSharedLock calls fn while holding the lock of name, a resource
+// shared by all enrollments. Its lock file is kept in the storage path.
+func (s *FileStorage) withSharedLock(name string, fn func() error) error {
+	// lock files are not directories so they are skipped when
+	// listing enrollments.
+	release, err := s.lockPath(path.Join(s.path, "."+name+".lock"))
+	if err != nil {
+		return err
+	}
+	defer release(false)
+	return fn()
+}
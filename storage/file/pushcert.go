@@ -4,20 +4,35 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/storage"
 )
 
+// pushCertLockName is the lock name shared by the push certificates
+// of every topic. One lock keeps topics supplied by API clients from
+// each leaving a lock file behind.
+const pushCertLockName = "PushCerts"
+
 // RetrievePushCert is passed through to a new PushCertFileStorage
 func (s *FileStorage) RetrievePushCert(ctx context.Context, topic string) (*tls.Certificate, string, error) {
 	ps := &PushCertFileStorage{
 		certFilepath: path.Join(s.path, topic+".pem"),
 		keyFilepath:  path.Join(s.path, topic+".key"),
 	}
-	return ps.RetrievePushCert(ctx, topic)
+	var cert *tls.Certificate
+	var staleToken string
+	// lock so that the certificate and key are read as a pair
+	err := s.withSharedLock(pushCertLockName, func() (err error) {
+		cert, staleToken, err = ps.RetrievePushCert(ctx, topic)
+		return
+	})
+	return cert, staleToken, err
 }
 
 // IsPushCertStale is passed through to a new PushCertFileStorage
@@ -39,7 +54,54 @@ func (s *FileStorage) StorePushCert(ctx context.Context, pemCert, pemKey []byte)
 		keyFilepath:  path.Join(s.path, topic+".key"),
 		allowStore:   true,
 	}
-	return ps.StorePushCert(ctx, pemCert, pemKey)
+	return s.withSharedLock(pushCertLockName, func() error {
+		return ps.StorePushCert(ctx, pemCert, pemKey)
+	})
+}
+
+// ListPushCerts reads each push certificate (topic.pem file) in the storage path.
+func (s *FileStorage) ListPushCerts(_ context.Context) ([]*storage.PushCertInfo, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var infos []*storage.PushCertInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".pem") {
+			continue
+		}
+		certFilepath := path.Join(s.path, entry.Name())
+		pemCert, err := ioutil.ReadFile(certFilepath)
+		if err != nil {
+			return nil, err
+		}
+		ps := &PushCertFileStorage{certFilepath: certFilepath}
+		staleToken, err := ps.getPushCertStaleToken(ps.certFilepath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := storage.NewPushCertInfo(pemCert, staleToken)
+		if err != nil {
+			return nil, fmt.Errorf("push cert file %s: %w", entry.Name(), err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// DeletePushCert removes the push certificate and key files of topic.
+func (s *FileStorage) DeletePushCert(_ context.Context, topic string) error {
+	if topic == "" || strings.Contains(topic, "/") {
+		return fmt.Errorf("invalid topic: %q", topic)
+	}
+	return s.withSharedLock(pushCertLockName, func() error {
+		for _, ext := range []string{".pem", ".key"} {
+			if err := removeFile(path.Join(s.path, topic+ext)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // PushCertFileStorage is a filesystem-based PushCertStore
@@ -95,14 +157,16 @@ func (s *PushCertFileStorage) IsPushCertStale(_ context.Context, topic, provided
 	return providedStaleToken != staleToken, nil
 }
 
-// StorePushCert writes the push cert to disk
+// StorePushCert writes the push cert to disk.
+// The key is written first as replacing the certificate changes the
+// stale token.
 func (s *PushCertFileStorage) StorePushCert(_ context.Context, pemCert, pemKey []byte) error {
 	if !s.allowStore {
 		return errors.New("store push cert: not permitted")
 	}
-	err := ioutil.WriteFile(s.certFilepath, pemCert, 0644)
+	err := writeFileAtomic(s.keyFilepath, pemKey, 0600)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(s.keyFilepath, pemKey, 0600)
+	return writeFileAtomic(s.certFilepath, pemCert, 0644)
 }
@@ -0,0 +1,76 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// readPushHistory reads the push history of e from newest to oldest.
+func (e *enrollment) readPushHistory() ([]*storage.PushResult, error) {
+	historyBytes, err := e.readFile(PushHistoryFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var results []*storage.PushResult
+	return results, json.Unmarshal(historyBytes, &results)
+}
+
+// StorePushResults prepends results to the push history files of
+// their enrollments and truncates them to keep push results.
+func (s *FileStorage) StorePushResults(_ context.Context, results []*storage.PushResult, keep int) error {
+	idResults := make(map[string][]*storage.PushResult)
+	var ids []string
+	for _, result := range results {
+		if _, ok := idResults[result.ID]; !ok {
+			ids = append(ids, result.ID)
+		}
+		// newest first
+		idResults[result.ID] = append([]*storage.PushResult{result}, idResults[result.ID]...)
+	}
+	for _, id := range ids {
+		err := s.withLock(id, func() error {
+			return s.newEnrollment(id).prependPushHistory(idResults[id], keep)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prependPushHistory prepends results to the push history of e and
+// truncates it to keep push results. The caller must hold the
+// enrollment's lock.
+func (e *enrollment) prependPushHistory(results []*storage.PushResult, keep int) error {
+	history, err := e.readPushHistory()
+	if err != nil {
+		return err
+	}
+	history = append(results, history...)
+	if keep > 0 && len(history) > keep {
+		history = history[:keep]
+	}
+	historyBytes, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	return e.writeFile(PushHistoryFilename, historyBytes)
+}
+
+// RetrievePushHistory reads the push history file of id.
+func (s *FileStorage) RetrievePushHistory(_ context.Context, id string, limit int) ([]*storage.PushResult, error) {
+	history, err := s.newEnrollment(id).readPushHistory()
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(history) > limit {
+		history = history[:limit]
+	}
+	return history, nil
+}
@@ -0,0 +1,66 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StorePushRetry writes the push retry to the enrollment's push retry file.
+func (s *FileStorage) StorePushRetry(_ context.Context, retry *storage.PushRetry) error {
+	retryBytes, err := json.Marshal(retry)
+	if err != nil {
+		return err
+	}
+	return s.newEnrollment(retry.ID).writeFile(PushRetryFilename, retryBytes)
+}
+
+// RetrieveDuePushRetries reads the push retry file of each enrollment.
+func (s *FileStorage) RetrieveDuePushRetries(_ context.Context, before time.Time, limit int) ([]*storage.PushRetry, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var retries []*storage.PushRetry
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		retryBytes, err := s.newEnrollment(entry.Name()).readFile(PushRetryFilename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		retry := new(storage.PushRetry)
+		if err = json.Unmarshal(retryBytes, retry); err != nil {
+			return nil, err
+		}
+		if retry.NextAt.After(before) {
+			continue
+		}
+		retries = append(retries, retry)
+	}
+	sort.SliceStable(retries, func(i, j int) bool {
+		return retries[i].NextAt.Before(retries[j].NextAt)
+	})
+	if limit > 0 && len(retries) > limit {
+		retries = retries[:limit]
+	}
+	return retries, nil
+}
+
+// DeletePushRetries removes the push retry files of ids.
+func (s *FileStorage) DeletePushRetries(_ context.Context, ids []string) error {
+	for _, id := range ids {
+		if err := removeFile(s.newEnrollment(id).dirPrefix(PushRetryFilename)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,11 +3,16 @@ package file
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
 )
 
 const (
@@ -15,8 +20,19 @@ const (
 	subQueue    = "Queue"
 	subDone     = "QueueDone"
 	subInactive = "QueueInactive"
+
+	notNowTallySuffix = ".notnow.tally.txt"
+	notNowAtSuffix    = ".notnow.at.txt"
+	prioritySuffix    = ".priority.txt"
+	notBeforeSuffix   = ".notbefore.txt"
+	expiresSuffix     = ".expires.txt"
+	sequenceSuffix    = ".seq.txt"
 )
 
+// sidecarSuffixes are the suffixes of per-command files that follow
+// the command between queues.
+var sidecarSuffixes = []string{notNowTallySuffix, notNowAtSuffix, prioritySuffix, notBeforeSuffix, expiresSuffix, sequenceSuffix}
+
 type queue struct {
 	e   *enrollment
 	sub string // subdirectory for this queue
@@ -34,15 +50,45 @@ func (q *queue) dir() string {
 }
 
 func (q *queue) mkdir() error {
-	return os.MkdirAll(q.dir(), 0755)
+	return mkdirAll(q.dir())
 }
 
-func (q *queue) enqueue(uuid string, raw []byte) error {
+func (q *queue) enqueue(uuid string, raw []byte, opts *storage.EnqueueOptions) error {
 	err := q.mkdir()
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(
+	// file modification times are too coarse to order commands queued
+	// in quick succession so an enqueue sequence is recorded.
+	seq, err := q.e.nextSequence()
+	if err != nil {
+		return err
+	}
+	err = q.e.writeFile(path.Join(q.sub, uuid+sequenceSuffix), []byte(strconv.Itoa(seq)))
+	if err != nil {
+		return err
+	}
+	if opts.Priority != 0 {
+		err = q.e.writeFile(path.Join(q.sub, uuid+prioritySuffix), []byte(strconv.Itoa(opts.Priority)))
+		if err != nil {
+			return err
+		}
+	}
+	if !opts.NotBefore.IsZero() {
+		err = q.e.writeFile(path.Join(q.sub, uuid+notBeforeSuffix), []byte(opts.NotBefore.UTC().Format(time.RFC3339)))
+		if err != nil {
+			return err
+		}
+	}
+	if !opts.Expires.IsZero() {
+		err = q.e.writeFile(path.Join(q.sub, uuid+expiresSuffix), []byte(opts.Expires.UTC().Format(time.RFC3339)))
+		if err != nil {
+			return err
+		}
+	}
+	// the command is written last so that it is never seen without
+	// its sidecar files.
+	return writeFileAtomic(
 		path.Join(q.dir(), uuid+".plist"),
 		raw,
 		0755,
@@ -59,54 +105,295 @@ func (q *queue) exists(uuid string) (bool, error) {
 	return true, nil
 }
 
+// hasCommand reports whether command uuid is in any of the subs queues
+// of e. The queues are checked in the order commands move through them
+// so that a command moved while checking without the enrollment's
+// lock is still found.
+func (e *enrollment) hasCommand(uuid string, subs []string) (bool, error) {
+	for _, sub := range subs {
+		if exists, err := e.newQueue(sub).exists(uuid); err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// move moves the command and its sidecar files to the dest queue.
+// The sidecar files are copied to dest before the command is moved and
+// only then removed from the queue so that a crash never separates the
+// command from them. Results are not moved.
 func (q *queue) move(uuid string, dest *queue) error {
+	if q.sub == dest.sub {
+		return nil
+	}
 	err := dest.mkdir()
 	if err != nil {
 		return err
 	}
-	return os.Rename(
+	for _, suffix := range sidecarSuffixes {
+		destPath := path.Join(dest.dir(), uuid+suffix)
+		val, err := os.ReadFile(path.Join(q.dir(), uuid+suffix))
+		if errors.Is(err, os.ErrNotExist) {
+			// remove any stale copy left by an interrupted move
+			err = removeFile(destPath)
+		} else if err == nil {
+			err = writeFileAtomic(destPath, val, 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = renameFile(
 		path.Join(q.dir(), uuid+".plist"),
 		path.Join(dest.dir(), uuid+".plist"),
 	)
+	if err != nil {
+		return err
+	}
+	for _, suffix := range sidecarSuffixes {
+		if err = removeFile(path.Join(q.dir(), uuid+suffix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove removes the command, its results, and its sidecar files from
+// the queue. The command is removed first so that a crash never leaves
+// it without its sidecar files.
+func (q *queue) remove(uuid string) error {
+	for _, suffix := range append([]string{".plist", ".result.plist"}, sidecarSuffixes...) {
+		if err := removeFile(path.Join(q.dir(), uuid+suffix)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (q *queue) removeResults(uuid string) error {
-	return os.Remove(path.Join(q.dir(), uuid+".result.plist"))
+	return removeFile(path.Join(q.dir(), uuid+".result.plist"))
 }
 
 func (q *queue) writeResults(uuid string, raw []byte) error {
-	return os.WriteFile(
+	return writeFileAtomic(
 		path.Join(q.dir(), uuid+".result.plist"),
 		raw,
 		0755,
 	)
 }
 
-func (q *queue) getNext() (*mdm.Command, error) {
+// commandUUIDs returns the UUIDs of the commands in the queue.
+func (q *queue) commandUUIDs() ([]string, error) {
 	entries, err := os.ReadDir(q.dir())
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, err
 	}
+	var uuids []string
 	for _, entry := range entries {
-		if !strings.HasSuffix(entry.Name(), ".plist") {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".plist") || strings.HasSuffix(name, ".result.plist") {
+			continue
+		}
+		uuids = append(uuids, strings.TrimSuffix(name, ".plist"))
+	}
+	return uuids, nil
+}
+
+// priority reads the priority of command uuid in the queue.
+func (q *queue) priority(uuid string) (int, error) {
+	return q.e.readNumericFile(path.Join(q.sub, uuid+prioritySuffix))
+}
+
+// sequence reads the enqueue sequence of command uuid in the queue.
+// Commands queued before sequences were recorded have a zero sequence.
+func (q *queue) sequence(uuid string) (int, error) {
+	return q.e.readNumericFile(path.Join(q.sub, uuid+sequenceSuffix))
+}
+
+// readTime reads the time in the sidecar file with suffix of command
+// uuid in the queue. A zero time is returned if the file does not exist.
+func (q *queue) readTime(uuid, suffix string) (time.Time, error) {
+	val, err := q.e.readFile(path.Join(q.sub, uuid+suffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, string(val))
+}
+
+// notBefore reads the not-before time of command uuid in the queue.
+// A zero time is returned if the command is not scheduled.
+func (q *queue) notBefore(uuid string) (time.Time, error) {
+	return q.readTime(uuid, notBeforeSuffix)
+}
+
+// expired reports whether command uuid in the queue has expired as of now.
+func (q *queue) expired(uuid string, now time.Time) (bool, error) {
+	expires, err := q.readTime(uuid, expiresSuffix)
+	if err != nil {
+		return false, err
+	}
+	return !expires.IsZero() && !expires.After(now), nil
+}
+
+// queuedBefore reports whether the command with enqueue sequence seq1
+// and modification time t1 was queued before the one with seq2 and t2.
+func queuedBefore(seq1 int, t1 time.Time, seq2 int, t2 time.Time) bool {
+	if seq1 != seq2 {
+		return seq1 < seq2
+	}
+	return t1.Before(t2)
+}
+
+// getNext returns the highest priority command in the queue. Commands
+// of the same priority are returned in the order they were queued.
+// Commands scheduled for the future and expired commands are skipped.
+func (q *queue) getNext() (*mdm.Command, error) {
+	uuids, err := q.commandUUIDs()
+	if err != nil || len(uuids) < 1 {
+		return nil, err
+	}
+	now := time.Now()
+	var next string
+	var nextPriority, nextSeq int
+	var nextTime time.Time
+	for _, uuid := range uuids {
+		notBefore, err := q.notBefore(uuid)
+		if err != nil {
+			return nil, err
+		}
+		if notBefore.After(now) {
+			continue
+		}
+		expired, err := q.expired(uuid, now)
+		if err != nil {
+			return nil, err
+		}
+		if expired {
 			continue
 		}
-		raw, err := os.ReadFile(path.Join(q.dir(), entry.Name()))
+		priority, err := q.priority(uuid)
+		if err != nil {
+			return nil, err
+		}
+		seq, err := q.sequence(uuid)
 		if err != nil {
 			return nil, err
 		}
-		return mdm.DecodeCommand(raw)
+		fi, err := os.Stat(path.Join(q.dir(), uuid+".plist"))
+		if err != nil {
+			return nil, err
+		}
+		if next == "" || priority > nextPriority || (priority == nextPriority && queuedBefore(seq, fi.ModTime(), nextSeq, nextTime)) {
+			next, nextPriority, nextSeq, nextTime = uuid, priority, seq, fi.ModTime()
+		}
 	}
-	return nil, nil
+	if next == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path.Join(q.dir(), next+".plist"))
+	if err != nil {
+		return nil, err
+	}
+	return mdm.DecodeCommand(raw)
+}
+
+// item assembles a queue item for command uuid in the queue.
+func (q *queue) item(uuid string) (*storage.QueueItem, error) {
+	cmdPath := path.Join(q.dir(), uuid+".plist")
+	fi, err := os.Stat(cmdPath)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(cmdPath)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := mdm.DecodeCommand(raw)
+	if err != nil {
+		return nil, err
+	}
+	item := &storage.QueueItem{
+		CommandUUID: uuid,
+		RequestType: cmd.Command.RequestType,
+		Active:      q.sub != subInactive,
+		CreatedAt:   fi.ModTime(),
+	}
+	if item.Priority, err = q.priority(uuid); err != nil {
+		return nil, err
+	}
+	notBefore, err := q.notBefore(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if !notBefore.IsZero() {
+		item.NotBefore = &notBefore
+	}
+	expires, err := q.readTime(uuid, expiresSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if !expires.IsZero() {
+		item.Expires = &expires
+	}
+	resultPath := path.Join(q.dir(), uuid+".result.plist")
+	if fi, err = os.Stat(resultPath); errors.Is(err, os.ErrNotExist) {
+		return item, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if raw, err = os.ReadFile(resultPath); err != nil {
+		return nil, err
+	}
+	results, err := mdm.DecodeCommandResults(raw)
+	if err != nil {
+		return nil, err
+	}
+	item.Status = results.Status
+	updatedAt := fi.ModTime()
+	item.ResultUpdatedAt = &updatedAt
+	return item, nil
+}
+
+// result assembles the command result for command uuid in the queue.
+func (q *queue) result(uuid string) (*storage.CommandResult, error) {
+	result := &storage.CommandResult{ID: q.e.id}
+	var err error
+	result.NotNowTally, err = q.e.readNumericFile(path.Join(q.sub, uuid+notNowTallySuffix))
+	if err != nil {
+		return nil, err
+	}
+	resultPath := path.Join(q.dir(), uuid+".result.plist")
+	fi, err := os.Stat(resultPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if result.Result, err = os.ReadFile(resultPath); err != nil {
+		return nil, err
+	}
+	results, err := mdm.DecodeCommandResults(result.Result)
+	if err != nil {
+		return nil, err
+	}
+	result.Status = results.Status
+	updatedAt := fi.ModTime()
+	result.UpdatedAt = &updatedAt
+	return result, nil
 }
 
 // EnqueueCommand writes the command to disk in the queue directory
-func (s *FileStorage) EnqueueCommand(_ context.Context, ids []string, command *mdm.Command) (map[string]error, error) {
+func (s *FileStorage) EnqueueCommand(_ context.Context, ids []string, command *mdm.Command, opts ...storage.EnqueueOption) (map[string]error, error) {
+	options := storage.NewEnqueueOptions(opts...)
 	idErrs := make(map[string]error)
 	for _, id := range ids {
-		e := s.newEnrollment(id)
-		q := e.newQueue(subQueue)
-		if err := q.enqueue(command.CommandUUID, command.Raw); err != nil {
+		err := s.withLock(id, func() error {
+			q := s.newEnrollment(id).newQueue(subQueue)
+			return q.enqueue(command.CommandUUID, command.Raw, options)
+		})
+		if err != nil {
 			idErrs[id] = err
 		}
 	}
@@ -115,10 +402,18 @@ func (s *FileStorage) EnqueueCommand(_ context.Context, ids []string, command *m
 
 // StoreCommandReport moves commands to different queues (like NotNow)
 func (s *FileStorage) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
+	unlock, err := s.lock(r.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	e := s.newEnrollment(r.ID)
+	if err := e.updateLastSeen(); err != nil {
+		return err
+	}
 	if report.Status == "Idle" {
 		return nil
 	}
-	e := s.newEnrollment(r.ID)
 	src := e.newQueue(subQueue)
 	qExists, err := src.exists(report.CommandUUID)
 	if err != nil {
@@ -131,27 +426,57 @@ func (s *FileStorage) StoreCommandReport(r *mdm.Request, report *mdm.CommandResu
 		if err != nil {
 			return err
 		}
-		if nnqExists {
-			src = nnq
-
+		if !nnqExists {
+			return fmt.Errorf("command %s: %w", report.CommandUUID, os.ErrNotExist)
 		}
+		src = nnq
 	}
 	dest := e.newQueue(subDone)
 	if report.Status == "NotNow" {
 		dest = e.newQueue(subNotNow)
 	}
+	// write the results before moving the command so that a crash
+	// never leaves a completed command without them.
+	if err = dest.mkdir(); err != nil {
+		return err
+	}
+	if err = dest.writeResults(report.CommandUUID, report.Raw); err != nil {
+		return err
+	}
 	err = src.move(report.CommandUUID, dest)
 	if err != nil {
 		return err
 	}
-	if nnqExists {
-		nnq.removeResults(report.CommandUUID)
+	if report.Status == "NotNow" {
+		err = e.bumpNumericFile(path.Join(subNotNow, report.CommandUUID+notNowTallySuffix))
+		if err != nil {
+			return err
+		}
+		// only record the first NotNow
+		first, err := dest.readTime(report.CommandUUID, notNowAtSuffix)
+		if err != nil {
+			return err
+		}
+		if first.IsZero() {
+			err = e.writeFile(path.Join(subNotNow, report.CommandUUID+notNowAtSuffix), []byte(time.Now().UTC().Format(time.RFC3339)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if nnqExists && dest.sub != subNotNow {
+		return nnq.removeResults(report.CommandUUID)
 	}
-	return dest.writeResults(report.CommandUUID, report.Raw)
+	return nil
 }
 
 // RetrieveNextCommand gets the next command from the queue while minding NotNow status
 func (s *FileStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	unlock, err := s.lock(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	e := s.newEnrollment(r.ID)
 	var q *queue
 	if !skipNotNow {
@@ -168,31 +493,515 @@ func (s *FileStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm
 	return q.getNext()
 }
 
+// ClearQueue moves the outstanding and NotNow commands of the device
+// and its sub-enrollments to their inactive queues.
 func (s *FileStorage) ClearQueue(r *mdm.Request) error {
 	if r.ParentID != "" {
 		return errors.New("can only clear a device channel queue")
 	}
-	// assemble list of IDs for which to clear the queue
+	unlock, err := s.lock(r.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// clear the queue for all of the ids. sub-enrollments are locked
+	// while holding the device lock (the device then user channel
+	// lock order).
 	e := s.newEnrollment(r.ID)
-	clearIds := e.listSubEnrollments()
-	clearIds = append(clearIds, r.ID)
-	// clear the queue for all of the ids
-	for _, id := range clearIds {
+	for _, id := range e.listSubEnrollments() {
+		if err = s.withLock(id, s.newEnrollment(id).clearQueue); err != nil {
+			return err
+		}
+	}
+	return e.clearQueue()
+}
+
+// clearQueue moves the outstanding and NotNow commands of e to the
+// inactive queue. The caller must hold the enrollment's lock.
+func (e *enrollment) clearQueue() error {
+	dest := e.newQueue(subInactive)
+	for _, q := range []*queue{e.newQueue(subQueue), e.newQueue(subNotNow)} {
+		uuids, err := q.commandUUIDs()
+		if err != nil {
+			return err
+		}
+		for _, uuid := range uuids {
+			if err = q.move(uuid, dest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RetrieveQueue reads the commands in all of the queue directories.
+// Commands in the inactive queue are reported as inactive.
+func (s *FileStorage) RetrieveQueue(_ context.Context, id string) ([]*storage.QueueItem, error) {
+	var items []*storage.QueueItem
+	err := s.withLockIfExists(id, func() (err error) {
+		items, err = s.newEnrollment(id).queueItems()
+		return
+	})
+	return items, err
+}
+
+// queueItems reads the commands in all of the queue directories of e.
+// The caller must hold the enrollment's lock.
+func (e *enrollment) queueItems() ([]*storage.QueueItem, error) {
+	var items []*storage.QueueItem
+	seqs := make(map[*storage.QueueItem]int)
+	for _, sub := range []string{subQueue, subNotNow, subDone, subInactive} {
+		q := e.newQueue(sub)
+		uuids, err := q.commandUUIDs()
+		if err != nil {
+			return nil, err
+		}
+		for _, uuid := range uuids {
+			item, err := q.item(uuid)
+			if err != nil {
+				return nil, err
+			}
+			if seqs[item], err = q.sequence(uuid); err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Priority != items[j].Priority {
+			return items[i].Priority > items[j].Priority
+		}
+		return queuedBefore(seqs[items[i]], items[i].CreatedAt, seqs[items[j]], items[j].CreatedAt)
+	})
+	return items, nil
+}
+
+// RetrieveCommandResults searches the queues of every enrollment for
+// command uuid and reads its results.
+func (s *FileStorage) RetrieveCommandResults(ctx context.Context, uuid string) ([]*storage.CommandResult, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var results []*storage.CommandResult
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		e := s.newEnrollment(entry.Name())
+		subs := []string{subQueue, subNotNow, subDone, subInactive}
+		// only lock the enrollments that have the command
+		if found, err := e.hasCommand(uuid, subs); err != nil {
+			return nil, err
+		} else if !found {
+			continue
+		}
+		err = s.withLock(entry.Name(), func() error {
+			for _, sub := range subs {
+				q := e.newQueue(sub)
+				exists, err := q.exists(uuid)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					continue
+				}
+				result, err := q.result(uuid)
+				if err != nil {
+					return err
+				}
+				results = append(results, result)
+				break
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// DequeueCommand removes the command from the outstanding, NotNow, and
+// inactive queues of each enrollment.
+func (s *FileStorage) DequeueCommand(ctx context.Context, ids []string, uuid string) (int, error) {
+	if len(ids) < 1 {
+		entries, err := os.ReadDir(s.path)
+		if err != nil {
+			return 0, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				ids = append(ids, entry.Name())
+			}
+		}
+	}
+	var ct int
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return ct, err
+		}
 		e := s.newEnrollment(id)
-		dest := e.newQueue(subInactive)
-		for _, q := range []*queue{e.newQueue(subQueue), e.newQueue(subNotNow)} {
-			raw, err := q.getNext()
-			for raw != nil && err == nil {
-				err = q.move(raw.CommandUUID, dest)
+		subs := []string{subQueue, subNotNow, subInactive}
+		// only lock the enrollments that have the command
+		if found, err := e.hasCommand(uuid, subs); err != nil {
+			return ct, err
+		} else if !found {
+			continue
+		}
+		err := s.withLock(id, func() error {
+			for _, sub := range subs {
+				q := e.newQueue(sub)
+				exists, err := q.exists(uuid)
 				if err != nil {
 					return err
 				}
-				raw, err = q.getNext()
+				if !exists {
+					continue
+				}
+				if err = q.remove(uuid); err != nil {
+					return err
+				}
+				ct++
+				break
 			}
+			return nil
+		})
+		if err != nil {
+			return ct, err
+		}
+	}
+	return ct, nil
+}
+
+// RetrieveScheduledIDs searches the outstanding queue of every
+// enrollment for commands that became deliverable between after and before.
+func (s *FileStorage) RetrieveScheduledIDs(ctx context.Context, after, before time.Time) ([]string, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		scheduled, err := s.newEnrollment(entry.Name()).newQueue(subQueue).scheduled(after, before)
+		if err != nil {
+			return nil, err
+		}
+		if scheduled {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+// scheduled reports whether any command in the queue became
+// deliverable between after and before. The enrollment is not locked:
+// the files are written atomically and a command moved out of the
+// queue while reading is only missed.
+func (q *queue) scheduled(after, before time.Time) (bool, error) {
+	uuids, err := q.commandUUIDs()
+	if err != nil {
+		return false, err
+	}
+	for _, uuid := range uuids {
+		notBefore, err := q.notBefore(uuid)
+		if err != nil {
+			return false, err
+		}
+		if notBefore.After(after) && !notBefore.After(before) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RetrievePendingIDs searches the outstanding queue of every enabled
+// enrollment for deliverable commands queued at or before before.
+func (s *FileStorage) RetrievePendingIDs(ctx context.Context, before time.Time) ([]string, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		// the enrollment is not locked as with RetrieveScheduledIDs.
+		e := s.newEnrollment(entry.Name())
+		disabled, err := e.fileExists(DisabledFilename)
+		if err != nil {
+			return nil, err
+		} else if disabled {
+			continue
+		}
+		q := e.newQueue(subQueue)
+		uuids, err := q.commandUUIDs()
+		if err != nil {
+			return nil, err
+		}
+		for _, uuid := range uuids {
+			pending, err := q.pending(uuid, before, now)
 			if err != nil {
-				return err
+				return nil, err
+			}
+			if pending {
+				ids = append(ids, entry.Name())
+				break
 			}
 		}
 	}
+	return ids, nil
+}
+
+// pending reports whether command uuid in the queue was queued at or
+// before before and is deliverable as of now.
+func (q *queue) pending(uuid string, before, now time.Time) (bool, error) {
+	fi, err := os.Stat(path.Join(q.dir(), uuid+".plist"))
+	if errors.Is(err, os.ErrNotExist) {
+		// moved out of the queue since it was listed
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if fi.ModTime().After(before) {
+		return false, nil
+	}
+	notBefore, err := q.notBefore(uuid)
+	if err != nil || notBefore.After(now) {
+		return false, err
+	}
+	expired, err := q.expired(uuid, now)
+	return !expired, err
+}
+
+// finalize moves command uuid from the queue to the dest queue with a
+// synthetic result of status. Any NotNow result is removed.
+func (q *queue) finalize(uuid, status string, dest *queue) error {
+	result, err := storage.NewSyntheticResult(uuid, status)
+	if err != nil {
+		return err
+	}
+	// write the results before moving the command so that a crash
+	// never leaves a finalized command without them.
+	if err = dest.mkdir(); err != nil {
+		return err
+	}
+	if err = dest.writeResults(uuid, result); err != nil {
+		return err
+	}
+	if err = q.move(uuid, dest); err != nil {
+		return err
+	}
+	if q.sub == subNotNow {
+		return q.removeResults(uuid)
+	}
 	return nil
 }
+
+// ExpireCommands searches the outstanding and NotNow queues of every
+// enrollment for expired commands. They are moved to the completed
+// queue with a synthetic expired result.
+func (s *FileStorage) ExpireCommands(ctx context.Context) ([]*storage.ExpiredCommand, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var expired []*storage.ExpiredCommand
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return expired, err
+		}
+		e := s.newEnrollment(entry.Name())
+		// only lock the enrollments that have expired commands
+		if hasExpired, err := e.hasExpired(now); err != nil {
+			return expired, err
+		} else if !hasExpired {
+			continue
+		}
+		err = s.withLock(entry.Name(), func() error {
+			dest := e.newQueue(subDone)
+			for _, sub := range []string{subQueue, subNotNow} {
+				q := e.newQueue(sub)
+				uuids, err := q.commandUUIDs()
+				if err != nil {
+					return err
+				}
+				for _, uuid := range uuids {
+					isExpired, err := q.expired(uuid, now)
+					if err != nil {
+						return err
+					}
+					if !isExpired {
+						continue
+					}
+					item, err := q.item(uuid)
+					if err != nil {
+						return err
+					}
+					if err = q.finalize(uuid, storage.StatusExpired, dest); err != nil {
+						return err
+					}
+					expired = append(expired, &storage.ExpiredCommand{
+						ID:          e.id,
+						CommandUUID: uuid,
+						RequestType: item.RequestType,
+					})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// hasExpired reports whether any command in the outstanding or NotNow
+// queues of e has expired as of now. The enrollment is not locked so
+// the caller must check again while holding the lock.
+func (e *enrollment) hasExpired(now time.Time) (bool, error) {
+	for _, sub := range []string{subQueue, subNotNow} {
+		q := e.newQueue(sub)
+		uuids, err := q.commandUUIDs()
+		if err != nil {
+			return false, err
+		}
+		for _, uuid := range uuids {
+			if isExpired, err := q.expired(uuid, now); err != nil || isExpired {
+				return isExpired, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// RetrieveNotNow reads the NotNow tally and first NotNow time of the
+// command in the NotNow queue.
+func (s *FileStorage) RetrieveNotNow(_ context.Context, id, uuid string) (tally int, first time.Time, err error) {
+	err = s.withLockIfExists(id, func() (err error) {
+		q := s.newEnrollment(id).newQueue(subNotNow)
+		tally, err = q.e.readNumericFile(path.Join(q.sub, uuid+notNowTallySuffix))
+		if err != nil {
+			return err
+		}
+		first, err = q.readTime(uuid, notNowAtSuffix)
+		return err
+	})
+	return
+}
+
+// DeadLetterCommand moves the command from the outstanding or NotNow
+// queue to the inactive queue with a synthetic dead-lettered result.
+func (s *FileStorage) DeadLetterCommand(_ context.Context, id, uuid string) error {
+	return s.withLockIfExists(id, func() error {
+		e := s.newEnrollment(id)
+		dest := e.newQueue(subInactive)
+		for _, sub := range []string{subNotNow, subQueue} {
+			q := e.newQueue(sub)
+			exists, err := q.exists(uuid)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			return q.finalize(uuid, storage.StatusDeadLettered, dest)
+		}
+		return nil
+	})
+}
+
+// RetrieveDeadLetters searches the inactive queue of each enrollment
+// for dead-lettered commands.
+func (s *FileStorage) RetrieveDeadLetters(ctx context.Context, ids []string) ([]*storage.DeadLetter, error) {
+	if len(ids) < 1 {
+		entries, err := os.ReadDir(s.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				ids = append(ids, entry.Name())
+			}
+		}
+	} else {
+		ids = append([]string{}, ids...)
+		sort.Strings(ids)
+	}
+	var deadLetters []*storage.DeadLetter
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var idDeadLetters []*storage.DeadLetter
+		err := s.withLockIfExists(id, func() (err error) {
+			idDeadLetters, err = s.newEnrollment(id).newQueue(subInactive).deadLetters()
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(idDeadLetters, func(i, j int) bool {
+			return idDeadLetters[i].DeadLetteredAt.Before(idDeadLetters[j].DeadLetteredAt)
+		})
+		deadLetters = append(deadLetters, idDeadLetters...)
+	}
+	return deadLetters, nil
+}
+
+// deadLetters reads the dead-lettered commands in the queue.
+func (q *queue) deadLetters() ([]*storage.DeadLetter, error) {
+	uuids, err := q.commandUUIDs()
+	if err != nil {
+		return nil, err
+	}
+	var deadLetters []*storage.DeadLetter
+	for _, uuid := range uuids {
+		item, err := q.item(uuid)
+		if err != nil {
+			return nil, err
+		}
+		if item.Status != storage.StatusDeadLettered {
+			continue
+		}
+		result, err := q.result(uuid)
+		if err != nil {
+			return nil, err
+		}
+		dl := &storage.DeadLetter{
+			ID:             q.e.id,
+			CommandUUID:    uuid,
+			RequestType:    item.RequestType,
+			NotNowTally:    result.NotNowTally,
+			DeadLetteredAt: *item.ResultUpdatedAt,
+		}
+		notNowAt, err := q.readTime(uuid, notNowAtSuffix)
+		if err != nil {
+			return nil, err
+		}
+		if !notNowAt.IsZero() {
+			dl.NotNowAt = &notNowAt
+		}
+		deadLetters = append(deadLetters, dl)
+	}
+	return deadLetters, nil
+}
@@ -0,0 +1,81 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temporary file beside name and
+// renames it over name. Readers see either the old or the new file
+// contents, never a partial write, even after a crash.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	// the leading dot and suffix keep temporary files from being
+	// mistaken for commands, results, or push certificates.
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	err = func() error {
+		defer f.Close()
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+		if err := f.Chmod(perm); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if err == nil {
+		err = os.Rename(tmpName, name)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// renameFile renames oldName to newName and syncs their directories.
+func renameFile(oldName, newName string) error {
+	if err := os.Rename(oldName, newName); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(newName)); err != nil {
+		return err
+	}
+	if filepath.Dir(oldName) == filepath.Dir(newName) {
+		return nil
+	}
+	return syncDir(filepath.Dir(oldName))
+}
+
+// removeFile removes the file name, if it exists, and syncs its directory.
+func removeFile(name string) error {
+	err := os.Remove(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// mkdirAll creates directory dir and any missing parents, syncing
+// the parent of each directory created.
+func mkdirAll(dir string) error {
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		return nil
+	}
+	parent := filepath.Dir(dir)
+	if parent != dir {
+		if err := mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return syncDir(parent)
+}
@@ -0,0 +1,34 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StoreBadPushToken stores a copy of the bad push token for token.ID.
+func (s *InMem) StoreBadPushToken(_ context.Context, token *storage.BadPushToken) error {
+	stored := *token
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.badTokens[token.ID] = &stored
+	return nil
+}
+
+// RetrieveBadPushTokens retrieves the bad push tokens of ids.
+func (s *InMem) RetrieveBadPushTokens(_ context.Context, ids []string) (map[string]*storage.BadPushToken, error) {
+	if len(ids) < 1 {
+		return nil, errors.New("no ids provided")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tokens := make(map[string]*storage.BadPushToken)
+	for _, id := range ids {
+		if token, ok := s.badTokens[id]; ok {
+			retrieved := *token
+			tokens[id] = &retrieved
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,30 @@
+package inmem
+
+import (
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+func (s *InMem) StoreBootstrapToken(r *mdm.Request, msg *mdm.SetBootstrapToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[r.ID]; ok {
+		d.bootstrapToken = msg.BootstrapToken.BootstrapToken.String()
+	}
+	s.updateLastSeen(r.ID)
+	return nil
+}
+
+func (s *InMem) RetrieveBootstrapToken(r *mdm.Request, _ *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[r.ID]
+	if !ok || d.bootstrapToken == "" {
+		return nil, nil
+	}
+	bsToken := new(mdm.BootstrapToken)
+	if err := bsToken.SetTokenString(d.bootstrapToken); err != nil {
+		return nil, err
+	}
+	s.updateLastSeen(r.ID)
+	return bsToken, nil
+}
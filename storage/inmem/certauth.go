@@ -0,0 +1,62 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+func (s *InMem) EnrollmentHasCertHash(r *mdm.Request, _ string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, ids := range s.certHashes {
+		if _, ok := ids[r.ID]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *InMem) HasCertHash(r *mdm.Request, hash string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.certHashes[strings.ToLower(hash)]) > 0, nil
+}
+
+func (s *InMem) IsCertHashAssociated(r *mdm.Request, hash string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.certHashes[strings.ToLower(hash)][r.ID]
+	return ok, nil
+}
+
+func (s *InMem) AssociateCertHash(r *mdm.Request, hash string) error {
+	hash = strings.ToLower(hash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids, ok := s.certHashes[hash]
+	if !ok {
+		ids = make(map[string]struct{})
+		s.certHashes[hash] = ids
+	}
+	ids[r.ID] = struct{}{}
+	return nil
+}
+
+// EnrollmentFromHash returns the (lowest, if there are several)
+// enrollment ID associated with hash.
+func (s *InMem) EnrollmentFromHash(_ context.Context, hash string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, 0, len(s.certHashes[strings.ToLower(hash)]))
+	for id := range s.certHashes[strings.ToLower(hash)] {
+		ids = append(ids, id)
+	}
+	if len(ids) < 1 {
+		return "", nil
+	}
+	sort.Strings(ids)
+	return ids[0], nil
+}
@@ -0,0 +1,44 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// ListEnrollments lists enrollments matching filter.
+func (s *InMem) ListEnrollments(_ context.Context, filter *storage.EnrollmentFilter, cursor string, limit int) ([]*storage.Enrollment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var enrollments []*storage.Enrollment
+	for id, e := range s.enrollments {
+		if id <= cursor {
+			continue
+		}
+		enrollment := &storage.Enrollment{
+			ID:               id,
+			Type:             e.typ,
+			Topic:            e.topic,
+			Enabled:          e.enabled,
+			TokenUpdateTally: e.tally,
+			LastSeenAt:       e.lastSeen,
+		}
+		if e.userID != "" {
+			enrollment.ParentID = e.deviceID
+		}
+		if d, ok := s.devices[e.deviceID]; ok {
+			enrollment.SerialNumber = d.serialNumber
+		}
+		if filter.Match(enrollment) {
+			enrollments = append(enrollments, enrollment)
+		}
+	}
+	sort.Slice(enrollments, func(i, j int) bool {
+		return enrollments[i].ID < enrollments[j].ID
+	})
+	if limit > 0 && len(enrollments) > limit {
+		enrollments = enrollments[:limit]
+	}
+	return enrollments, nil
+}
@@ -0,0 +1,258 @@
+// Package inmem implements in-memory storage for MDM services.
+//
+// Nothing is persisted: all data is lost when the process exits. It is
+// intended for tests and development where a new, isolated store is
+// needed quickly.
+package inmem
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// device is a device channel's check-in data.
+type device struct {
+	identityCert   []byte // PEM
+	serialNumber   string
+	authenticate   []byte
+	tokenUpdate    []byte
+	unlockToken    []byte
+	bootstrapToken string // base64
+}
+
+// user is a user channel's check-in data.
+type user struct {
+	deviceID               string
+	shortName              string
+	longName               string
+	tokenUpdate            []byte
+	userAuthenticate       []byte
+	userAuthenticateDigest []byte
+}
+
+// enrollment is a device or user channel enrollment.
+type enrollment struct {
+	deviceID string
+	userID   string // empty for device channel enrollments
+	typ      string
+	topic    string
+	// pushMagic and tokenHex are the APNs push data.
+	pushMagic string
+	tokenHex  string
+	enabled   bool
+	tally     int
+	lastSeen  time.Time
+}
+
+// InMem implements in-memory storage for MDM services.
+// It is safe for concurrent use.
+type InMem struct {
+	logger log.Logger
+	rm     bool
+
+	mu          sync.RWMutex
+	devices     map[string]*device
+	users       map[string]*user
+	enrollments map[string]*enrollment
+	// certHashes holds the enrollment IDs associated with each
+	// (lower-case) certificate hash.
+	certHashes  map[string]map[string]struct{}
+	commands    map[string]*command
+	queues      map[string]map[string]*queued // enrollment ID, command UUID
+	results     map[string]map[string]*result // enrollment ID, command UUID
+	seq         uint64
+	pushCerts   map[string]*pushCert
+	inventory   map[string]map[string][]byte
+	pushRetries map[string]*storage.PushRetry
+	badTokens   map[string]*storage.BadPushToken
+	pushHistory map[string][]*storage.PushResult
+}
+
+type config struct {
+	logger log.Logger
+	rm     bool
+}
+
+type Option func(*config)
+
+func WithLogger(logger log.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithDeleteCommands deletes commands once they are completed.
+func WithDeleteCommands() Option {
+	return func(c *config) {
+		c.rm = true
+	}
+}
+
+// New creates a new, empty, in-memory storage backend.
+func New(opts ...Option) *InMem {
+	cfg := &config{logger: log.NopLogger}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return &InMem{
+		logger:      cfg.logger,
+		rm:          cfg.rm,
+		devices:     make(map[string]*device),
+		users:       make(map[string]*user),
+		enrollments: make(map[string]*enrollment),
+		certHashes:  make(map[string]map[string]struct{}),
+		commands:    make(map[string]*command),
+		queues:      make(map[string]map[string]*queued),
+		results:     make(map[string]map[string]*result),
+		pushCerts:   make(map[string]*pushCert),
+		inventory:   make(map[string]map[string][]byte),
+		pushRetries: make(map[string]*storage.PushRetry),
+		badTokens:   make(map[string]*storage.BadPushToken),
+		pushHistory: make(map[string][]*storage.PushResult),
+	}
+}
+
+// clone returns a copy of b so that callers can't modify stored data.
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func (s *InMem) StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate) error {
+	var pemCert []byte
+	if r.Certificate != nil {
+		pemCert = cryptoutil.PEMCertificate(r.Certificate.Raw)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[r.ID]
+	if !ok {
+		d = new(device)
+		s.devices[r.ID] = d
+	}
+	d.identityCert = pemCert
+	d.serialNumber = msg.SerialNumber
+	d.authenticate = clone(msg.Raw)
+	return nil
+}
+
+func (s *InMem) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	resolved := (&msg.Enrollment).Resolved()
+	if err := resolved.Validate(); err != nil {
+		return err
+	}
+	var deviceID, userID string
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resolved.IsUserChannel {
+		deviceID = r.ParentID
+		userID = r.ID
+		// there shouldn't be an Unlock Token on the user channel, but
+		// complain if there is to warn an admin
+		if len(msg.UnlockToken) > 0 {
+			ctxlog.Logger(r.Context, s.logger).Info(
+				"msg", "Unlock Token on user channel not stored",
+			)
+		}
+		u, ok := s.users[r.ID]
+		if !ok {
+			u = new(user)
+			s.users[r.ID] = u
+		}
+		u.deviceID = r.ParentID
+		u.shortName = msg.UserShortName
+		u.longName = msg.UserLongName
+		u.tokenUpdate = clone(msg.Raw)
+	} else {
+		deviceID = r.ID
+		if d, ok := s.devices[r.ID]; ok {
+			d.tokenUpdate = clone(msg.Raw)
+			// separately store the Unlock Token per MDM spec
+			if len(msg.UnlockToken) > 0 {
+				d.unlockToken = clone(msg.UnlockToken)
+			}
+		}
+	}
+	e, ok := s.enrollments[r.ID]
+	if !ok {
+		e = new(enrollment)
+		s.enrollments[r.ID] = e
+	}
+	e.deviceID = deviceID
+	e.userID = userID
+	e.typ = r.Type.String()
+	e.topic = msg.Topic
+	e.pushMagic = msg.PushMagic
+	e.tokenHex = msg.Token.String()
+	e.enabled = true
+	e.tally++
+	e.lastSeen = time.Now()
+	return nil
+}
+
+func (s *InMem) RetrieveTokenUpdateTally(_ context.Context, id string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if e, ok := s.enrollments[id]; ok {
+		return e.tally, nil
+	}
+	return 0, nil
+}
+
+func (s *InMem) StoreUserAuthenticate(r *mdm.Request, msg *mdm.UserAuthenticate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[r.ID]
+	if !ok {
+		u = new(user)
+		s.users[r.ID] = u
+	}
+	u.deviceID = r.ParentID
+	u.shortName = msg.UserShortName
+	u.longName = msg.UserLongName
+	// if the DigestResponse is empty then this is the first (of two)
+	// UserAuthenticate messages depending on our response
+	if msg.DigestResponse != "" {
+		u.userAuthenticateDigest = clone(msg.Raw)
+	} else {
+		u.userAuthenticate = clone(msg.Raw)
+	}
+	s.updateLastSeen(r.ID)
+	return nil
+}
+
+// Disable can be called for an Authenticate or CheckOut message
+func (s *InMem) Disable(r *mdm.Request) error {
+	if r.ParentID != "" {
+		return errors.New("can only disable a device channel")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.enrollments {
+		if e.deviceID == r.ID && e.enabled {
+			e.enabled = false
+			e.tally = 0
+			e.lastSeen = time.Now()
+		}
+	}
+	return nil
+}
+
+// updateLastSeen updates the last seen time of enrollment id.
+// The caller must hold the write lock.
+func (s *InMem) updateLastSeen(id string) {
+	if e, ok := s.enrollments[id]; ok {
+		e.lastSeen = time.Now()
+	}
+}
@@ -0,0 +1,99 @@
+package inmem
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/test"
+)
+
+func TestInMem(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("WithDeleteCommands()", func(t *testing.T) {
+		test.TestQueue(t, "EA4E19F1-7F8B-493D-BEAB-264B33BCF4E6", New(WithDeleteCommands()))
+	})
+
+	// the storage tests share command UUIDs so each needs its own storage.
+	test.TestQueue(t, "EA4E19F1-7F8B-493D-BEAB-264B33BCF4E6", New())
+	test.TestRetrievePushInfo(t, ctx, New())
+	test.TestRetrieveQueue(t, "2BD3F5D4-1F0A-4F5E-9A39-3C7B1B6D4E11", New())
+	test.TestRetrieveCommandResults(t, "7C1E2A5B-93D4-4B8F-A2E6-0F5D8C3B9A47", New())
+	test.TestDequeueCommand(t, "D4A1C7E2-5B3F-4E9A-8C6D-1F2B3A4C5D6E", New())
+	test.TestScheduledCommands(t, "5E8B2C1A-7D4F-4A3B-9E6C-2B1D0F8A7C53", New())
+
+	s := New()
+	test.EnrollDevice(t, s, ctx, "B2D4F6A8-0C1E-4A3B-8D5F-7E9A1C3B5D46")
+	test.TestPendingCommands(t, "B2D4F6A8-0C1E-4A3B-8D5F-7E9A1C3B5D46", s)
+
+	test.TestExpireCommands(t, "9A3D6F1B-2C8E-4B7A-A5D4-6E0C1F9B8D72", New())
+	test.TestDeadLetters(t, "3F7C9E2D-8B1A-4D6E-B2C5-7A0E4F1D9C36", New())
+	test.TestInventory(t, "6B2E8D4F-1A9C-4E7B-8D3F-5C0A2E6B9F14", New())
+	test.TestPushRetries(t, "8C4A1E7D-3F2B-4D9A-B6E5-0A7C9D2F1B48", "E1F3B5D7-9A2C-4E6B-8D0F-2A4C6E8B0D13", New())
+	test.TestBadPushTokens(t, "5D9B3F1A-7E2C-4A8D-9B6F-1C3E5A7D9B20", New())
+	test.TestPushHistory(t, "A7E1C3F5-2B4D-4F6A-8C0E-3D5F7B9A1C24", "4F8B2D6E-0C3A-4E1F-9A7D-6B2C8E4F0A35", New())
+	test.TestPushCerts(t, New())
+	test.TestEnrollmentLister(t, ctx, New())
+}
+
+func TestConcurrentQueues(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		id := fmt.Sprintf("CONCURRENT-%04d", i)
+		r := test.EnrollDevice(t, s, ctx, id)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				cmd := &mdm.Command{CommandUUID: fmt.Sprintf("%s-CMD%d", id, j)}
+				cmd.Command.RequestType = "DeviceInformation"
+				if _, err := s.EnqueueCommand(ctx, []string{id}, cmd, storage.WithPriority(j%3)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			for j := 0; j < 50; j++ {
+				cmd, err := s.RetrieveNextCommand(r, false)
+				if err != nil {
+					t.Error(err)
+					return
+				} else if cmd == nil {
+					t.Errorf("%s: no command after %d results", id, j)
+					return
+				}
+				err = s.StoreCommandReport(r, &mdm.CommandResults{CommandUUID: cmd.CommandUUID, Status: "Acknowledged"})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	enrollments, err := s.ListEnrollments(ctx, nil, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range enrollments {
+		items, err := s.RetrieveQueue(ctx, e.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range items {
+			if item.Status != "Acknowledged" {
+				t.Errorf("%s: %s: status: have: %q, want: %q", e.ID, item.CommandUUID, item.Status, "Acknowledged")
+			}
+		}
+		if have, want := len(items), 50; have != want {
+			t.Errorf("%s: queue length: have: %d, want: %d", e.ID, have, want)
+		}
+	}
+}
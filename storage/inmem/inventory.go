@@ -0,0 +1,51 @@
+package inmem
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StoreInventoryValues merges values into the inventory of id.
+// Values are stored JSON encoded like the other backends so that they
+// retrieve as the same types.
+func (s *InMem) StoreInventoryValues(_ context.Context, id string, values storage.InventoryValues) error {
+	if len(values) < 1 {
+		return nil
+	}
+	encoded := make(map[string][]byte, len(values))
+	for name, v := range values {
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		encoded[name] = value
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inventory, ok := s.inventory[id]
+	if !ok {
+		inventory = make(map[string][]byte)
+		s.inventory[id] = inventory
+	}
+	for name, value := range encoded {
+		inventory[name] = value
+	}
+	return nil
+}
+
+// RetrieveInventory retrieves and JSON decodes the inventory attributes of id.
+func (s *InMem) RetrieveInventory(_ context.Context, id string) (storage.InventoryValues, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values := make(storage.InventoryValues)
+	for name, value := range s.inventory[id] {
+		var v interface{}
+		if err := json.Unmarshal(value, &v); err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,47 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+func (s *InMem) RetrieveMigrationCheckins(ctx context.Context, c chan<- interface{}) error {
+	// collect the check-ins first so that the lock isn't held while
+	// sending to the channel.
+	s.mu.RLock()
+	deviceIDs := make([]string, 0, len(s.devices))
+	for id := range s.devices {
+		deviceIDs = append(deviceIDs, id)
+	}
+	sort.Strings(deviceIDs)
+	userIDs := make([]string, 0, len(s.users))
+	for id := range s.users {
+		userIDs = append(userIDs, id)
+	}
+	sort.Strings(userIDs)
+	var checkins [][]byte
+	for _, id := range deviceIDs {
+		checkins = append(checkins, s.devices[id].authenticate, s.devices[id].tokenUpdate)
+	}
+	for _, id := range userIDs {
+		checkins = append(checkins, s.users[id].tokenUpdate)
+	}
+	s.mu.RUnlock()
+	for _, msgBytes := range checkins {
+		if msgBytes == nil {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		msg, err := mdm.DecodeCheckin(msgBytes)
+		if err != nil {
+			c <- err
+		} else {
+			c <- msg
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+// RetrievePushInfo retreives push info for identifiers ids.
+//
+// Note that we may return fewer results than input. The user of this
+// method needs to reconcile that with their requested ids.
+func (s *InMem) RetrievePushInfo(_ context.Context, ids []string) (map[string]*mdm.Push, error) {
+	if len(ids) < 1 {
+		return nil, errors.New("no ids provided")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pushInfos := make(map[string]*mdm.Push)
+	for _, id := range ids {
+		e, ok := s.enrollments[id]
+		if !ok {
+			continue
+		}
+		push := &mdm.Push{
+			Topic:     e.topic,
+			PushMagic: e.pushMagic,
+		}
+		// convert from hex
+		if err := push.SetTokenString(e.tokenHex); err != nil {
+			return nil, err
+		}
+		pushInfos[id] = push
+	}
+	return pushInfos, nil
+}
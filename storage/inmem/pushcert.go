@@ -0,0 +1,94 @@
+package inmem
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+type pushCert struct {
+	certPEM    []byte
+	keyPEM     []byte
+	staleToken int
+}
+
+func (s *InMem) RetrievePushCert(_ context.Context, topic string) (*tls.Certificate, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pc, ok := s.pushCerts[topic]
+	if !ok {
+		return nil, "", fmt.Errorf("no push certificate for topic: %s", topic)
+	}
+	cert, err := tls.X509KeyPair(pc.certPEM, pc.keyPEM)
+	if err != nil {
+		return nil, "", err
+	}
+	return &cert, strconv.Itoa(pc.staleToken), nil
+}
+
+func (s *InMem) IsPushCertStale(_ context.Context, topic, staleToken string) (bool, error) {
+	staleTokenInt, err := strconv.Atoi(staleToken)
+	if err != nil {
+		return true, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pc, ok := s.pushCerts[topic]
+	if !ok {
+		return true, fmt.Errorf("no push certificate for topic: %s", topic)
+	}
+	return pc.staleToken != staleTokenInt, nil
+}
+
+func (s *InMem) StorePushCert(_ context.Context, pemCert, pemKey []byte) error {
+	topic, err := cryptoutil.TopicFromPEMCert(pemCert)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pc, ok := s.pushCerts[topic]
+	if ok {
+		pc.staleToken++
+	} else {
+		pc = new(pushCert)
+		s.pushCerts[topic] = pc
+	}
+	pc.certPEM = clone(pemCert)
+	pc.keyPEM = clone(pemKey)
+	return nil
+}
+
+// ListPushCerts returns information about each stored push certificate.
+func (s *InMem) ListPushCerts(_ context.Context) ([]*storage.PushCertInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	topics := make([]string, 0, len(s.pushCerts))
+	for topic := range s.pushCerts {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	var infos []*storage.PushCertInfo
+	for _, topic := range topics {
+		pc := s.pushCerts[topic]
+		info, err := storage.NewPushCertInfo(pc.certPEM, strconv.Itoa(pc.staleToken))
+		if err != nil {
+			return nil, fmt.Errorf("push cert for topic %q: %w", topic, err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// DeletePushCert deletes the push certificate of topic.
+func (s *InMem) DeletePushCert(_ context.Context, topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pushCerts, topic)
+	return nil
+}
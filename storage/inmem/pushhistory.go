@@ -0,0 +1,43 @@
+package inmem
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StorePushResults appends copies of results to the push history of
+// their enrollments and prunes each to the keep most recent push results.
+func (s *InMem) StorePushResults(_ context.Context, results []*storage.PushResult, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, result := range results {
+		stored := *result
+		s.pushHistory[result.ID] = append(s.pushHistory[result.ID], &stored)
+	}
+	if keep < 1 {
+		return nil
+	}
+	for _, result := range results {
+		if history := s.pushHistory[result.ID]; len(history) > keep {
+			s.pushHistory[result.ID] = append([]*storage.PushResult{}, history[len(history)-keep:]...)
+		}
+	}
+	return nil
+}
+
+// RetrievePushHistory retrieves the most recent push results of id.
+func (s *InMem) RetrievePushHistory(_ context.Context, id string, limit int) ([]*storage.PushResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	history := s.pushHistory[id]
+	var results []*storage.PushResult
+	for i := len(history) - 1; i >= 0; i-- {
+		if limit > 0 && len(results) >= limit {
+			break
+		}
+		result := *history[i]
+		results = append(results, &result)
+	}
+	return results, nil
+}
@@ -0,0 +1,52 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StorePushRetry stores a copy of the push retry for retry.ID.
+func (s *InMem) StorePushRetry(_ context.Context, retry *storage.PushRetry) error {
+	stored := *retry
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pushRetries[retry.ID] = &stored
+	return nil
+}
+
+// RetrieveDuePushRetries retrieves push retries due at or before before.
+func (s *InMem) RetrieveDuePushRetries(_ context.Context, before time.Time, limit int) ([]*storage.PushRetry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var retries []*storage.PushRetry
+	for _, retry := range s.pushRetries {
+		if retry.NextAt.After(before) {
+			continue
+		}
+		due := *retry
+		retries = append(retries, &due)
+	}
+	sort.Slice(retries, func(i, j int) bool {
+		if !retries[i].NextAt.Equal(retries[j].NextAt) {
+			return retries[i].NextAt.Before(retries[j].NextAt)
+		}
+		return retries[i].ID < retries[j].ID
+	})
+	if limit > 0 && len(retries) > limit {
+		retries = retries[:limit]
+	}
+	return retries, nil
+}
+
+// DeletePushRetries deletes the push retries of ids.
+func (s *InMem) DeletePushRetries(_ context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.pushRetries, id)
+	}
+	return nil
+}
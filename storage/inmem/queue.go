@@ -0,0 +1,439 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+type command struct {
+	requestType string
+	raw         []byte
+}
+
+// queued is a command queued for an enrollment.
+type queued struct {
+	priority  int
+	notBefore time.Time
+	expires   time.Time
+	createdAt time.Time
+	// seq orders commands queued at the same time.
+	seq    uint64
+	active bool
+}
+
+// deliverable reports whether the queued command may be delivered as of now.
+func (q *queued) deliverable(now time.Time) bool {
+	return q.active &&
+		!q.notBefore.After(now) &&
+		(q.expires.IsZero() || q.expires.After(now))
+}
+
+// result is an enrollment's result for a queued command.
+type result struct {
+	status      string
+	raw         []byte
+	notNowAt    time.Time
+	notNowTally int
+	updatedAt   time.Time
+}
+
+func (s *InMem) EnqueueCommand(_ context.Context, ids []string, cmd *mdm.Command, opts ...storage.EnqueueOption) (map[string]error, error) {
+	if len(ids) < 1 {
+		return nil, errors.New("no id(s) supplied to queue command to")
+	}
+	options := storage.NewEnqueueOptions(opts...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// check everything first so that the whole batch fails, as with
+	// the SQL backends.
+	if _, ok := s.commands[cmd.CommandUUID]; ok && !options.Append {
+		return nil, fmt.Errorf("command already exists: %s", cmd.CommandUUID)
+	}
+	for _, id := range ids {
+		if _, ok := s.queues[id][cmd.CommandUUID]; ok {
+			return nil, fmt.Errorf("command %s already queued for id: %s", cmd.CommandUUID, id)
+		}
+	}
+	if _, ok := s.commands[cmd.CommandUUID]; !ok {
+		s.commands[cmd.CommandUUID] = &command{
+			requestType: cmd.Command.RequestType,
+			raw:         clone(cmd.Raw),
+		}
+	}
+	now := time.Now()
+	for _, id := range ids {
+		if s.queues[id] == nil {
+			s.queues[id] = make(map[string]*queued)
+		}
+		s.seq++
+		s.queues[id][cmd.CommandUUID] = &queued{
+			priority:  options.Priority,
+			notBefore: options.NotBefore,
+			expires:   options.Expires,
+			createdAt: now,
+			seq:       s.seq,
+			active:    true,
+		}
+	}
+	return nil, nil
+}
+
+// deleteCommand deletes command uuid and its result from the queue of
+// id. The caller must hold the write lock.
+func (s *InMem) deleteCommand(id, uuid string) {
+	delete(s.queues[id], uuid)
+	delete(s.results[id], uuid)
+	s.deleteOrphanCommand(uuid)
+}
+
+// deleteOrphanCommand deletes the command if no enrollments have it
+// queued nor are there any results for it. The caller must hold the
+// write lock.
+func (s *InMem) deleteOrphanCommand(uuid string) {
+	for _, q := range s.queues {
+		if _, ok := q[uuid]; ok {
+			return
+		}
+	}
+	for _, r := range s.results {
+		if _, ok := r[uuid]; ok {
+			return
+		}
+	}
+	delete(s.commands, uuid)
+}
+
+// storeResult stores the result for queued command uuid of id.
+// The caller must hold the write lock.
+func (s *InMem) storeResult(id, uuid, status string, raw []byte) *result {
+	if s.results[id] == nil {
+		s.results[id] = make(map[string]*result)
+	}
+	r, ok := s.results[id][uuid]
+	if !ok {
+		r = new(result)
+		s.results[id][uuid] = r
+	}
+	r.status = status
+	r.raw = clone(raw)
+	r.updatedAt = time.Now()
+	return r
+}
+
+func (s *InMem) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateLastSeen(r.ID)
+	if report.Status == "Idle" {
+		return nil
+	}
+	if s.rm && report.Status != "NotNow" {
+		s.deleteCommand(r.ID, report.CommandUUID)
+		return nil
+	}
+	if _, ok := s.commands[report.CommandUUID]; !ok {
+		return fmt.Errorf("command not found: %s", report.CommandUUID)
+	}
+	result := s.storeResult(r.ID, report.CommandUUID, report.Status, report.Raw)
+	if report.Status == "NotNow" {
+		// only record the first NotNow
+		if result.notNowAt.IsZero() {
+			result.notNowAt = result.updatedAt
+		}
+		result.notNowTally++
+	}
+	return nil
+}
+
+func (s *InMem) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	var next string
+	var nextItem *queued
+	for uuid, item := range s.queues[r.ID] {
+		if !item.deliverable(now) {
+			continue
+		}
+		if result, ok := s.results[r.ID][uuid]; ok && (result.status != "NotNow" || skipNotNow) {
+			continue
+		}
+		if nextItem == nil ||
+			item.priority > nextItem.priority ||
+			(item.priority == nextItem.priority && item.seq < nextItem.seq) {
+			next, nextItem = uuid, item
+		}
+	}
+	if nextItem == nil {
+		return nil, nil
+	}
+	c := s.commands[next]
+	command := &mdm.Command{
+		CommandUUID: next,
+		Raw:         clone(c.raw),
+	}
+	command.Command.RequestType = c.requestType
+	return command, nil
+}
+
+func (s *InMem) ClearQueue(r *mdm.Request) error {
+	if r.ParentID != "" {
+		return errors.New("can only clear a device channel queue")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// clear (mark inactive) the queue of not only this device ID, but
+	// all user-channel enrollments with a 'parent' ID of this device.
+	for id, e := range s.enrollments {
+		if e.deviceID != r.ID {
+			continue
+		}
+		for uuid, item := range s.queues[id] {
+			if result, ok := s.results[id][uuid]; ok && result.status != "NotNow" {
+				continue
+			}
+			item.active = false
+		}
+	}
+	return nil
+}
+
+func (s *InMem) RetrieveQueue(_ context.Context, id string) ([]*storage.QueueItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var items []*storage.QueueItem
+	seqs := make(map[*storage.QueueItem]uint64)
+	for uuid, q := range s.queues[id] {
+		item := &storage.QueueItem{
+			CommandUUID: uuid,
+			RequestType: s.commands[uuid].requestType,
+			Priority:    q.priority,
+			Active:      q.active,
+			CreatedAt:   q.createdAt,
+		}
+		if !q.notBefore.IsZero() {
+			notBefore := q.notBefore
+			item.NotBefore = &notBefore
+		}
+		if !q.expires.IsZero() {
+			expires := q.expires
+			item.Expires = &expires
+		}
+		if result, ok := s.results[id][uuid]; ok {
+			item.Status = result.status
+			updatedAt := result.updatedAt
+			item.ResultUpdatedAt = &updatedAt
+		}
+		seqs[item] = q.seq
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Priority != items[j].Priority {
+			return items[i].Priority > items[j].Priority
+		}
+		return seqs[items[i]] < seqs[items[j]]
+	})
+	return items, nil
+}
+
+func (s *InMem) RetrieveCommandResults(_ context.Context, uuid string) ([]*storage.CommandResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var results []*storage.CommandResult
+	for id, q := range s.queues {
+		if _, ok := q[uuid]; !ok {
+			continue
+		}
+		cr := &storage.CommandResult{ID: id}
+		if result, ok := s.results[id][uuid]; ok {
+			cr.Status = result.status
+			cr.NotNowTally = result.notNowTally
+			cr.Result = clone(result.raw)
+			updatedAt := result.updatedAt
+			cr.UpdatedAt = &updatedAt
+		}
+		results = append(results, cr)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ID < results[j].ID
+	})
+	return results, nil
+}
+
+func (s *InMem) DequeueCommand(_ context.Context, ids []string, uuid string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(ids) < 1 {
+		for id := range s.queues {
+			ids = append(ids, id)
+		}
+	}
+	var ct int
+	for _, id := range ids {
+		if _, ok := s.queues[id][uuid]; !ok {
+			continue
+		}
+		// NotNow commands are removed like outstanding commands.
+		if result, ok := s.results[id][uuid]; ok {
+			if result.status != "NotNow" {
+				continue
+			}
+			delete(s.results[id], uuid)
+		}
+		delete(s.queues[id], uuid)
+		ct++
+	}
+	s.deleteOrphanCommand(uuid)
+	return ct, nil
+}
+
+func (s *InMem) RetrieveScheduledIDs(_ context.Context, after, before time.Time) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ids []string
+	for id, q := range s.queues {
+		for uuid, item := range q {
+			if _, ok := s.results[id][uuid]; ok || !item.active {
+				continue
+			}
+			if item.notBefore.After(after) && !item.notBefore.After(before) {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *InMem) RetrievePendingIDs(_ context.Context, before time.Time) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	var ids []string
+	for id, q := range s.queues {
+		if e, ok := s.enrollments[id]; !ok || !e.enabled {
+			continue
+		}
+		for uuid, item := range q {
+			if _, ok := s.results[id][uuid]; ok {
+				continue
+			}
+			if !item.createdAt.After(before) && item.deliverable(now) {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *InMem) ExpireCommands(_ context.Context) ([]*storage.ExpiredCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var expired []*storage.ExpiredCommand
+	for id, q := range s.queues {
+		for uuid, item := range q {
+			if !item.active || item.expires.IsZero() || item.expires.After(now) {
+				continue
+			}
+			if result, ok := s.results[id][uuid]; ok && result.status != "NotNow" {
+				continue
+			}
+			expired = append(expired, &storage.ExpiredCommand{
+				ID:          id,
+				CommandUUID: uuid,
+				RequestType: s.commands[uuid].requestType,
+			})
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if expired[i].ID != expired[j].ID {
+			return expired[i].ID < expired[j].ID
+		}
+		return expired[i].CommandUUID < expired[j].CommandUUID
+	})
+	for _, cmd := range expired {
+		if s.rm {
+			s.deleteCommand(cmd.ID, cmd.CommandUUID)
+			continue
+		}
+		raw, err := storage.NewSyntheticResult(cmd.CommandUUID, storage.StatusExpired)
+		if err != nil {
+			return nil, err
+		}
+		s.storeResult(cmd.ID, cmd.CommandUUID, storage.StatusExpired, raw)
+	}
+	return expired, nil
+}
+
+func (s *InMem) RetrieveNotNow(_ context.Context, id, uuid string) (int, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result, ok := s.results[id][uuid]
+	if !ok {
+		return 0, time.Time{}, nil
+	}
+	return result.notNowTally, result.notNowAt, nil
+}
+
+func (s *InMem) DeadLetterCommand(_ context.Context, id, uuid string) error {
+	raw, err := storage.NewSyntheticResult(uuid, storage.StatusDeadLettered)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.queues[id][uuid]
+	if !ok {
+		return nil
+	}
+	item.active = false
+	s.storeResult(id, uuid, storage.StatusDeadLettered, raw)
+	return nil
+}
+
+func (s *InMem) RetrieveDeadLetters(_ context.Context, ids []string) ([]*storage.DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(ids) < 1 {
+		for id := range s.results {
+			ids = append(ids, id)
+		}
+	}
+	var deadLetters []*storage.DeadLetter
+	for _, id := range ids {
+		for uuid, result := range s.results[id] {
+			if result.status != storage.StatusDeadLettered {
+				continue
+			}
+			dl := &storage.DeadLetter{
+				ID:             id,
+				CommandUUID:    uuid,
+				RequestType:    s.commands[uuid].requestType,
+				NotNowTally:    result.notNowTally,
+				DeadLetteredAt: result.updatedAt,
+			}
+			if !result.notNowAt.IsZero() {
+				notNowAt := result.notNowAt
+				dl.NotNowAt = &notNowAt
+			}
+			deadLetters = append(deadLetters, dl)
+		}
+	}
+	sort.Slice(deadLetters, func(i, j int) bool {
+		if deadLetters[i].ID != deadLetters[j].ID {
+			return deadLetters[i].ID < deadLetters[j].ID
+		}
+		return deadLetters[i].DeadLetteredAt.Before(deadLetters[j].DeadLetteredAt)
+	})
+	return deadLetters, nil
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "context"
+
+// InventoryValues are device attributes keyed by attribute name.
+// Values must be able to be marshalled to JSON.
+type InventoryValues map[string]interface{}
+
+// InventoryStore stores device inventory attributes.
+type InventoryStore interface {
+	// StoreInventoryValues merges values into the inventory of the
+	// enrollment id. Stored attributes missing from values are kept.
+	StoreInventoryValues(ctx context.Context, id string, values InventoryValues) error
+}
+
+// InventoryRetriever retrieves device inventory attributes.
+type InventoryRetriever interface {
+	// RetrieveInventory returns the inventory attributes of the
+	// enrollment id. An empty result (with no error) is returned if
+	// there is no inventory for the enrollment.
+	RetrieveInventory(ctx context.Context, id string) (InventoryValues, error)
+}
@@ -0,0 +1,71 @@
+package mysql
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StoreInventoryValues upserts each inventory attribute as a row.
+// Values are stored JSON encoded.
+func (s *MySQLStorage) StoreInventoryValues(ctx context.Context, id string, values storage.InventoryValues) error {
+	if len(values) < 1 {
+		return nil
+	}
+	// sort for a consistent locking order
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := make([]interface{}, 0, len(names)*3)
+	for _, name := range names {
+		value, err := json.Marshal(values[name])
+		if err != nil {
+			return err
+		}
+		args = append(args, id, name, string(value))
+	}
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO inventory
+    (id, name, value)
+VALUES
+    (?, ?, ?)`+strings.Repeat(", (?, ?, ?)", len(names)-1)+` AS new
+ON DUPLICATE KEY
+UPDATE
+    value = new.value;`,
+		args...,
+	)
+	return err
+}
+
+// RetrieveInventory retrieves and JSON decodes the inventory attributes of id.
+func (s *MySQLStorage) RetrieveInventory(ctx context.Context, id string) (storage.InventoryValues, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT name, value FROM inventory WHERE id = ?;`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make(storage.InventoryValues)
+	for rows.Next() {
+		var name string
+		var value []byte
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal(value, &v); err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+	return values, rows.Err()
+}
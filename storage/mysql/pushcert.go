@@ -3,9 +3,11 @@ package mysql
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"strconv"
 
 	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/storage"
 )
 
 func (s *MySQLStorage) RetrievePushCert(ctx context.Context, topic string) (*tls.Certificate, string, error) {
@@ -60,3 +62,40 @@ UPDATE
 	)
 	return err
 }
+
+// ListPushCerts returns information about each stored push certificate.
+func (s *MySQLStorage) ListPushCerts(ctx context.Context) ([]*storage.PushCertInfo, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT topic, cert_pem, stale_token FROM push_certs ORDER BY topic;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var infos []*storage.PushCertInfo
+	for rows.Next() {
+		var topic string
+		var certPEM []byte
+		var staleToken int
+		if err := rows.Scan(&topic, &certPEM, &staleToken); err != nil {
+			return nil, err
+		}
+		info, err := storage.NewPushCertInfo(certPEM, strconv.Itoa(staleToken))
+		if err != nil {
+			return nil, fmt.Errorf("push cert for topic %q: %w", topic, err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+// DeletePushCert deletes the push certificate of topic.
+func (s *MySQLStorage) DeletePushCert(ctx context.Context, topic string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM push_certs WHERE topic = ?;`,
+		topic,
+	)
+	return err
+}
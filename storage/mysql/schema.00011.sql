@@ -0,0 +1,27 @@
+ALTER TABLE enrollment_queue ADD COLUMN expires_at TIMESTAMP NULL;
+ALTER TABLE enrollment_queue ADD INDEX (expires_at);
+CREATE OR REPLACE VIEW view_queue AS
+SELECT
+    q.id,
+    q.created_at,
+    q.active,
+    q.priority,
+    q.not_before,
+    q.expires_at,
+    c.command_uuid,
+    c.request_type,
+    c.command,
+    r.updated_at AS result_updated_at,
+    r.status,
+    r.result
+FROM
+    enrollment_queue AS q
+
+        INNER JOIN commands AS c
+        ON q.command_uuid = c.command_uuid
+
+        LEFT JOIN command_results r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+ORDER BY
+    q.priority DESC,
+    q.created_at;
@@ -0,0 +1,16 @@
+CREATE TABLE inventory (
+    id    VARCHAR(255) NOT NULL,
+    name  VARCHAR(127) NOT NULL,
+    value TEXT         NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, name),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (name != '')
+);
@@ -0,0 +1,18 @@
+CREATE TABLE push_retries (
+    id VARCHAR(255) NOT NULL,
+
+    attempts   INTEGER   NOT NULL DEFAULT 0,
+    next_at    TIMESTAMP NOT NULL,
+    last_error TEXT      NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    INDEX (next_at),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,17 @@
+CREATE TABLE bad_push_tokens (
+    id VARCHAR(255) NOT NULL,
+
+    token_hex VARCHAR(255) NOT NULL,
+    reason    VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (token_hex != '')
+);
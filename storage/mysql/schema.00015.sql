@@ -0,0 +1,20 @@
+CREATE TABLE push_history (
+    history_id BIGINT       NOT NULL AUTO_INCREMENT,
+    id         VARCHAR(255) NOT NULL,
+
+    pushed_at TIMESTAMP    NOT NULL,
+    apns_id   VARCHAR(255) NULL,
+    topic     VARCHAR(255) NOT NULL,
+    error     TEXT         NULL,
+    reason    VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (history_id),
+
+    INDEX (id, history_id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -197,12 +197,19 @@ CREATE TABLE enrollment_queue (
     active   BOOLEAN NOT NULL DEFAULT 1,
     priority TINYINT NOT NULL DEFAULT 0,
 
+    -- commands are not delivered before this time
+    not_before TIMESTAMP NULL,
+    -- commands are not delivered after this time
+    expires_at TIMESTAMP NULL,
+
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 
     PRIMARY KEY (id, command_uuid),
 
     INDEX (priority DESC, created_at),
+    INDEX (not_before),
+    INDEX (expires_at),
 
     FOREIGN KEY (id)
         REFERENCES enrollments (id)
@@ -224,6 +231,8 @@ SELECT
     q.created_at,
     q.active,
     q.priority,
+    q.not_before,
+    q.expires_at,
     c.command_uuid,
     c.request_type,
     c.command,
@@ -280,3 +289,82 @@ CREATE TABLE cert_auth_associations (
     CHECK (id != ''),
     CHECK (sha256 != '')
 );
+
+
+CREATE TABLE inventory (
+    id    VARCHAR(255) NOT NULL,
+    name  VARCHAR(127) NOT NULL,
+    value TEXT         NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, name),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (name != '')
+);
+
+
+CREATE TABLE push_retries (
+    id VARCHAR(255) NOT NULL,
+
+    attempts   INTEGER   NOT NULL DEFAULT 0,
+    next_at    TIMESTAMP NOT NULL,
+    last_error TEXT      NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    INDEX (next_at),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+
+CREATE TABLE bad_push_tokens (
+    id VARCHAR(255) NOT NULL,
+
+    token_hex VARCHAR(255) NOT NULL,
+    reason    VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (token_hex != '')
+);
+
+
+CREATE TABLE push_history (
+    history_id BIGINT       NOT NULL AUTO_INCREMENT,
+    id         VARCHAR(255) NOT NULL,
+
+    pushed_at TIMESTAMP    NOT NULL,
+    apns_id   VARCHAR(255) NULL,
+    topic     VARCHAR(255) NOT NULL,
+    error     TEXT         NULL,
+    reason    VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (history_id),
+
+    INDEX (id, history_id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,65 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StoreBadPushToken upserts the bad push token for token.ID.
+func (s *PgSQLStorage) StoreBadPushToken(ctx context.Context, token *storage.BadPushToken) error {
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO bad_push_tokens
+    (id, token_hex, reason)
+VALUES
+    ($1, $2, $3)
+ON CONFLICT ON CONSTRAINT bad_push_tokens_pkey DO UPDATE
+SET
+    token_hex = EXCLUDED.token_hex,
+    reason = EXCLUDED.reason;`,
+		token.ID,
+		token.Token,
+		nullEmptyString(token.Reason),
+	)
+	return err
+}
+
+// RetrieveBadPushTokens retrieves the bad push tokens of ids.
+func (s *PgSQLStorage) RetrieveBadPushTokens(ctx context.Context, ids []string) (map[string]*storage.BadPushToken, error) {
+	if len(ids) < 1 {
+		return nil, errors.New("no ids provided")
+	}
+	var qs strings.Builder
+	qs.WriteString(`SELECT id, token_hex, reason FROM bad_push_tokens WHERE id IN (`)
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+		if i > 0 {
+			qs.WriteString(",")
+		}
+		qs.WriteString("$")
+		qs.WriteString(strconv.Itoa(i + 1))
+	}
+	qs.WriteString(`);`)
+	rows, err := s.db.QueryContext(ctx, qs.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := make(map[string]*storage.BadPushToken)
+	for rows.Next() {
+		token := new(storage.BadPushToken)
+		var reason sql.NullString
+		if err := rows.Scan(&token.ID, &token.Token, &reason); err != nil {
+			return nil, err
+		}
+		token.Reason = reason.String
+		tokens[token.ID] = token
+	}
+	return tokens, rows.Err()
+}
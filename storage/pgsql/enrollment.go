@@ -0,0 +1,100 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// enrollmentWhere builds the SQL WHERE conditions and arguments for filter.
+func enrollmentWhere(filter *storage.EnrollmentFilter, cursor string) (string, []interface{}) {
+	args := []interface{}{cursor}
+	where := []string{"e.id > $1"}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	in := func(col string, values []string) {
+		if len(values) < 1 {
+			return
+		}
+		qs := make([]string, len(values))
+		for i, v := range values {
+			qs[i] = arg(v)
+		}
+		where = append(where, col+" IN ("+strings.Join(qs, ",")+")")
+	}
+	if filter != nil {
+		in("e.id", filter.IDs)
+		in("e.type", filter.Types)
+		in("e.topic", filter.Topics)
+		in("d.serial_number", filter.SerialNumbers)
+		if len(filter.ParentIDs) > 0 {
+			where = append(where, "e.user_id IS NOT NULL")
+			in("e.device_id", filter.ParentIDs)
+		}
+		if filter.DeviceChannel != nil {
+			if *filter.DeviceChannel {
+				where = append(where, "e.user_id IS NULL")
+			} else {
+				where = append(where, "e.user_id IS NOT NULL")
+			}
+		}
+		if filter.Enabled != nil {
+			where = append(where, "e.enabled = "+arg(*filter.Enabled))
+		}
+		if !filter.LastSeenAfter.IsZero() {
+			where = append(where, "e.last_seen_at >= to_timestamp("+arg(filter.LastSeenAfter.Unix())+")")
+		}
+		if !filter.LastSeenBefore.IsZero() {
+			where = append(where, "e.last_seen_at < to_timestamp("+arg(filter.LastSeenBefore.Unix())+")")
+		}
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// ListEnrollments lists enrollments matching filter.
+func (s *PgSQLStorage) ListEnrollments(ctx context.Context, filter *storage.EnrollmentFilter, cursor string, limit int) ([]*storage.Enrollment, error) {
+	where, args := enrollmentWhere(filter, cursor)
+	query := `
+SELECT
+    e.id, e.device_id, e.user_id, e.type, e.topic, d.serial_number,
+    e.enabled, e.token_update_tally, e.last_seen_at
+FROM
+    enrollments e
+    LEFT JOIN devices d
+        ON d.id = e.device_id
+WHERE
+    ` + where + `
+ORDER BY
+    e.id`
+	if limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query+`;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var enrollments []*storage.Enrollment
+	for rows.Next() {
+		var deviceID string
+		var userID, serial sql.NullString
+		e := new(storage.Enrollment)
+		if err := rows.Scan(
+			&e.ID, &deviceID, &userID, &e.Type, &e.Topic, &serial,
+			&e.Enabled, &e.TokenUpdateTally, &e.LastSeenAt,
+		); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			e.ParentID = deviceID
+		}
+		e.SerialNumber = serial.String
+		enrollments = append(enrollments, e)
+	}
+	return enrollments, rows.Err()
+}
@@ -0,0 +1,76 @@
+package pgsql
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StoreInventoryValues upserts each inventory attribute as a row.
+// Values are stored JSON encoded.
+func (s *PgSQLStorage) StoreInventoryValues(ctx context.Context, id string, values storage.InventoryValues) error {
+	if len(values) < 1 {
+		return nil
+	}
+	// sort for a consistent locking order
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var query strings.Builder
+	query.WriteString(`INSERT INTO inventory (id, name, value) VALUES `)
+	args := make([]interface{}, 0, len(names)*3)
+	for i, name := range names {
+		value, err := json.Marshal(values[name])
+		if err != nil {
+			return err
+		}
+		args = append(args, id, name, string(value))
+		if i > 0 {
+			query.WriteString(",")
+		}
+		ind := i * 3
+		query.WriteString("($")
+		query.WriteString(strconv.Itoa(ind + 1))
+		query.WriteString(", $")
+		query.WriteString(strconv.Itoa(ind + 2))
+		query.WriteString(", $")
+		query.WriteString(strconv.Itoa(ind + 3))
+		query.WriteString(")")
+	}
+	query.WriteString(` ON CONFLICT ON CONSTRAINT inventory_pkey DO UPDATE SET value = EXCLUDED.value;`)
+	_, err := s.db.ExecContext(ctx, query.String(), args...)
+	return err
+}
+
+// RetrieveInventory retrieves and JSON decodes the inventory attributes of id.
+func (s *PgSQLStorage) RetrieveInventory(ctx context.Context, id string) (storage.InventoryValues, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT name, value FROM inventory WHERE id = $1;`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make(storage.InventoryValues)
+	for rows.Next() {
+		var name string
+		var value []byte
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal(value, &v); err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+	return values, rows.Err()
+}
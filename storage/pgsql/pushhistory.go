@@ -0,0 +1,137 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StorePushResults inserts results and prunes the push history of
+// their enrollments to the keep most recent push results.
+func (s *PgSQLStorage) StorePushResults(ctx context.Context, results []*storage.PushResult, keep int) error {
+	if len(results) < 1 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = storePushResults(ctx, tx, results, keep); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func storePushResults(ctx context.Context, tx *sql.Tx, results []*storage.PushResult, keep int) error {
+	var qs strings.Builder
+	qs.WriteString(`INSERT INTO push_history (id, pushed_at, apns_id, topic, error, reason) VALUES `)
+	args := make([]interface{}, 0, len(results)*6)
+	for i, result := range results {
+		if i > 0 {
+			qs.WriteString(", ")
+		}
+		n := i * 6
+		qs.WriteString("($" + strconv.Itoa(n+1))
+		qs.WriteString(", to_timestamp($" + strconv.Itoa(n+2) + ")")
+		for j := 3; j <= 6; j++ {
+			qs.WriteString(", $" + strconv.Itoa(n+j))
+		}
+		qs.WriteString(")")
+		args = append(
+			args,
+			result.ID,
+			result.PushedAt.Unix(),
+			nullEmptyString(result.APNsID),
+			result.Topic,
+			nullEmptyString(result.Error),
+			nullEmptyString(result.Reason),
+		)
+	}
+	qs.WriteString(`;`)
+	if _, err := tx.ExecContext(ctx, qs.String(), args...); err != nil {
+		return err
+	}
+	if keep < 1 {
+		return nil
+	}
+	// prune the push history of every enrollment in results at once
+	var ps strings.Builder
+	args = make([]interface{}, 0, len(results)+1)
+	seen := make(map[string]struct{})
+	for _, result := range results {
+		if _, ok := seen[result.ID]; ok {
+			continue
+		}
+		seen[result.ID] = struct{}{}
+		args = append(args, result.ID)
+		if len(args) > 1 {
+			ps.WriteString(", ")
+		}
+		ps.WriteString("$" + strconv.Itoa(len(args)))
+	}
+	args = append(args, keep)
+	_, err := tx.ExecContext(
+		ctx, `
+DELETE FROM
+    push_history
+WHERE
+    history_id IN (
+        SELECT history_id FROM (
+            SELECT
+                history_id,
+                ROW_NUMBER() OVER (PARTITION BY id ORDER BY history_id DESC) AS n
+            FROM
+                push_history
+            WHERE
+                id IN (`+ps.String()+`)
+        ) AS r
+        WHERE r.n > $`+strconv.Itoa(len(args))+`
+    );`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RetrievePushHistory retrieves the most recent push results of id.
+func (s *PgSQLStorage) RetrievePushHistory(ctx context.Context, id string, limit int) ([]*storage.PushResult, error) {
+	query := `
+SELECT
+    id, pushed_at, apns_id, topic, error, reason
+FROM
+    push_history
+WHERE
+    id = $1
+ORDER BY
+    history_id DESC`
+	if limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query+`;`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []*storage.PushResult
+	for rows.Next() {
+		result := new(storage.PushResult)
+		var apnsID, pushErr, reason sql.NullString
+		if err := rows.Scan(&result.ID, &result.PushedAt, &apnsID, &result.Topic, &pushErr, &reason); err != nil {
+			return nil, err
+		}
+		result.APNsID = apnsID.String
+		result.Error = pushErr.String
+		result.Reason = reason.String
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,85 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StorePushRetry upserts the push retry for retry.ID.
+func (s *PgSQLStorage) StorePushRetry(ctx context.Context, retry *storage.PushRetry) error {
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO push_retries
+    (id, attempts, next_at, last_error)
+VALUES
+    ($1, $2, to_timestamp($3), $4)
+ON CONFLICT ON CONSTRAINT push_retries_pkey DO UPDATE
+SET
+    attempts = EXCLUDED.attempts,
+    next_at = EXCLUDED.next_at,
+    last_error = EXCLUDED.last_error;`,
+		retry.ID,
+		retry.Attempts,
+		retry.NextAt.Unix(),
+		nullEmptyString(retry.LastError),
+	)
+	return err
+}
+
+// RetrieveDuePushRetries retrieves push retries due at or before before.
+func (s *PgSQLStorage) RetrieveDuePushRetries(ctx context.Context, before time.Time, limit int) ([]*storage.PushRetry, error) {
+	query := `
+SELECT
+    id, attempts, next_at, last_error
+FROM
+    push_retries
+WHERE
+    next_at <= to_timestamp($1)
+ORDER BY
+    next_at, id`
+	if limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query+`;`, before.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retries []*storage.PushRetry
+	for rows.Next() {
+		retry := new(storage.PushRetry)
+		var lastErr sql.NullString
+		if err := rows.Scan(&retry.ID, &retry.Attempts, &retry.NextAt, &lastErr); err != nil {
+			return nil, err
+		}
+		retry.LastError = lastErr.String
+		retries = append(retries, retry)
+	}
+	return retries, rows.Err()
+}
+
+// DeletePushRetries deletes the push retries of ids.
+func (s *PgSQLStorage) DeletePushRetries(ctx context.Context, ids []string) error {
+	if len(ids) < 1 {
+		return nil
+	}
+	var qs strings.Builder
+	qs.WriteString(`DELETE FROM push_retries WHERE id IN (`)
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+		if i > 0 {
+			qs.WriteString(",")
+		}
+		qs.WriteString("$")
+		qs.WriteString(strconv.Itoa(i + 1))
+	}
+	qs.WriteString(`);`)
+	_, err := s.db.ExecContext(ctx, qs.String(), args...)
+	return err
+}
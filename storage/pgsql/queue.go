@@ -7,17 +7,23 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
 )
 
-func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command) error {
+func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command, opts *storage.EnqueueOptions) error {
 	if len(ids) < 1 {
 		return errors.New("no id(s) supplied to queue command to")
 	}
+	cmdQuery := `INSERT INTO commands (command_uuid, request_type, command) VALUES ($1, $2, $3)`
+	if opts.Append {
+		cmdQuery += ` ON CONFLICT ON CONSTRAINT commands_pkey DO NOTHING`
+	}
 	_, err := tx.ExecContext(
 		ctx,
-		`INSERT INTO commands (command_uuid, request_type, command) VALUES ($1, $2, $3);`,
+		cmdQuery+`;`,
 		cmd.CommandUUID, cmd.Command.RequestType, cmd.Raw,
 	)
 	if err != nil {
@@ -26,23 +32,39 @@ func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command) er
 
 	var query strings.Builder
 
-	query.WriteString(`INSERT INTO enrollment_queue (id, command_uuid) VALUES `)
-	args := make([]interface{}, len(ids)*2)
+	var notBefore, expires sql.NullInt64
+	if !opts.NotBefore.IsZero() {
+		notBefore = sql.NullInt64{Int64: opts.NotBefore.Unix(), Valid: true}
+	}
+	if !opts.Expires.IsZero() {
+		expires = sql.NullInt64{Int64: opts.Expires.Unix(), Valid: true}
+	}
+
+	query.WriteString(`INSERT INTO enrollment_queue (id, command_uuid, priority, not_before, expires_at) VALUES `)
+	args := make([]interface{}, len(ids)*5)
 	for i, id := range ids {
 		if i > 0 {
 			query.WriteString(",")
 		}
-		ind := i * 2
+		ind := i * 5
 
-		//previous: query += fmt.Sprintf("($%d, $%d)", ind+1, ind+2)
 		query.WriteString("($")
 		query.WriteString(strconv.Itoa(ind + 1))
 		query.WriteString(", $")
 		query.WriteString(strconv.Itoa(ind + 2))
-		query.WriteString(")")
+		query.WriteString(", $")
+		query.WriteString(strconv.Itoa(ind + 3))
+		query.WriteString(", to_timestamp($")
+		query.WriteString(strconv.Itoa(ind + 4))
+		query.WriteString("), to_timestamp($")
+		query.WriteString(strconv.Itoa(ind + 5))
+		query.WriteString("))")
 
 		args[ind] = id
 		args[ind+1] = cmd.CommandUUID
+		args[ind+2] = opts.Priority
+		args[ind+3] = notBefore
+		args[ind+4] = expires
 	}
 	query.WriteString(";")
 
@@ -50,12 +72,12 @@ func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command) er
 	return err
 }
 
-func (s *PgSQLStorage) EnqueueCommand(ctx context.Context, ids []string, cmd *mdm.Command) (map[string]error, error) {
+func (s *PgSQLStorage) EnqueueCommand(ctx context.Context, ids []string, cmd *mdm.Command, opts ...storage.EnqueueOption) (map[string]error, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	if err = enqueue(ctx, tx, ids, cmd); err != nil {
+	if err = enqueue(ctx, tx, ids, cmd, storage.NewEnqueueOptions(opts...)); err != nil {
 		if rbErr := tx.Rollback(); rbErr != nil {
 			return nil, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
 		}
@@ -79,9 +101,13 @@ WHERE id =$1 AND command_uuid =$2;`, id, uuid)
 		return err
 	}
 
-	// now delete the actual command if no enrollments have it queued
-	// nor are there any results for it.
-	_, err = tx.ExecContext(
+	return deleteOrphanCommand(ctx, tx, uuid)
+}
+
+// deleteOrphanCommand deletes the command if no enrollments have it
+// queued nor are there any results for it.
+func deleteOrphanCommand(ctx context.Context, tx *sql.Tx, uuid string) error {
+	_, err := tx.ExecContext(
 		ctx, `
 DELETE FROM commands
 USING
@@ -159,7 +185,7 @@ func (s *PgSQLStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*md
 	command := new(mdm.Command)
 	err := s.db.QueryRowContext(
 		r.Context,
-		`SELECT command_uuid, request_type, command FROM view_queue WHERE id = $1 AND active = TRUE AND `+statusWhere+` LIMIT 1;`,
+		`SELECT command_uuid, request_type, command FROM view_queue WHERE id = $1 AND active = TRUE AND `+statusWhere+` AND (not_before IS NULL OR not_before <= CURRENT_TIMESTAMP) AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP) LIMIT 1;`,
 		r.ID,
 	).Scan(&command.CommandUUID, &command.Command.RequestType, &command.Raw)
 	if err != nil {
@@ -197,3 +223,409 @@ WHERE
 		r.ID)
 	return err
 }
+
+func (s *PgSQLStorage) RetrieveQueue(ctx context.Context, id string) ([]*storage.QueueItem, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    command_uuid, request_type, priority, active, status,
+    created_at, result_updated_at, not_before, expires_at
+FROM
+    view_queue
+WHERE
+    id = $1
+ORDER BY
+    priority DESC,
+    created_at;`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*storage.QueueItem
+	for rows.Next() {
+		var status sql.NullString
+		var resultUpdatedAt, notBefore, expires sql.NullTime
+		item := new(storage.QueueItem)
+		if err := rows.Scan(
+			&item.CommandUUID, &item.RequestType, &item.Priority, &item.Active, &status,
+			&item.CreatedAt, &resultUpdatedAt, &notBefore, &expires,
+		); err != nil {
+			return nil, err
+		}
+		if notBefore.Valid {
+			item.NotBefore = &notBefore.Time
+		}
+		if expires.Valid {
+			item.Expires = &expires.Time
+		}
+		item.Status = status.String
+		if resultUpdatedAt.Valid {
+			item.ResultUpdatedAt = &resultUpdatedAt.Time
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (s *PgSQLStorage) RetrieveCommandResults(ctx context.Context, uuid string) ([]*storage.CommandResult, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    q.id, r.status, r.not_now_tally, r.result, r.updated_at
+FROM
+    enrollment_queue AS q
+    LEFT JOIN command_results AS r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+WHERE
+    q.command_uuid = $1
+ORDER BY
+    q.id;`,
+		uuid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []*storage.CommandResult
+	for rows.Next() {
+		var status sql.NullString
+		var notNowTally sql.NullInt64
+		var updatedAt sql.NullTime
+		result := new(storage.CommandResult)
+		if err := rows.Scan(&result.ID, &status, &notNowTally, &result.Result, &updatedAt); err != nil {
+			return nil, err
+		}
+		result.Status = status.String
+		result.NotNowTally = int(notNowTally.Int64)
+		if updatedAt.Valid {
+			result.UpdatedAt = &updatedAt.Time
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func dequeue(ctx context.Context, tx *sql.Tx, ids []string, uuid string) (int, error) {
+	// lock the command so that enrollments reporting results for it
+	// do not race us
+	_, err := tx.ExecContext(
+		ctx,
+		`SELECT command_uuid FROM commands WHERE command_uuid = $1 FOR UPDATE;`,
+		uuid,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var idsSQL strings.Builder
+	args := []interface{}{uuid}
+	if len(ids) > 0 {
+		idsSQL.WriteString(` AND q.id IN (`)
+		for i, id := range ids {
+			args = append(args, id)
+			if i > 0 {
+				idsSQL.WriteString(",")
+			}
+			idsSQL.WriteString("$")
+			idsSQL.WriteString(strconv.Itoa(i + 2))
+		}
+		idsSQL.WriteString(`)`)
+	}
+	// first delete any NotNow results so that those queued commands
+	// look outstanding, too.
+	_, err = tx.ExecContext(
+		ctx, `
+DELETE FROM command_results AS r
+USING enrollment_queue AS q
+WHERE
+    q.command_uuid = r.command_uuid AND
+    r.id = q.id AND
+    q.command_uuid = $1 AND
+    r.status = 'NotNow'`+idsSQL.String()+`;`,
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	// then delete the outstanding queued commands.
+	res, err := tx.ExecContext(
+		ctx, `
+DELETE FROM enrollment_queue AS q
+WHERE
+    q.command_uuid = $1 AND
+    NOT EXISTS (
+        SELECT 1 FROM command_results AS r
+        WHERE r.command_uuid = q.command_uuid AND r.id = q.id
+    )`+idsSQL.String()+`;`,
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	ct, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(ct), deleteOrphanCommand(ctx, tx, uuid)
+}
+
+func (s *PgSQLStorage) DequeueCommand(ctx context.Context, ids []string, uuid string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	ct, err := dequeue(ctx, tx, ids, uuid)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return 0, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return 0, err
+	}
+	return ct, tx.Commit()
+}
+
+func (s *PgSQLStorage) RetrieveScheduledIDs(ctx context.Context, after, before time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT DISTINCT
+    q.id
+FROM
+    enrollment_queue AS q
+    LEFT JOIN command_results AS r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+WHERE
+    q.active = TRUE AND
+    r.status IS NULL AND
+    q.not_before > to_timestamp($1) AND
+    q.not_before <= to_timestamp($2);`,
+		after.Unix(), before.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *PgSQLStorage) RetrievePendingIDs(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT DISTINCT
+    q.id
+FROM
+    enrollment_queue AS q
+    INNER JOIN enrollments AS e
+        ON e.id = q.id
+    LEFT JOIN command_results AS r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+WHERE
+    e.enabled = TRUE AND
+    q.active = TRUE AND
+    r.status IS NULL AND
+    q.created_at <= to_timestamp($1) AND
+    (q.not_before IS NULL OR q.not_before <= CURRENT_TIMESTAMP) AND
+    (q.expires_at IS NULL OR q.expires_at > CURRENT_TIMESTAMP);`,
+		before.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// expire finalizes the expired commands within tx.
+func (s *PgSQLStorage) expire(ctx context.Context, tx *sql.Tx) ([]*storage.ExpiredCommand, error) {
+	rows, err := tx.QueryContext(
+		ctx, `
+SELECT
+    q.id, q.command_uuid, c.request_type
+FROM
+    enrollment_queue AS q
+    INNER JOIN commands AS c
+        ON q.command_uuid = c.command_uuid
+    LEFT JOIN command_results AS r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+WHERE
+    q.active = TRUE AND
+    (r.status IS NULL OR r.status = 'NotNow') AND
+    q.expires_at <= CURRENT_TIMESTAMP
+FOR UPDATE OF q;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var expired []*storage.ExpiredCommand
+	for rows.Next() {
+		cmd := new(storage.ExpiredCommand)
+		if err := rows.Scan(&cmd.ID, &cmd.CommandUUID, &cmd.RequestType); err != nil {
+			return nil, err
+		}
+		expired = append(expired, cmd)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for _, cmd := range expired {
+		if s.rm {
+			err = s.deleteCommand(ctx, tx, cmd.ID, cmd.CommandUUID)
+		} else {
+			err = storeSyntheticResult(ctx, tx, cmd.ID, cmd.CommandUUID, storage.StatusExpired)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+// storeSyntheticResult stores a synthetic result with status for the
+// queued command.
+func storeSyntheticResult(ctx context.Context, tx *sql.Tx, id, uuid, status string) error {
+	result, err := storage.NewSyntheticResult(uuid, status)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(
+		ctx, `
+INSERT INTO command_results
+    (id, command_uuid, status, result, not_now_at, not_now_tally)
+VALUES
+    ($1, $2, $3, $4, NULL, 0)
+ON CONFLICT ON CONSTRAINT command_results_pkey DO UPDATE
+SET
+    status = EXCLUDED.status,
+    result = EXCLUDED.result;`,
+		id, uuid, status, result,
+	)
+	return err
+}
+
+func (s *PgSQLStorage) ExpireCommands(ctx context.Context) ([]*storage.ExpiredCommand, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	expired, err := s.expire(ctx, tx)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return nil, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return nil, err
+	}
+	return expired, tx.Commit()
+}
+
+func (s *PgSQLStorage) RetrieveNotNow(ctx context.Context, id, uuid string) (int, time.Time, error) {
+	var tally int
+	var notNowAt sql.NullTime
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT not_now_tally, not_now_at FROM command_results WHERE id = $1 AND command_uuid = $2;`,
+		id, uuid,
+	).Scan(&tally, &notNowAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, nil
+	} else if err != nil {
+		return 0, time.Time{}, err
+	}
+	return tally, notNowAt.Time, nil
+}
+
+func deadLetter(ctx context.Context, tx *sql.Tx, id, uuid string) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE enrollment_queue SET active = FALSE WHERE id = $1 AND command_uuid = $2;`,
+		id, uuid,
+	)
+	if err != nil {
+		return err
+	}
+	return storeSyntheticResult(ctx, tx, id, uuid, storage.StatusDeadLettered)
+}
+
+func (s *PgSQLStorage) DeadLetterCommand(ctx context.Context, id, uuid string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = deadLetter(ctx, tx, id, uuid); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PgSQLStorage) RetrieveDeadLetters(ctx context.Context, ids []string) ([]*storage.DeadLetter, error) {
+	var idsSQL strings.Builder
+	args := []interface{}{storage.StatusDeadLettered}
+	if len(ids) > 0 {
+		idsSQL.WriteString(` AND r.id IN (`)
+		for i, id := range ids {
+			args = append(args, id)
+			if i > 0 {
+				idsSQL.WriteString(",")
+			}
+			idsSQL.WriteString("$")
+			idsSQL.WriteString(strconv.Itoa(i + 2))
+		}
+		idsSQL.WriteString(`)`)
+	}
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    r.id, r.command_uuid, c.request_type, r.not_now_tally,
+    r.not_now_at, r.updated_at
+FROM
+    command_results AS r
+    INNER JOIN commands AS c
+        ON c.command_uuid = r.command_uuid
+WHERE
+    r.status = $1`+idsSQL.String()+`
+ORDER BY
+    r.id,
+    r.updated_at;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deadLetters []*storage.DeadLetter
+	for rows.Next() {
+		var notNowAt sql.NullTime
+		dl := new(storage.DeadLetter)
+		if err := rows.Scan(
+			&dl.ID, &dl.CommandUUID, &dl.RequestType, &dl.NotNowTally,
+			&notNowAt, &dl.DeadLetteredAt,
+		); err != nil {
+			return nil, err
+		}
+		if notNowAt.Valid {
+			dl.NotNowAt = &notNowAt.Time
+		}
+		deadLetters = append(deadLetters, dl)
+	}
+	return deadLetters, rows.Err()
+}
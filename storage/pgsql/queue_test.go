@@ -109,3 +109,51 @@ func TestQueue(t *testing.T) {
 		test.TestQueue(t, deviceUDID, storage)
 	})
 }
+
+func TestPgSQLStorage(t *testing.T) {
+	if *flDSN == "" {
+		t.Fatal("PostgreSQL DSN flag not provided to test")
+	}
+
+	storage, err := New(WithDSN(*flDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	// enrollments must exist to satisfy the foreign keys.
+	enrolled := func(ids ...string) *PgSQLStorage {
+		for _, id := range ids {
+			test.EnrollDevice(t, storage, ctx, id)
+		}
+		return storage
+	}
+
+	id := "2BD3F5D4-1F0A-4F5E-9A39-3C7B1B6D4E11"
+	test.TestRetrieveQueue(t, id, enrolled(id))
+	id = "7C1E2A5B-93D4-4B8F-A2E6-0F5D8C3B9A47"
+	test.TestRetrieveCommandResults(t, id, enrolled(id))
+	id = "D4A1C7E2-5B3F-4E9A-8C6D-1F2B3A4C5D6E"
+	test.TestDequeueCommand(t, id, enrolled(id))
+	id = "5E8B2C1A-7D4F-4A3B-9E6C-2B1D0F8A7C53"
+	test.TestScheduledCommands(t, id, enrolled(id))
+	id = "B2D4F6A8-0C1E-4A3B-8D5F-7E9A1C3B5D46"
+	test.TestPendingCommands(t, id, enrolled(id))
+	id = "9A3D6F1B-2C8E-4B7A-A5D4-6E0C1F9B8D72"
+	test.TestExpireCommands(t, id, enrolled(id))
+	id = "3F7C9E2D-8B1A-4D6E-B2C5-7A0E4F1D9C36"
+	test.TestDeadLetters(t, id, enrolled(id))
+	id = "6B2E8D4F-1A9C-4E7B-8D3F-5C0A2E6B9F14"
+	test.TestInventory(t, id, enrolled(id))
+	id = "5D9B3F1A-7E2C-4A8D-9B6F-1C3E5A7D9B20"
+	test.TestBadPushTokens(t, id, enrolled(id))
+
+	id1, id2 := "8C4A1E7D-3F2B-4D9A-B6E5-0A7C9D2F1B48", "E1F3B5D7-9A2C-4E6B-8D0F-2A4C6E8B0D13"
+	test.TestPushRetries(t, id1, id2, enrolled(id1, id2))
+	id1, id2 = "A7E1C3F5-2B4D-4F6A-8C0E-3D5F7B9A1C24", "4F8B2D6E-0C3A-4E1F-9A7D-6B2C8E4F0A35"
+	test.TestPushHistory(t, id1, id2, enrolled(id1, id2))
+
+	test.TestPushCerts(t, storage)
+	test.TestEnrollmentLister(t, ctx, storage)
+}
@@ -197,6 +197,11 @@ CREATE TABLE enrollment_queue
     active       BOOLEAN      NOT NULL DEFAULT TRUE,
     priority     SMALLINT     NOT NULL DEFAULT 0,
 
+    -- commands are not delivered before this time
+    not_before   TIMESTAMP    NULL,
+    -- commands are not delivered after this time
+    expires_at   TIMESTAMP    NULL,
+
     created_at   TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
     updated_at   TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
 
@@ -210,6 +215,8 @@ CREATE TABLE enrollment_queue
         REFERENCES commands (command_uuid)
         ON DELETE CASCADE ON UPDATE CASCADE
 );
+CREATE INDEX idx_not_before ON enrollment_queue (not_before);
+CREATE INDEX idx_expires_at ON enrollment_queue (expires_at);
 
 /* An enrollment's queue is a view into commands, enrollment queued
  * commands, and any results received. Outstanding queue items (i.e.
@@ -226,7 +233,9 @@ SELECT q.id,
        c.command,
        r.updated_at AS result_updated_at,
        r.status,
-       r.result
+       r.result,
+       q.not_before,
+       q.expires_at
 FROM enrollment_queue AS q
 
          INNER JOIN commands AS c
@@ -278,6 +287,85 @@ CREATE TABLE cert_auth_associations
     CHECK (sha256 != '')
 );
 
+CREATE TABLE inventory
+(
+    id         VARCHAR(255) NOT NULL,
+    name       VARCHAR(127) NOT NULL,
+    value      TEXT         NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, name),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (name != '')
+);
+
+CREATE TABLE push_retries
+(
+    id         VARCHAR(255) NOT NULL,
+
+    attempts   INTEGER      NOT NULL DEFAULT 0,
+    next_at    TIMESTAMP    NOT NULL,
+    last_error TEXT         NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX idx_next_at ON push_retries (next_at);
+
+CREATE TABLE bad_push_tokens
+(
+    id        VARCHAR(255) NOT NULL,
+
+    token_hex VARCHAR(255) NOT NULL,
+    reason    VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (token_hex != '')
+);
+
+CREATE TABLE push_history
+(
+    history_id BIGSERIAL    NOT NULL,
+    id         VARCHAR(255) NOT NULL,
+
+    pushed_at TIMESTAMP    NOT NULL,
+    apns_id   VARCHAR(255) NULL,
+    topic     VARCHAR(255) NOT NULL,
+    error     TEXT         NULL,
+    reason    VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (history_id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX idx_push_history_id ON push_history (id, history_id);
+
 /* creating function to update current_timestamp, works with triggers to tables
    same as MySQL functionality:
    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP*/
@@ -315,3 +403,12 @@ CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON push_certs
 
 CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON cert_auth_associations
     FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON inventory
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON push_retries
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON bad_push_tokens
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
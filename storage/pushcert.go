@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+)
+
+// PushCertInfo describes a stored APNs push certificate.
+type PushCertInfo struct {
+	Topic   string `json:"topic"`
+	Subject string `json:"subject"`
+	// SerialNumber is the upper-case hex-encoded certificate serial number.
+	SerialNumber string    `json:"serial_number"`
+	NotAfter     time.Time `json:"not_after"`
+	// StaleToken is the current stale token (see PushCertStore).
+	StaleToken string `json:"stale_token"`
+}
+
+// NewPushCertInfo creates a PushCertInfo from the PEM-encoded push
+// certificate pemCert and its staleToken.
+func NewPushCertInfo(pemCert []byte, staleToken string) (*PushCertInfo, error) {
+	cert, err := cryptoutil.DecodePEMCertificate(pemCert)
+	if err != nil {
+		return nil, err
+	}
+	topic, err := cryptoutil.TopicFromCert(cert)
+	if err != nil {
+		return nil, err
+	}
+	return &PushCertInfo{
+		Topic:        topic,
+		Subject:      cert.Subject.String(),
+		SerialNumber: strings.ToUpper(cert.SerialNumber.Text(16)),
+		NotAfter:     cert.NotAfter,
+		StaleToken:   staleToken,
+	}, nil
+}
+
+// PushCertLister lists stored APNs push certificates.
+type PushCertLister interface {
+	// ListPushCerts returns information about each stored push certificate.
+	ListPushCerts(ctx context.Context) ([]*PushCertInfo, error)
+}
+
+// PushCertDeleter deletes stored APNs push certificates.
+type PushCertDeleter interface {
+	// DeletePushCert deletes the push certificate (and key) of topic.
+	// Deleting a topic without a push certificate is not an error.
+	DeletePushCert(ctx context.Context, topic string) error
+}
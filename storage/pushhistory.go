@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// PushResult is the outcome of an APNs push to an enrollment.
+type PushResult struct {
+	ID       string    `json:"id"`
+	PushedAt time.Time `json:"pushed_at"`
+	// APNsID is the apns-id of the push, if APNs replied with one.
+	APNsID string `json:"apns_id,omitempty"`
+	Topic  string `json:"topic"`
+	// Error is the push error, if any.
+	Error string `json:"error,omitempty"`
+	// Reason is the APNs failure reason, if any.
+	Reason string `json:"reason,omitempty"`
+}
+
+// PushHistoryStore stores the outcomes of APNs pushes.
+type PushHistoryStore interface {
+	// StorePushResults stores results and then discards all but the keep
+	// most recent push results of each enrollment in results.
+	// A keep less than one keeps all push results.
+	StorePushResults(ctx context.Context, results []*PushResult, keep int) error
+}
+
+// PushHistoryRetriever retrieves the outcomes of APNs pushes.
+type PushHistoryRetriever interface {
+	// RetrievePushHistory retrieves the most recent push results of id
+	// from newest to oldest. At most limit push results are returned;
+	// a limit less than one means no limit.
+	RetrievePushHistory(ctx context.Context, id string, limit int) ([]*PushResult, error)
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// PushRetry is a push notification to an enrollment that is to be
+// retried after a transient failure.
+type PushRetry struct {
+	ID string
+	// Attempts is the number of failed push attempts so far.
+	Attempts int
+	// NextAt is when the push should next be attempted.
+	NextAt time.Time
+	// LastError is the error of the last failed push attempt.
+	LastError string
+}
+
+// PushRetryStore persists pushes that are to be retried.
+type PushRetryStore interface {
+	// StorePushRetry stores (or replaces) the push retry for retry.ID.
+	StorePushRetry(ctx context.Context, retry *PushRetry) error
+
+	// RetrieveDuePushRetries returns push retries with a NextAt at or
+	// before before ordered by NextAt. At most limit push retries are
+	// returned; a limit less than one means no limit.
+	RetrieveDuePushRetries(ctx context.Context, before time.Time, limit int) ([]*PushRetry, error)
+
+	// DeletePushRetries deletes the push retries of ids, if any.
+	DeletePushRetries(ctx context.Context, ids []string) error
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/groob/plist"
+)
+
+const (
+	// StatusExpired is the synthetic command result status given to
+	// queued commands that expired before an enrollment completed them.
+	StatusExpired = "Expired"
+	// StatusDeadLettered is the synthetic command result status given
+	// to queued commands that failed by being deferred (with NotNow)
+	// for too long.
+	StatusDeadLettered = "DeadLettered"
+)
+
+// EnqueueOptions are options for enqueuing MDM commands.
+type EnqueueOptions struct {
+	// Priority of the command. Higher priority commands are delivered
+	// to enrollments before lower priority commands. Backends may
+	// limit the range of priorities. The default priority is 0.
+	Priority int
+	// NotBefore, if not zero, is the time before which the command
+	// will not be delivered to enrollments.
+	NotBefore time.Time
+	// Expires, if not zero, is the time after which the command will
+	// no longer be delivered to enrollments.
+	Expires time.Time
+	// Append enqueues a command that may already have been enqueued
+	// (e.g. in an earlier batch) to additional enrollments. An
+	// existing command with the same command UUID is not an error.
+	Append bool
+}
+
+// EnqueueOption configures EnqueueOptions.
+type EnqueueOption func(*EnqueueOptions)
+
+// WithPriority sets the priority of the enqueued command.
+func WithPriority(priority int) EnqueueOption {
+	return func(o *EnqueueOptions) {
+		o.Priority = priority
+	}
+}
+
+// WithNotBefore schedules the enqueued command to not be delivered
+// before notBefore.
+func WithNotBefore(notBefore time.Time) EnqueueOption {
+	return func(o *EnqueueOptions) {
+		o.NotBefore = notBefore
+	}
+}
+
+// WithExpires sets the time after which the enqueued command will no
+// longer be delivered.
+func WithExpires(expires time.Time) EnqueueOption {
+	return func(o *EnqueueOptions) {
+		o.Expires = expires
+	}
+}
+
+// WithAppend enqueues a command that may already have been enqueued to
+// other enrollments. This allows enqueuing a command in batches.
+func WithAppend() EnqueueOption {
+	return func(o *EnqueueOptions) {
+		o.Append = true
+	}
+}
+
+// NewEnqueueOptions assembles EnqueueOptions from opts.
+func NewEnqueueOptions(opts ...EnqueueOption) *EnqueueOptions {
+	o := new(EnqueueOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// QueueItem is a command in an enrollment's command queue.
+type QueueItem struct {
+	CommandUUID string `json:"command_uuid"`
+	RequestType string `json:"request_type"`
+	Priority    int    `json:"priority"`
+	// Active is false if the queue has been cleared (e.g. by an
+	// enrollment being disabled) while the command was outstanding.
+	Active bool `json:"active"`
+	// Status is the status of the last command result received from
+	// the enrollment (e.g. "Acknowledged" or "NotNow"). It is empty for
+	// outstanding commands.
+	Status    string     `json:"status,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	// ResultUpdatedAt is when the last command result was received.
+	ResultUpdatedAt *time.Time `json:"result_updated_at,omitempty"`
+}
+
+// QueueRetriever retrieves enrollment command queues.
+type QueueRetriever interface {
+	// RetrieveQueue returns the outstanding, NotNow, and completed
+	// commands queued for enrollment id ordered by priority (highest
+	// first) and then by when they were queued. Backends which delete
+	// commands once they are completed will not return them.
+	RetrieveQueue(ctx context.Context, id string) ([]*QueueItem, error)
+}
+
+// ScheduledCommandRetriever retrieves enrollments with scheduled commands.
+type ScheduledCommandRetriever interface {
+	// RetrieveScheduledIDs returns the IDs of enrollments with
+	// outstanding commands whose not-before time is after after and at
+	// or before before. I.e. those enrollments with commands that have
+	// become deliverable during that period.
+	RetrieveScheduledIDs(ctx context.Context, after, before time.Time) ([]string, error)
+}
+
+// PendingCommandRetriever retrieves enrollments with pending commands.
+type PendingCommandRetriever interface {
+	// RetrievePendingIDs returns the IDs of enabled enrollments with
+	// deliverable commands that were enqueued at or before before and
+	// that the enrollment has not yet responded to.
+	RetrievePendingIDs(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// CommandResult is an enrollment's result for a queued command.
+type CommandResult struct {
+	ID string
+	// Status is the status of the last command result received from
+	// the enrollment. It is empty if no result has been received.
+	Status      string
+	NotNowTally int
+	// Result is the raw command result plist, if any.
+	Result    []byte
+	UpdatedAt *time.Time
+}
+
+// CommandResultsRetriever retrieves the results of queued commands.
+type CommandResultsRetriever interface {
+	// RetrieveCommandResults returns the result of command uuid for
+	// every enrollment the command was queued to ordered by enrollment
+	// ID. Enrollments that have not yet responded have an empty status.
+	// Backends which delete commands once they are completed will not
+	// return those results.
+	RetrieveCommandResults(ctx context.Context, uuid string) ([]*CommandResult, error)
+}
+
+// NewSyntheticResult generates a command result plist for command uuid
+// with status. It is used to record the outcome of commands that the
+// enrollment itself never reported (e.g. those that expired or were
+// dead-lettered).
+func NewSyntheticResult(uuid, status string) ([]byte, error) {
+	return plist.Marshal(&struct {
+		CommandUUID string
+		Status      string
+	}{
+		CommandUUID: uuid,
+		Status:      status,
+	})
+}
+
+// ExpiredCommand is a queued command that expired.
+type ExpiredCommand struct {
+	ID          string
+	CommandUUID string
+	RequestType string
+}
+
+// CommandExpirer expires queued commands.
+type CommandExpirer interface {
+	// ExpireCommands finalizes the active outstanding and NotNow queued
+	// commands whose expiry has passed. They are given a synthetic
+	// result with a status of StatusExpired or, for backends which
+	// delete commands once they are completed, removed. The expired
+	// commands are returned.
+	ExpireCommands(ctx context.Context) ([]*ExpiredCommand, error)
+}
+
+// DeadLetterStore dead-letters queued commands.
+type DeadLetterStore interface {
+	// RetrieveNotNow returns the number of NotNow results received for
+	// command uuid queued for enrollment id and when the first NotNow
+	// was received. A zero time is returned if no NotNow was received.
+	RetrieveNotNow(ctx context.Context, id, uuid string) (tally int, first time.Time, err error)
+
+	// DeadLetterCommand deactivates command uuid queued for enrollment
+	// id and gives it a synthetic result with a status of
+	// StatusDeadLettered. Dead-lettered commands are kept even by
+	// backends which delete commands once they are completed.
+	DeadLetterCommand(ctx context.Context, id, uuid string) error
+}
+
+// DeadLetter is a dead-lettered queued command.
+type DeadLetter struct {
+	ID          string     `json:"id"`
+	CommandUUID string     `json:"command_uuid"`
+	RequestType string     `json:"request_type"`
+	NotNowTally int        `json:"not_now_tally"`
+	NotNowAt    *time.Time `json:"not_now_at,omitempty"`
+	// DeadLetteredAt is when the command was dead-lettered.
+	DeadLetteredAt time.Time `json:"dead_lettered_at"`
+}
+
+// DeadLetterRetriever retrieves dead-lettered commands.
+type DeadLetterRetriever interface {
+	// RetrieveDeadLetters returns the dead-lettered commands of
+	// enrollment ids or of all enrollments if ids is empty ordered by
+	// enrollment ID and then by when they were dead-lettered.
+	RetrieveDeadLetters(ctx context.Context, ids []string) ([]*DeadLetter, error)
+}
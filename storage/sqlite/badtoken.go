@@ -0,0 +1,60 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StoreBadPushToken upserts the bad push token for token.ID.
+func (s *SQLiteStorage) StoreBadPushToken(ctx context.Context, token *storage.BadPushToken) error {
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO bad_push_tokens
+    (id, token_hex, reason)
+VALUES
+    (?, ?, ?)
+ON CONFLICT (id) DO
+UPDATE SET
+    token_hex = excluded.token_hex,
+    reason = excluded.reason;`,
+		token.ID,
+		token.Token,
+		nullEmptyString(token.Reason),
+	)
+	return err
+}
+
+// RetrieveBadPushTokens retrieves the bad push tokens of ids.
+func (s *SQLiteStorage) RetrieveBadPushTokens(ctx context.Context, ids []string) (map[string]*storage.BadPushToken, error) {
+	if len(ids) < 1 {
+		return nil, errors.New("no ids provided")
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, token_hex, reason FROM bad_push_tokens WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`);`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := make(map[string]*storage.BadPushToken)
+	for rows.Next() {
+		token := new(storage.BadPushToken)
+		var reason sql.NullString
+		if err := rows.Scan(&token.ID, &token.Token, &reason); err != nil {
+			return nil, err
+		}
+		token.Reason = reason.String
+		tokens[token.ID] = token
+	}
+	return tokens, rows.Err()
+}
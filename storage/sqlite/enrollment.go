@@ -0,0 +1,101 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// enrollmentWhere builds the SQL WHERE conditions and arguments for filter.
+func enrollmentWhere(filter *storage.EnrollmentFilter, cursor string) (string, []interface{}) {
+	where := []string{"e.id > ?"}
+	args := []interface{}{cursor}
+	in := func(col string, values []string) {
+		if len(values) < 1 {
+			return
+		}
+		where = append(where, col+" IN (?"+strings.Repeat(", ?", len(values)-1)+")")
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+	if filter != nil {
+		in("e.id", filter.IDs)
+		in("e.type", filter.Types)
+		in("e.topic", filter.Topics)
+		in("d.serial_number", filter.SerialNumbers)
+		if len(filter.ParentIDs) > 0 {
+			where = append(where, "e.user_id IS NOT NULL")
+			in("e.device_id", filter.ParentIDs)
+		}
+		if filter.DeviceChannel != nil {
+			if *filter.DeviceChannel {
+				where = append(where, "e.user_id IS NULL")
+			} else {
+				where = append(where, "e.user_id IS NOT NULL")
+			}
+		}
+		if filter.Enabled != nil {
+			where = append(where, "e.enabled = ?")
+			args = append(args, *filter.Enabled)
+		}
+		if !filter.LastSeenAfter.IsZero() {
+			where = append(where, "e.last_seen_at >= datetime(?, 'unixepoch')")
+			args = append(args, filter.LastSeenAfter.Unix())
+		}
+		if !filter.LastSeenBefore.IsZero() {
+			where = append(where, "e.last_seen_at < datetime(?, 'unixepoch')")
+			args = append(args, filter.LastSeenBefore.Unix())
+		}
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// ListEnrollments lists enrollments matching filter.
+func (s *SQLiteStorage) ListEnrollments(ctx context.Context, filter *storage.EnrollmentFilter, cursor string, limit int) ([]*storage.Enrollment, error) {
+	where, args := enrollmentWhere(filter, cursor)
+	query := `
+SELECT
+    e.id, e.device_id, e.user_id, e.type, e.topic, d.serial_number,
+    e.enabled, e.token_update_tally, unixepoch(e.last_seen_at)
+FROM
+    enrollments e
+    LEFT JOIN devices d
+        ON d.id = e.device_id
+WHERE
+    ` + where + `
+ORDER BY
+    e.id`
+	if limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query+`;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var enrollments []*storage.Enrollment
+	for rows.Next() {
+		var deviceID string
+		var userID, serial sql.NullString
+		var lastSeen int64
+		e := new(storage.Enrollment)
+		if err := rows.Scan(
+			&e.ID, &deviceID, &userID, &e.Type, &e.Topic, &serial,
+			&e.Enabled, &e.TokenUpdateTally, &lastSeen,
+		); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			e.ParentID = deviceID
+		}
+		e.SerialNumber = serial.String
+		e.LastSeenAt = time.Unix(lastSeen, 0).UTC()
+		enrollments = append(enrollments, e)
+	}
+	return enrollments, rows.Err()
+}
@@ -0,0 +1,101 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *SQLiteStorage) RetrievePushCert(ctx context.Context, topic string) (*tls.Certificate, string, error) {
+	var certPEM, keyPEM []byte
+	var staleToken int
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT cert_pem, key_pem, stale_token FROM push_certs WHERE topic = ?;`,
+		topic,
+	).Scan(&certPEM, &keyPEM, &staleToken)
+	if err != nil {
+		return nil, "", err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, "", err
+	}
+	return &cert, strconv.Itoa(staleToken), err
+}
+
+func (s *SQLiteStorage) IsPushCertStale(ctx context.Context, topic, staleToken string) (bool, error) {
+	var staleTokenInt, dbStaleToken int
+	staleTokenInt, err := strconv.Atoi(staleToken)
+	if err != nil {
+		return true, err
+	}
+	err = s.db.QueryRowContext(
+		ctx,
+		`SELECT stale_token FROM push_certs WHERE topic = ?;`,
+		topic,
+	).Scan(&dbStaleToken)
+	return dbStaleToken != staleTokenInt, err
+}
+
+func (s *SQLiteStorage) StorePushCert(ctx context.Context, pemCert, pemKey []byte) error {
+	topic, err := cryptoutil.TopicFromPEMCert(pemCert)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(
+		ctx, `
+INSERT INTO push_certs
+    (topic, cert_pem, key_pem, stale_token)
+VALUES
+    (?, ?, ?, 0)
+ON CONFLICT (topic) DO
+UPDATE SET
+    cert_pem = excluded.cert_pem,
+    key_pem = excluded.key_pem,
+    stale_token = push_certs.stale_token + 1;`,
+		topic, string(pemCert), string(pemKey),
+	)
+	return err
+}
+
+// ListPushCerts returns information about each stored push certificate.
+func (s *SQLiteStorage) ListPushCerts(ctx context.Context) ([]*storage.PushCertInfo, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT topic, cert_pem, stale_token FROM push_certs ORDER BY topic;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var infos []*storage.PushCertInfo
+	for rows.Next() {
+		var topic string
+		var certPEM []byte
+		var staleToken int
+		if err := rows.Scan(&topic, &certPEM, &staleToken); err != nil {
+			return nil, err
+		}
+		info, err := storage.NewPushCertInfo(certPEM, strconv.Itoa(staleToken))
+		if err != nil {
+			return nil, fmt.Errorf("push cert for topic %q: %w", topic, err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+// DeletePushCert deletes the push certificate of topic.
+func (s *SQLiteStorage) DeletePushCert(ctx context.Context, topic string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM push_certs WHERE topic = ?;`,
+		topic,
+	)
+	return err
+}
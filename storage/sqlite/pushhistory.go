@@ -0,0 +1,122 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StorePushResults inserts results and prunes the push history of
+// their enrollments to the keep most recent push results.
+func (s *SQLiteStorage) StorePushResults(ctx context.Context, results []*storage.PushResult, keep int) error {
+	if len(results) < 1 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = storePushResults(ctx, tx, results, keep); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func storePushResults(ctx context.Context, tx *sql.Tx, results []*storage.PushResult, keep int) error {
+	query := `INSERT INTO push_history (id, pushed_at, apns_id, topic, error, reason) VALUES (?, datetime(?, 'unixepoch'), ?, ?, ?, ?)`
+	query += strings.Repeat(", (?, datetime(?, 'unixepoch'), ?, ?, ?, ?)", len(results)-1)
+	args := make([]interface{}, 0, len(results)*6)
+	for _, result := range results {
+		args = append(
+			args,
+			result.ID,
+			result.PushedAt.Unix(),
+			nullEmptyString(result.APNsID),
+			result.Topic,
+			nullEmptyString(result.Error),
+			nullEmptyString(result.Reason),
+		)
+	}
+	if _, err := tx.ExecContext(ctx, query+";", args...); err != nil {
+		return err
+	}
+	if keep < 1 {
+		return nil
+	}
+	// prune the push history of every enrollment in results at once
+	ids := make([]interface{}, 0, len(results))
+	seen := make(map[string]struct{})
+	for _, result := range results {
+		if _, ok := seen[result.ID]; !ok {
+			seen[result.ID] = struct{}{}
+			ids = append(ids, result.ID)
+		}
+	}
+	_, err := tx.ExecContext(
+		ctx, `
+DELETE FROM
+    push_history
+WHERE
+    history_id IN (
+        SELECT history_id FROM (
+            SELECT
+                history_id,
+                ROW_NUMBER() OVER (PARTITION BY id ORDER BY history_id DESC) AS n
+            FROM
+                push_history
+            WHERE
+                id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+        )
+        WHERE n > ?
+    );`,
+		append(ids, keep)...,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RetrievePushHistory retrieves the most recent push results of id.
+func (s *SQLiteStorage) RetrievePushHistory(ctx context.Context, id string, limit int) ([]*storage.PushResult, error) {
+	query := `
+SELECT
+    id, unixepoch(pushed_at), apns_id, topic, error, reason
+FROM
+    push_history
+WHERE
+    id = ?
+ORDER BY
+    history_id DESC`
+	if limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query+`;`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []*storage.PushResult
+	for rows.Next() {
+		result := new(storage.PushResult)
+		var pushedAt int64
+		var apnsID, pushErr, reason sql.NullString
+		if err := rows.Scan(&result.ID, &pushedAt, &apnsID, &result.Topic, &pushErr, &reason); err != nil {
+			return nil, err
+		}
+		result.PushedAt = time.Unix(pushedAt, 0)
+		result.APNsID = apnsID.String
+		result.Error = pushErr.String
+		result.Reason = reason.String
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
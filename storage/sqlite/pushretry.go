@@ -0,0 +1,83 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// StorePushRetry upserts the push retry for retry.ID.
+func (s *SQLiteStorage) StorePushRetry(ctx context.Context, retry *storage.PushRetry) error {
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO push_retries
+    (id, attempts, next_at, last_error)
+VALUES
+    (?, ?, datetime(?, 'unixepoch'), ?)
+ON CONFLICT (id) DO
+UPDATE SET
+    attempts = excluded.attempts,
+    next_at = excluded.next_at,
+    last_error = excluded.last_error;`,
+		retry.ID,
+		retry.Attempts,
+		retry.NextAt.Unix(),
+		nullEmptyString(retry.LastError),
+	)
+	return err
+}
+
+// RetrieveDuePushRetries retrieves push retries due at or before before.
+func (s *SQLiteStorage) RetrieveDuePushRetries(ctx context.Context, before time.Time, limit int) ([]*storage.PushRetry, error) {
+	query := `
+SELECT
+    id, attempts, unixepoch(next_at), last_error
+FROM
+    push_retries
+WHERE
+    next_at <= datetime(?, 'unixepoch')
+ORDER BY
+    next_at, id`
+	if limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query+`;`, before.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var retries []*storage.PushRetry
+	for rows.Next() {
+		retry := new(storage.PushRetry)
+		var nextAt int64
+		var lastErr sql.NullString
+		if err := rows.Scan(&retry.ID, &retry.Attempts, &nextAt, &lastErr); err != nil {
+			return nil, err
+		}
+		retry.NextAt = time.Unix(nextAt, 0)
+		retry.LastError = lastErr.String
+		retries = append(retries, retry)
+	}
+	return retries, rows.Err()
+}
+
+// DeletePushRetries deletes the push retries of ids.
+func (s *SQLiteStorage) DeletePushRetries(ctx context.Context, ids []string) error {
+	if len(ids) < 1 {
+		return nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	_, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM push_retries WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`);`,
+		args...,
+	)
+	return err
+}
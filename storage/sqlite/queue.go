@@ -0,0 +1,613 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command, opts *storage.EnqueueOptions) error {
+	if len(ids) < 1 {
+		return errors.New("no id(s) supplied to queue command to")
+	}
+	query := `INSERT INTO commands (command_uuid, request_type, command) VALUES (?, ?, ?)`
+	if opts.Append {
+		query += ` ON CONFLICT (command_uuid) DO NOTHING`
+	}
+	_, err := tx.ExecContext(
+		ctx,
+		query+`;`,
+		cmd.CommandUUID, cmd.Command.RequestType, string(cmd.Raw),
+	)
+	if err != nil {
+		return err
+	}
+	var notBefore, expires sql.NullInt64
+	if !opts.NotBefore.IsZero() {
+		notBefore = sql.NullInt64{Int64: opts.NotBefore.Unix(), Valid: true}
+	}
+	if !opts.Expires.IsZero() {
+		expires = sql.NullInt64{Int64: opts.Expires.Unix(), Valid: true}
+	}
+	query = `INSERT INTO enrollment_queue (id, command_uuid, priority, not_before, expires_at) VALUES (?, ?, ?, datetime(?, 'unixepoch'), datetime(?, 'unixepoch'))`
+	query += strings.Repeat(", (?, ?, ?, datetime(?, 'unixepoch'), datetime(?, 'unixepoch'))", len(ids)-1)
+	args := make([]interface{}, len(ids)*5)
+	for i, id := range ids {
+		args[i*5] = id
+		args[i*5+1] = cmd.CommandUUID
+		args[i*5+2] = opts.Priority
+		args[i*5+3] = notBefore
+		args[i*5+4] = expires
+	}
+	_, err = tx.ExecContext(ctx, query+";", args...)
+	return err
+}
+
+func (m *SQLiteStorage) EnqueueCommand(ctx context.Context, ids []string, cmd *mdm.Command, opts ...storage.EnqueueOption) (map[string]error, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = enqueue(ctx, tx, ids, cmd, storage.NewEnqueueOptions(opts...)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return nil, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return nil, err
+	}
+	return nil, tx.Commit()
+}
+
+func (s *SQLiteStorage) deleteCommand(ctx context.Context, tx *sql.Tx, id, uuid string) error {
+	// SQLite transactions hold a database lock so there is no need
+	// for a record lock on the command.
+	_, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM enrollment_queue WHERE id = ? AND command_uuid = ?;`,
+		id, uuid,
+	)
+	if err != nil {
+		return err
+	}
+	// delete command result (i.e. NotNows) and this queued command
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM command_results WHERE id = ? AND command_uuid = ?;`,
+		id, uuid,
+	)
+	if err != nil {
+		return err
+	}
+	return deleteOrphanCommand(ctx, tx, uuid)
+}
+
+// deleteOrphanCommand deletes the command if no enrollments have it
+// queued nor are there any results for it.
+func deleteOrphanCommand(ctx context.Context, tx *sql.Tx, uuid string) error {
+	_, err := tx.ExecContext(
+		ctx, `
+DELETE FROM
+    commands
+WHERE
+    command_uuid = ? AND
+    NOT EXISTS (SELECT 1 FROM enrollment_queue AS q WHERE q.command_uuid = commands.command_uuid) AND
+    NOT EXISTS (SELECT 1 FROM command_results AS r WHERE r.command_uuid = commands.command_uuid);
+`,
+		uuid,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) deleteCommandTx(r *mdm.Request, result *mdm.CommandResults) error {
+	tx, err := s.db.BeginTx(r.Context, nil)
+	if err != nil {
+		return err
+	}
+	if err = s.deleteCommand(r.Context, tx, r.ID, result.CommandUUID); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) StoreCommandReport(r *mdm.Request, result *mdm.CommandResults) error {
+	if err := s.updateLastSeen(r); err != nil {
+		return err
+	}
+	if result.Status == "Idle" {
+		return nil
+	}
+	if s.rm && result.Status != "NotNow" {
+		return s.deleteCommandTx(r, result)
+	}
+	notNowConstants := "NULL, 0"
+	notNowBumpTallySQL := ""
+	// note that due to the ON CONFLICT we don't UPDATE the
+	// not_now_at field. thus it will only represent the first NotNow.
+	if result.Status == "NotNow" {
+		notNowConstants = "CURRENT_TIMESTAMP, 1"
+		notNowBumpTallySQL = `, not_now_tally = command_results.not_now_tally + 1`
+	}
+	_, err := s.db.ExecContext(
+		r.Context, `
+INSERT INTO command_results
+    (id, command_uuid, status, result, not_now_at, not_now_tally)
+VALUES
+    (?, ?, ?, ?, `+notNowConstants+`)
+ON CONFLICT (id, command_uuid) DO
+UPDATE SET
+    status = excluded.status,
+    result = excluded.result`+notNowBumpTallySQL+`;`,
+		r.ID,
+		result.CommandUUID,
+		result.Status,
+		string(result.Raw),
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	command := new(mdm.Command)
+	err := s.db.QueryRowContext(
+		r.Context, `
+SELECT c.command_uuid, c.request_type, c.command
+FROM enrollment_queue AS q
+    INNER JOIN commands AS c
+        ON q.command_uuid = c.command_uuid
+    LEFT JOIN command_results r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+WHERE q.id = ?
+    AND q.active = 1
+    AND (r.status IS NULL OR (r.status = 'NotNow' AND NOT ?))
+    AND (q.not_before IS NULL OR q.not_before <= CURRENT_TIMESTAMP)
+    AND (q.expires_at IS NULL OR q.expires_at > CURRENT_TIMESTAMP)
+ORDER BY
+    q.priority DESC,
+    q.created_at
+LIMIT 1;`,
+		r.ID, skipNotNow,
+	).Scan(&command.CommandUUID, &command.Command.RequestType, &command.Raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return command, nil
+}
+
+func (s *SQLiteStorage) ClearQueue(r *mdm.Request) error {
+	if r.ParentID != "" {
+		return errors.New("can only clear a device channel queue")
+	}
+	// Because we're joining on and WHERE-ing by the enrollments table
+	// this will clear (mark inactive) the queue of not only this
+	// device ID, but all user-channel enrollments with a 'parent' ID of
+	// this device, too.
+	_, err := s.db.ExecContext(
+		r.Context,
+		`
+UPDATE
+    enrollment_queue
+SET
+    active = 0
+WHERE
+    active = 1 AND
+    id IN (SELECT e.id FROM enrollments AS e WHERE e.device_id = ?) AND
+    NOT EXISTS (
+        SELECT 1 FROM command_results AS r
+        WHERE
+            r.command_uuid = enrollment_queue.command_uuid AND
+            r.id = enrollment_queue.id AND
+            r.status != 'NotNow'
+    );`,
+		r.ID,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrieveQueue(ctx context.Context, id string) ([]*storage.QueueItem, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    command_uuid, request_type, priority, active, status,
+    unixepoch(created_at), unixepoch(result_updated_at),
+    unixepoch(not_before), unixepoch(expires_at)
+FROM
+    view_queue
+WHERE
+    id = ?
+ORDER BY
+    priority DESC,
+    created_at;`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*storage.QueueItem
+	for rows.Next() {
+		var status sql.NullString
+		var createdAt int64
+		var resultUpdatedAt, notBefore, expires sql.NullInt64
+		item := new(storage.QueueItem)
+		if err := rows.Scan(
+			&item.CommandUUID, &item.RequestType, &item.Priority, &item.Active, &status,
+			&createdAt, &resultUpdatedAt, &notBefore, &expires,
+		); err != nil {
+			return nil, err
+		}
+		if notBefore.Valid {
+			t := time.Unix(notBefore.Int64, 0).UTC()
+			item.NotBefore = &t
+		}
+		if expires.Valid {
+			t := time.Unix(expires.Int64, 0).UTC()
+			item.Expires = &t
+		}
+		item.Status = status.String
+		item.CreatedAt = time.Unix(createdAt, 0).UTC()
+		if resultUpdatedAt.Valid {
+			updatedAt := time.Unix(resultUpdatedAt.Int64, 0).UTC()
+			item.ResultUpdatedAt = &updatedAt
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (s *SQLiteStorage) RetrieveCommandResults(ctx context.Context, uuid string) ([]*storage.CommandResult, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    q.id, r.status, r.not_now_tally, r.result, unixepoch(r.updated_at)
+FROM
+    enrollment_queue AS q
+    LEFT JOIN command_results AS r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+WHERE
+    q.command_uuid = ?
+ORDER BY
+    q.id;`,
+		uuid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []*storage.CommandResult
+	for rows.Next() {
+		var status sql.NullString
+		var notNowTally, updatedAt sql.NullInt64
+		result := new(storage.CommandResult)
+		if err := rows.Scan(&result.ID, &status, &notNowTally, &result.Result, &updatedAt); err != nil {
+			return nil, err
+		}
+		result.Status = status.String
+		result.NotNowTally = int(notNowTally.Int64)
+		if updatedAt.Valid {
+			t := time.Unix(updatedAt.Int64, 0).UTC()
+			result.UpdatedAt = &t
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func dequeue(ctx context.Context, tx *sql.Tx, ids []string, uuid string) (int, error) {
+	idsSQL := ""
+	args := []interface{}{uuid}
+	if len(ids) > 0 {
+		idsSQL = ` AND id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	// first delete any NotNow results so that those queued commands
+	// look outstanding, too.
+	_, err := tx.ExecContext(
+		ctx, `
+DELETE FROM
+    command_results
+WHERE
+    command_uuid = ? AND
+    status = 'NotNow' AND
+    EXISTS (
+        SELECT 1 FROM enrollment_queue AS q
+        WHERE q.command_uuid = command_results.command_uuid AND q.id = command_results.id
+    )`+idsSQL+`;`,
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	// then delete the outstanding queued commands.
+	res, err := tx.ExecContext(
+		ctx, `
+DELETE FROM
+    enrollment_queue
+WHERE
+    command_uuid = ? AND
+    NOT EXISTS (
+        SELECT 1 FROM command_results AS r
+        WHERE r.command_uuid = enrollment_queue.command_uuid AND r.id = enrollment_queue.id
+    )`+idsSQL+`;`,
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	ct, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(ct), deleteOrphanCommand(ctx, tx, uuid)
+}
+
+func (s *SQLiteStorage) DequeueCommand(ctx context.Context, ids []string, uuid string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	ct, err := dequeue(ctx, tx, ids, uuid)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return 0, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return 0, err
+	}
+	return ct, tx.Commit()
+}
+
+func (s *SQLiteStorage) RetrieveScheduledIDs(ctx context.Context, after, before time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT DISTINCT
+    q.id
+FROM
+    enrollment_queue AS q
+    LEFT JOIN command_results AS r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+WHERE
+    q.active = 1 AND
+    r.status IS NULL AND
+    q.not_before > datetime(?, 'unixepoch') AND
+    q.not_before <= datetime(?, 'unixepoch');`,
+		after.Unix(), before.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLiteStorage) RetrievePendingIDs(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT DISTINCT
+    q.id
+FROM
+    enrollment_queue AS q
+    INNER JOIN enrollments AS e
+        ON e.id = q.id
+    LEFT JOIN command_results AS r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+WHERE
+    e.enabled = 1 AND
+    q.active = 1 AND
+    r.status IS NULL AND
+    q.created_at <= datetime(?, 'unixepoch') AND
+    (q.not_before IS NULL OR q.not_before <= CURRENT_TIMESTAMP) AND
+    (q.expires_at IS NULL OR q.expires_at > CURRENT_TIMESTAMP);`,
+		before.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// expire finalizes the expired commands within tx.
+func (s *SQLiteStorage) expire(ctx context.Context, tx *sql.Tx) ([]*storage.ExpiredCommand, error) {
+	rows, err := tx.QueryContext(
+		ctx, `
+SELECT
+    q.id, q.command_uuid, c.request_type
+FROM
+    enrollment_queue AS q
+    INNER JOIN commands AS c
+        ON q.command_uuid = c.command_uuid
+    LEFT JOIN command_results AS r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+WHERE
+    q.active = 1 AND
+    (r.status IS NULL OR r.status = 'NotNow') AND
+    q.expires_at <= CURRENT_TIMESTAMP;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var expired []*storage.ExpiredCommand
+	for rows.Next() {
+		cmd := new(storage.ExpiredCommand)
+		if err := rows.Scan(&cmd.ID, &cmd.CommandUUID, &cmd.RequestType); err != nil {
+			return nil, err
+		}
+		expired = append(expired, cmd)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for _, cmd := range expired {
+		if s.rm {
+			err = s.deleteCommand(ctx, tx, cmd.ID, cmd.CommandUUID)
+		} else {
+			err = storeSyntheticResult(ctx, tx, cmd.ID, cmd.CommandUUID, storage.StatusExpired)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+// storeSyntheticResult stores a synthetic result with status for the
+// queued command.
+func storeSyntheticResult(ctx context.Context, tx *sql.Tx, id, uuid, status string) error {
+	result, err := storage.NewSyntheticResult(uuid, status)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(
+		ctx, `
+INSERT INTO command_results
+    (id, command_uuid, status, result, not_now_at, not_now_tally)
+VALUES
+    (?, ?, ?, ?, NULL, 0)
+ON CONFLICT (id, command_uuid) DO
+UPDATE SET
+    status = excluded.status,
+    result = excluded.result;`,
+		id, uuid, status, string(result),
+	)
+	return err
+}
+
+func (s *SQLiteStorage) ExpireCommands(ctx context.Context) ([]*storage.ExpiredCommand, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	expired, err := s.expire(ctx, tx)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return nil, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return nil, err
+	}
+	return expired, tx.Commit()
+}
+
+func (s *SQLiteStorage) RetrieveNotNow(ctx context.Context, id, uuid string) (int, time.Time, error) {
+	var tally int
+	var notNowAt sql.NullInt64
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT not_now_tally, unixepoch(not_now_at) FROM command_results WHERE id = ? AND command_uuid = ?;`,
+		id, uuid,
+	).Scan(&tally, &notNowAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, nil
+	} else if err != nil {
+		return 0, time.Time{}, err
+	}
+	var first time.Time
+	if notNowAt.Valid {
+		first = time.Unix(notNowAt.Int64, 0).UTC()
+	}
+	return tally, first, nil
+}
+
+func deadLetter(ctx context.Context, tx *sql.Tx, id, uuid string) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE enrollment_queue SET active = 0 WHERE id = ? AND command_uuid = ?;`,
+		id, uuid,
+	)
+	if err != nil {
+		return err
+	}
+	return storeSyntheticResult(ctx, tx, id, uuid, storage.StatusDeadLettered)
+}
+
+func (s *SQLiteStorage) DeadLetterCommand(ctx context.Context, id, uuid string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = deadLetter(ctx, tx, id, uuid); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) RetrieveDeadLetters(ctx context.Context, ids []string) ([]*storage.DeadLetter, error) {
+	idsSQL := ""
+	args := []interface{}{storage.StatusDeadLettered}
+	if len(ids) > 0 {
+		idsSQL = ` AND r.id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    r.id, r.command_uuid, c.request_type, r.not_now_tally,
+    unixepoch(r.not_now_at), unixepoch(r.updated_at)
+FROM
+    command_results AS r
+    INNER JOIN commands AS c
+        ON c.command_uuid = r.command_uuid
+WHERE
+    r.status = ?`+idsSQL+`
+ORDER BY
+    r.id,
+    r.updated_at;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deadLetters []*storage.DeadLetter
+	for rows.Next() {
+		var notNowAt sql.NullInt64
+		var updatedAt int64
+		dl := new(storage.DeadLetter)
+		if err := rows.Scan(
+			&dl.ID, &dl.CommandUUID, &dl.RequestType, &dl.NotNowTally,
+			&notNowAt, &updatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if notNowAt.Valid {
+			t := time.Unix(notNowAt.Int64, 0).UTC()
+			dl.NotNowAt = &t
+		}
+		dl.DeadLetteredAt = time.Unix(updatedAt, 0).UTC()
+		deadLetters = append(deadLetters, dl)
+	}
+	return deadLetters, rows.Err()
+}
@@ -0,0 +1,435 @@
+/* Requires SQLite 3.38 or later for unixepoch(). The schema is
+ * applied when the SQLite storage is opened and so every statement
+ * must be idempotent.
+ */
+
+CREATE TABLE IF NOT EXISTS devices (
+    id VARCHAR(255) NOT NULL,
+
+    identity_cert TEXT NULL,
+
+    serial_number VARCHAR(127) NULL,
+
+    -- If the (iOS, iPadOS) device sent an UnlockToken in the TokenUpdate
+    unlock_token    BLOB      NULL,
+    unlock_token_at TIMESTAMP NULL,
+
+    -- The last raw Authenticate for this device
+    authenticate    TEXT      NOT NULL,
+    authenticate_at TIMESTAMP NOT NULL,
+    -- The last raw TokenUpdate for this device
+    token_update    TEXT      NULL,
+    token_update_at TIMESTAMP NULL,
+
+    bootstrap_token_b64 TEXT      NULL,
+    bootstrap_token_at  TIMESTAMP NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (id),
+
+    CHECK (identity_cert IS NULL OR
+        SUBSTR(identity_cert, 1, 27) = '-----BEGIN CERTIFICATE-----'),
+
+    CHECK (serial_number IS NULL OR serial_number != ''),
+
+    CHECK (unlock_token IS NULL OR LENGTH(unlock_token) > 0),
+
+    CHECK (authenticate != ''),
+    CHECK (token_update IS NULL OR token_update != ''),
+
+    CHECK (bootstrap_token_b64 IS NULL OR bootstrap_token_b64 != '')
+);
+CREATE INDEX IF NOT EXISTS idx_serial_number ON devices (serial_number);
+
+
+CREATE TABLE IF NOT EXISTS users (
+    id        VARCHAR(255) NOT NULL,
+    device_id VARCHAR(255) NOT NULL,
+
+    user_short_name VARCHAR(255) NULL,
+    user_long_name  VARCHAR(255) NULL,
+
+    -- The last raw TokenUpdate for this user
+    token_update    TEXT      NULL,
+    token_update_at TIMESTAMP NULL,
+
+    -- The last raw UserAuthenticate (and optional digest) for this user
+    user_authenticate           TEXT      NULL,
+    user_authenticate_at        TIMESTAMP NULL,
+    user_authenticate_digest    TEXT      NULL,
+    user_authenticate_digest_at TIMESTAMP NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (id, device_id),
+    UNIQUE (id),
+
+    FOREIGN KEY (device_id)
+        REFERENCES devices (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (user_short_name IS NULL OR user_short_name != ''),
+    CHECK (user_long_name  IS NULL OR user_long_name  != ''),
+
+    CHECK (token_update IS NULL OR token_update != ''),
+
+    CHECK (user_authenticate        IS NULL OR user_authenticate        != ''),
+    CHECK (user_authenticate_digest IS NULL OR user_authenticate_digest != '')
+);
+
+
+/* This table represents enrollments which are an amalgamation of
+ * both device and user enrollments.
+ */
+CREATE TABLE IF NOT EXISTS enrollments (
+    -- The enrollment ID of this enrollment
+    id        VARCHAR(255) NOT NULL,
+    -- The "device" enrollment ID of this enrollment. This will be
+    -- the same as the `id` field in the case of a "device" enrollment,
+    -- or will be the "parent" enrollment for a "user" enrollment.
+    device_id VARCHAR(255) NOT NULL,
+    -- The "user" enrollment ID of this enrollment. This will be the
+    -- same as the `id` field in the case of a "user" enrollment or
+    -- NULL in the case of a device enrollment.
+    user_id   VARCHAR(255) NULL,
+
+    -- Textual representation of the type of device enrollment.
+    type      VARCHAR(31) NOT NULL,
+
+    -- The MDM APNs push trifecta.
+    topic      VARCHAR(255) NOT NULL,
+    push_magic VARCHAR(127) NOT NULL,
+    token_hex  VARCHAR(255) NOT NULL,
+
+    enabled            BOOLEAN NOT NULL DEFAULT 1,
+    token_update_tally INTEGER NOT NULL DEFAULT 1,
+
+    last_seen_at TIMESTAMP NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (id),
+    CHECK (id != ''),
+
+    FOREIGN KEY (device_id)
+        REFERENCES devices (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+    UNIQUE (user_id),
+
+    CHECK (type != ''),
+
+    CHECK (topic != ''),
+    CHECK (push_magic != ''),
+    CHECK (token_hex != '')
+);
+CREATE INDEX IF NOT EXISTS idx_type ON enrollments (type);
+CREATE INDEX IF NOT EXISTS idx_device_id ON enrollments (device_id);
+
+
+/* Commands stand alone. By themselves they aren't associated with
+ * a device, a result (response), etc. Joining other tables is required
+ * for more context.
+ */
+CREATE TABLE IF NOT EXISTS commands (
+    command_uuid VARCHAR(127) NOT NULL,
+    request_type VARCHAR(63)  NOT NULL,
+    -- Raw command Plist
+    command      TEXT         NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (command_uuid),
+
+    CHECK (command_uuid != ''),
+    CHECK (request_type != ''),
+    CHECK (SUBSTR(command, 1, 5) = '<?xml')
+);
+
+
+/* Results are enrollment responses to device commands. See the MySQL
+ * schema for the choice of primary key.
+ */
+CREATE TABLE IF NOT EXISTS command_results (
+    id           VARCHAR(255) NOT NULL,
+    command_uuid VARCHAR(127) NOT NULL,
+    status       VARCHAR(31)  NOT NULL,
+    result       TEXT         NOT NULL,
+
+    not_now_at    TIMESTAMP NULL,
+    not_now_tally INTEGER   NOT NULL DEFAULT 0,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (id, command_uuid),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    FOREIGN KEY (command_uuid)
+        REFERENCES commands (command_uuid)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (status != ''),
+    CHECK (SUBSTR(result, 1, 5) = '<?xml')
+);
+CREATE INDEX IF NOT EXISTS idx_status ON command_results (status);
+CREATE INDEX IF NOT EXISTS idx_results_command_uuid ON command_results (command_uuid);
+
+
+CREATE TABLE IF NOT EXISTS enrollment_queue (
+    id           VARCHAR(255) NOT NULL,
+    command_uuid VARCHAR(127) NOT NULL,
+
+    active   BOOLEAN NOT NULL DEFAULT 1,
+    priority INTEGER NOT NULL DEFAULT 0,
+
+    -- commands are not delivered before this time
+    not_before TIMESTAMP NULL,
+    -- commands are not delivered after this time
+    expires_at TIMESTAMP NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (id, command_uuid),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    FOREIGN KEY (command_uuid)
+        REFERENCES commands (command_uuid)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_priority ON enrollment_queue (priority DESC, created_at);
+CREATE INDEX IF NOT EXISTS idx_not_before ON enrollment_queue (not_before);
+CREATE INDEX IF NOT EXISTS idx_expires_at ON enrollment_queue (expires_at);
+CREATE INDEX IF NOT EXISTS idx_queue_command_uuid ON enrollment_queue (command_uuid);
+
+/* An enrollment's queue is a view into commands, enrollment queued
+ * commands, and any results received. Outstanding queue items (i.e.
+ * those that have received no result yet) will have a status of NULL
+ * (due to the LEFT JOIN against results).
+ */
+CREATE VIEW IF NOT EXISTS view_queue AS
+SELECT
+    q.id,
+    q.created_at,
+    q.active,
+    q.priority,
+    q.not_before,
+    q.expires_at,
+    c.command_uuid,
+    c.request_type,
+    c.command,
+    r.updated_at AS result_updated_at,
+    r.status,
+    r.result
+FROM
+    enrollment_queue AS q
+
+        INNER JOIN commands AS c
+        ON q.command_uuid = c.command_uuid
+
+        LEFT JOIN command_results r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+ORDER BY
+    q.priority DESC,
+    q.created_at;
+
+
+CREATE TABLE IF NOT EXISTS push_certs (
+    topic VARCHAR(255) NOT NULL,
+
+    cert_pem TEXT NOT NULL,
+    key_pem  TEXT NOT NULL,
+
+    /* stale_token is a simple value that coordinates push certificates
+     * across the SQL backend. The push service checks this value
+     * every time push info is requested. This value should be updated
+     * every time a push cert is updated (i.e. renewals) and so all
+     * push services using this table will know the certificate has
+     * changed and reload it. This is managed by the SQLite backend. */
+    stale_token INTEGER NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (topic),
+    CHECK (topic != ''),
+
+    CHECK (SUBSTR(cert_pem, 1, 27) = '-----BEGIN CERTIFICATE-----'),
+    CHECK (SUBSTR(key_pem,  1,  5) = '-----')
+);
+
+
+CREATE TABLE IF NOT EXISTS cert_auth_associations (
+    id     VARCHAR(255) NOT NULL,
+    sha256 CHAR(64)     NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (id, sha256),
+
+    CHECK (id != ''),
+    CHECK (sha256 != '')
+);
+CREATE INDEX IF NOT EXISTS idx_sha256 ON cert_auth_associations (sha256);
+
+
+CREATE TABLE IF NOT EXISTS inventory (
+    id    VARCHAR(255) NOT NULL,
+    name  VARCHAR(127) NOT NULL,
+    value TEXT         NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (id, name),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (name != '')
+);
+
+
+CREATE TABLE IF NOT EXISTS push_retries (
+    id VARCHAR(255) NOT NULL,
+
+    attempts   INTEGER   NOT NULL DEFAULT 0,
+    next_at    TIMESTAMP NOT NULL,
+    last_error TEXT      NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_next_at ON push_retries (next_at);
+
+
+CREATE TABLE IF NOT EXISTS bad_push_tokens (
+    id VARCHAR(255) NOT NULL,
+
+    token_hex VARCHAR(255) NOT NULL,
+    reason    VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (token_hex != '')
+);
+
+
+CREATE TABLE IF NOT EXISTS push_history (
+    history_id INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    id         VARCHAR(255) NOT NULL,
+
+    pushed_at TIMESTAMP    NOT NULL,
+    apns_id   VARCHAR(255) NULL,
+    topic     VARCHAR(255) NOT NULL,
+    error     TEXT         NULL,
+    reason    VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_push_history_id ON push_history (id, history_id);
+
+
+/* SQLite has no ON UPDATE CURRENT_TIMESTAMP so these triggers keep
+ * updated_at current like the MySQL schema. The WHEN clause skips
+ * updates that set updated_at themselves (including these triggers).
+ */
+CREATE TRIGGER IF NOT EXISTS devices_updated_at AFTER UPDATE ON devices
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE devices SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_updated_at AFTER UPDATE ON users
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS enrollments_updated_at AFTER UPDATE ON enrollments
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE enrollments SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS commands_updated_at AFTER UPDATE ON commands
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE commands SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS command_results_updated_at AFTER UPDATE ON command_results
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE command_results SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS enrollment_queue_updated_at AFTER UPDATE ON enrollment_queue
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE enrollment_queue SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS push_certs_updated_at AFTER UPDATE ON push_certs
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE push_certs SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS cert_auth_associations_updated_at AFTER UPDATE ON cert_auth_associations
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE cert_auth_associations SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS inventory_updated_at AFTER UPDATE ON inventory
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE inventory SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS push_retries_updated_at AFTER UPDATE ON push_retries
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE push_retries SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS bad_push_tokens_updated_at AFTER UPDATE ON bad_push_tokens
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE bad_push_tokens SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
@@ -0,0 +1,316 @@
+// Package sqlite stores and retrieves MDM data from an embedded SQLite database.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/mdm"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+	_ "modernc.org/sqlite"
+)
+
+// Schema holds the schema for the NanoMDM SQLite storage.
+// It is applied when the storage is created.
+//
+//go:embed schema.sql
+var Schema string
+
+// pragmas enable foreign keys and wait for locks on every connection
+// the default driver opens. They're set in the DSN rather than with
+// PRAGMA statements as database/sql may replace a connection (e.g.
+// after driver.ErrBadConn) and a new connection starts with defaults.
+const pragmas = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+
+type SQLiteStorage struct {
+	logger log.Logger
+	db     *sql.DB
+	rm     bool
+}
+
+type config struct {
+	driver string
+	dsn    string
+	db     *sql.DB
+	logger log.Logger
+	rm     bool
+}
+
+type Option func(*config)
+
+func WithLogger(logger log.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithDSN sets the data source name. For the default driver this is
+// a file path or a file: URI.
+func WithDSN(dsn string) Option {
+	return func(c *config) {
+		c.dsn = dsn
+	}
+}
+
+// WithDriver sets the database/sql driver name. The pragmas are only
+// added to the DSN for the default driver so for other drivers the
+// DSN must enable foreign keys itself.
+func WithDriver(driver string) Option {
+	return func(c *config) {
+		c.driver = driver
+	}
+}
+
+// WithDB uses db rather than opening the database. Every connection
+// of db must enable foreign keys (e.g. with "_pragma=foreign_keys(1)"
+// in the DSN of the default driver). SQLite only supports a single
+// writer so db should be limited to one open connection with
+// SetMaxOpenConns, which in-memory databases also require.
+func WithDB(db *sql.DB) Option {
+	return func(c *config) {
+		c.db = db
+	}
+}
+
+func WithDeleteCommands() Option {
+	return func(c *config) {
+		c.rm = true
+	}
+}
+
+// New opens the SQLite database and applies the schema.
+// SQLite only supports a single writer so a database opened by New is
+// limited to one open connection. This serializes access within
+// NanoMDM and allows for in-memory databases.
+func New(opts ...Option) (*SQLiteStorage, error) {
+	cfg := &config{logger: log.NopLogger, driver: "sqlite"}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	var err error
+	if cfg.db == nil {
+		if cfg.dsn == "" {
+			return nil, errors.New("no DSN provided")
+		}
+		dsn := cfg.dsn
+		if cfg.driver == "sqlite" {
+			dsn = withPragmas(dsn)
+		}
+		cfg.db, err = sql.Open(cfg.driver, dsn)
+		if err != nil {
+			return nil, err
+		}
+		cfg.db.SetMaxOpenConns(1)
+	}
+	if _, err = cfg.db.Exec(Schema); err != nil {
+		return nil, fmt.Errorf("applying schema: %w", err)
+	}
+	return &SQLiteStorage{db: cfg.db, logger: cfg.logger, rm: cfg.rm}, nil
+}
+
+// withPragmas adds the pragmas to the query of dsn.
+func withPragmas(dsn string) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + pragmas
+	}
+	return dsn + "?" + pragmas
+}
+
+// nullEmptyString returns a NULL string if s is empty.
+func nullEmptyString(s string) sql.NullString {
+	return sql.NullString{
+		String: s,
+		Valid:  s != "",
+	}
+}
+
+func (s *SQLiteStorage) StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate) error {
+	var pemCert []byte
+	if r.Certificate != nil {
+		pemCert = cryptoutil.PEMCertificate(r.Certificate.Raw)
+	}
+	// raw plist and PEM values are stored as strings rather than
+	// bytes. SQLite never considers BLOBs equal to TEXT which would
+	// fail the schema CHECKs.
+	_, err := s.db.ExecContext(
+		r.Context, `
+INSERT INTO devices
+    (id, identity_cert, serial_number, authenticate, authenticate_at)
+VALUES
+    (?, ?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT (id) DO
+UPDATE SET
+    identity_cert = excluded.identity_cert,
+    serial_number = excluded.serial_number,
+    authenticate = excluded.authenticate,
+    authenticate_at = CURRENT_TIMESTAMP;`,
+		r.ID, nullEmptyString(string(pemCert)), nullEmptyString(msg.SerialNumber), string(msg.Raw),
+	)
+	return err
+}
+
+func (s *SQLiteStorage) storeDeviceTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	query := `UPDATE devices SET token_update = ?, token_update_at = CURRENT_TIMESTAMP`
+	args := []interface{}{string(msg.Raw)}
+	// separately store the Unlock Token per MDM spec
+	if len(msg.UnlockToken) > 0 {
+		query += `, unlock_token = ?, unlock_token_at = CURRENT_TIMESTAMP`
+		args = append(args, msg.UnlockToken)
+	}
+	query += ` WHERE id = ?;`
+	args = append(args, r.ID)
+	_, err := s.db.ExecContext(r.Context, query, args...)
+	return err
+}
+
+func (s *SQLiteStorage) storeUserTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	// there shouldn't be an Unlock Token on the user channel, but
+	// complain if there is to warn an admin
+	if len(msg.UnlockToken) > 0 {
+		ctxlog.Logger(r.Context, s.logger).Info(
+			"msg", "Unlock Token on user channel not stored",
+		)
+	}
+	_, err := s.db.ExecContext(
+		r.Context, `
+INSERT INTO users
+    (id, device_id, user_short_name, user_long_name, token_update, token_update_at)
+VALUES
+    (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT (id) DO
+UPDATE SET
+    device_id = excluded.device_id,
+    user_short_name = excluded.user_short_name,
+    user_long_name = excluded.user_long_name,
+    token_update = excluded.token_update,
+    token_update_at = CURRENT_TIMESTAMP;`,
+		r.ID,
+		r.ParentID,
+		nullEmptyString(msg.UserShortName),
+		nullEmptyString(msg.UserLongName),
+		string(msg.Raw),
+	)
+	return err
+}
+
+func (s *SQLiteStorage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	var err error
+	var deviceId, userId string
+	resolved := (&msg.Enrollment).Resolved()
+	if err = resolved.Validate(); err != nil {
+		return err
+	}
+	if resolved.IsUserChannel {
+		deviceId = r.ParentID
+		userId = r.ID
+		err = s.storeUserTokenUpdate(r, msg)
+	} else {
+		deviceId = r.ID
+		err = s.storeDeviceTokenUpdate(r, msg)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(
+		r.Context, `
+INSERT INTO enrollments
+    (id, device_id, user_id, type, topic, push_magic, token_hex, last_seen_at, token_update_tally)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, 1)
+ON CONFLICT (id) DO
+UPDATE SET
+    device_id = excluded.device_id,
+    user_id = excluded.user_id,
+    type = excluded.type,
+    topic = excluded.topic,
+    push_magic = excluded.push_magic,
+    token_hex = excluded.token_hex,
+    enabled = 1,
+    last_seen_at = CURRENT_TIMESTAMP,
+    token_update_tally = enrollments.token_update_tally + 1;`,
+		r.ID,
+		deviceId,
+		nullEmptyString(userId),
+		r.Type.String(),
+		msg.Topic,
+		msg.PushMagic,
+		msg.Token.String(),
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrieveTokenUpdateTally(ctx context.Context, id string) (int, error) {
+	var tally int
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT token_update_tally FROM enrollments WHERE id = ?;`,
+		id,
+	).Scan(&tally)
+	return tally, err
+}
+
+func (s *SQLiteStorage) StoreUserAuthenticate(r *mdm.Request, msg *mdm.UserAuthenticate) error {
+	colName := "user_authenticate"
+	colAtName := "user_authenticate_at"
+	// if the DigestResponse is empty then this is the first (of two)
+	// UserAuthenticate messages depending on our response
+	if msg.DigestResponse != "" {
+		colName = "user_authenticate_digest"
+		colAtName = "user_authenticate_digest_at"
+	}
+	_, err := s.db.ExecContext(
+		r.Context, `
+INSERT INTO users
+    (id, device_id, user_short_name, user_long_name, `+colName+`, `+colAtName+`)
+VALUES
+    (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT (id) DO
+UPDATE SET
+    device_id = excluded.device_id,
+    user_short_name = excluded.user_short_name,
+    user_long_name = excluded.user_long_name,
+    `+colName+` = excluded.`+colName+`,
+    `+colAtName+` = excluded.`+colAtName+`;`,
+		r.ID,
+		r.ParentID,
+		nullEmptyString(msg.UserShortName),
+		nullEmptyString(msg.UserLongName),
+		string(msg.Raw),
+	)
+	if err != nil {
+		return err
+	}
+	return s.updateLastSeen(r)
+}
+
+// Disable can be called for an Authenticate or CheckOut message
+func (s *SQLiteStorage) Disable(r *mdm.Request) error {
+	if r.ParentID != "" {
+		return errors.New("can only disable a device channel")
+	}
+	_, err := s.db.ExecContext(
+		r.Context,
+		`UPDATE enrollments SET enabled = 0, token_update_tally = 0, last_seen_at = CURRENT_TIMESTAMP WHERE device_id = ? AND enabled = 1;`,
+		r.ID,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) updateLastSeen(r *mdm.Request) (err error) {
+	_, err = s.db.ExecContext(
+		r.Context,
+		`UPDATE enrollments SET last_seen_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		r.ID,
+	)
+	if err != nil {
+		err = fmt.Errorf("updating last seen: %w", err)
+	}
+	return
+}
@@ -0,0 +1,205 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql/driver"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push/apnstest"
+	"github.com/micromdm/nanomdm/storage/test"
+)
+
+// newTestStorage creates storage with a new database. The storage
+// tests share command UUIDs so each needs its own database.
+func newTestStorage(t *testing.T, opts ...Option) *SQLiteStorage {
+	opts = append(opts, WithDSN(filepath.Join(t.TempDir(), "nanomdm.db")))
+	s, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+// enrolled creates storage with a new database and enrolls ids.
+// Enrollments must exist to satisfy the foreign keys.
+func enrolled(t *testing.T, ids ...string) *SQLiteStorage {
+	s := newTestStorage(t)
+	for _, id := range ids {
+		test.EnrollDevice(t, s, context.Background(), id)
+	}
+	return s
+}
+
+func TestQueue(t *testing.T) {
+	const id = "EA4E19F1-7F8B-493D-BEAB-264B33BCF4E6"
+
+	s := newTestStorage(t, WithDeleteCommands())
+	test.EnrollDevice(t, s, context.Background(), id)
+	t.Run("WithDeleteCommands()", func(t *testing.T) {
+		test.TestQueue(t, id, s)
+	})
+
+	t.Run("normal", func(t *testing.T) {
+		test.TestQueue(t, id, enrolled(t, id))
+	})
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	ctx := context.Background()
+
+	test.TestRetrievePushInfo(t, ctx, newTestStorage(t))
+
+	id := "2BD3F5D4-1F0A-4F5E-9A39-3C7B1B6D4E11"
+	test.TestRetrieveQueue(t, id, enrolled(t, id))
+	id = "7C1E2A5B-93D4-4B8F-A2E6-0F5D8C3B9A47"
+	test.TestRetrieveCommandResults(t, id, enrolled(t, id))
+	id = "D4A1C7E2-5B3F-4E9A-8C6D-1F2B3A4C5D6E"
+	test.TestDequeueCommand(t, id, enrolled(t, id))
+	id = "5E8B2C1A-7D4F-4A3B-9E6C-2B1D0F8A7C53"
+	test.TestScheduledCommands(t, id, enrolled(t, id))
+	id = "B2D4F6A8-0C1E-4A3B-8D5F-7E9A1C3B5D46"
+	test.TestPendingCommands(t, id, enrolled(t, id))
+	id = "9A3D6F1B-2C8E-4B7A-A5D4-6E0C1F9B8D72"
+	test.TestExpireCommands(t, id, enrolled(t, id))
+	id = "3F7C9E2D-8B1A-4D6E-B2C5-7A0E4F1D9C36"
+	test.TestDeadLetters(t, id, enrolled(t, id))
+	id = "6B2E8D4F-1A9C-4E7B-8D3F-5C0A2E6B9F14"
+	test.TestInventory(t, id, enrolled(t, id))
+	id = "5D9B3F1A-7E2C-4A8D-9B6F-1C3E5A7D9B20"
+	test.TestBadPushTokens(t, id, enrolled(t, id))
+
+	id1, id2 := "8C4A1E7D-3F2B-4D9A-B6E5-0A7C9D2F1B48", "E1F3B5D7-9A2C-4E6B-8D0F-2A4C6E8B0D13"
+	test.TestPushRetries(t, id1, id2, enrolled(t, id1, id2))
+	id1, id2 = "A7E1C3F5-2B4D-4F6A-8C0E-3D5F7B9A1C24", "4F8B2D6E-0C3A-4E1F-9A7D-6B2C8E4F0A35"
+	test.TestPushHistory(t, id1, id2, enrolled(t, id1, id2))
+
+	test.TestPushCerts(t, newTestStorage(t))
+	test.TestEnrollmentLister(t, ctx, newTestStorage(t))
+}
+
+func TestPragmas(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	// discard the open connection as database/sql would after
+	// driver.ErrBadConn so that the next query opens a new one.
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	if err != driver.ErrBadConn {
+		t.Fatalf("have: %v, want: %v", err, driver.ErrBadConn)
+	}
+	conn.Close()
+
+	for _, pragma := range []struct {
+		name string
+		want int
+	}{
+		{"foreign_keys", 1},
+		{"busy_timeout", 5000},
+	} {
+		var have int
+		if err = s.db.QueryRowContext(ctx, "PRAGMA "+pragma.name+";").Scan(&have); err != nil {
+			t.Fatal(err)
+		}
+		if have != pragma.want {
+			t.Errorf("%s: have: %d, want: %d", pragma.name, have, pragma.want)
+		}
+	}
+}
+
+func TestCheckins(t *testing.T) {
+	ctx := context.Background()
+	const udid = "C3E5A7B9-1D2F-4A6C-8E0B-2D4F6A8C0E17"
+
+	s, err := New(WithDSN(":memory:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+
+	r := test.EnrollDevice(t, s, ctx, udid)
+	test.EnrollDevice(t, s, ctx, udid)
+	if tally, err := s.RetrieveTokenUpdateTally(ctx, udid); err != nil {
+		t.Fatal(err)
+	} else if tally != 2 {
+		t.Errorf("tally: have: %d, want: %d", tally, 2)
+	}
+
+	t.Run("bootstrap token", func(t *testing.T) {
+		msg := new(mdm.SetBootstrapToken)
+		if err := msg.BootstrapToken.SetTokenString("Qk9PVFNUUkFQ"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.StoreBootstrapToken(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		token, err := s.RetrieveBootstrapToken(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := token.BootstrapToken.String(), "Qk9PVFNUUkFQ"; have != want {
+			t.Errorf("bootstrap token: have: %q, want: %q", have, want)
+		}
+	})
+
+	t.Run("cert auth", func(t *testing.T) {
+		const hash = "ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789"
+		for i := 0; i < 2; i++ {
+			if err := s.AssociateCertHash(r, hash); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ok, err := s.IsCertHashAssociated(r, hash); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Error("cert hash not associated")
+		}
+		if id, err := s.EnrollmentFromHash(ctx, strings.ToLower(hash)); err != nil {
+			t.Fatal(err)
+		} else if id != udid {
+			t.Errorf("id: have: %q, want: %q", id, udid)
+		}
+	})
+
+	t.Run("push cert stale token", func(t *testing.T) {
+		const topic = "com.apple.mgmt.External.5e1a5c5e-0000-4000-8000-000000000001"
+		certPEM, keyPEM := apnstest.NewPushCert(t, topic, 1, time.Now().Add(time.Hour))
+		if err := s.StorePushCert(ctx, certPEM, keyPEM); err != nil {
+			t.Fatal(err)
+		}
+		_, staleToken, err := s.RetrievePushCert(ctx, topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stale, err := s.IsPushCertStale(ctx, topic, staleToken); err != nil {
+			t.Fatal(err)
+		} else if stale {
+			t.Error("push cert stale before renewal")
+		}
+		if err := s.StorePushCert(ctx, certPEM, keyPEM); err != nil {
+			t.Fatal(err)
+		}
+		if stale, err := s.IsPushCertStale(ctx, topic, staleToken); err != nil {
+			t.Fatal(err)
+		} else if !stale {
+			t.Error("push cert not stale after renewal")
+		}
+	})
+
+	if err := s.Disable(r); err != nil {
+		t.Fatal(err)
+	}
+	if tally, err := s.RetrieveTokenUpdateTally(ctx, udid); err != nil {
+		t.Fatal(err)
+	} else if tally != 0 {
+		t.Errorf("tally: have: %d, want: %d", tally, 0)
+	}
+}
@@ -64,7 +64,17 @@ type PushCertStore interface {
 
 // CommandEnqueuer is able to enqueue MDM commands.
 type CommandEnqueuer interface {
-	EnqueueCommand(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error)
+	EnqueueCommand(ctx context.Context, id []string, cmd *mdm.Command, opts ...EnqueueOption) (map[string]error, error)
+}
+
+// CommandDequeuer is able to remove queued MDM commands.
+type CommandDequeuer interface {
+	// DequeueCommand removes command uuid from the queues of enrollment
+	// ids or from the queues of all enrollments if ids is empty. Only
+	// commands that have not yet completed (i.e. those outstanding or
+	// that received a NotNow) are removed. The number of enrollments
+	// the command was removed from is returned.
+	DequeueCommand(ctx context.Context, ids []string, uuid string) (int, error)
 }
 
 // CertAuthStore stores and retrieves cert-to-enrollment associations.
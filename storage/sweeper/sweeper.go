@@ -0,0 +1,118 @@
+// Package sweeper periodically expires queued MDM commands.
+package sweeper
+
+import (
+	"context"
+	"time"
+
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// DefaultInterval is the default interval between sweeps.
+const DefaultInterval = 5 * time.Minute
+
+// TopicCommandExpired is the event topic for expired commands.
+const TopicCommandExpired = "nanomdm.CommandExpired"
+
+// Sweeper periodically expires queued commands whose expiry has passed.
+type Sweeper struct {
+	store    storage.CommandExpirer
+	notifier service.EventNotifier
+	logger   log.Logger
+	interval time.Duration
+}
+
+type Option func(*Sweeper)
+
+// WithLogger sets the logger.
+func WithLogger(logger log.Logger) Option {
+	return func(s *Sweeper) {
+		s.logger = logger
+	}
+}
+
+// WithInterval sets the interval between sweeps.
+func WithInterval(interval time.Duration) Option {
+	return func(s *Sweeper) {
+		s.interval = interval
+	}
+}
+
+// WithNotifier sends an event to notifier for each expired command.
+func WithNotifier(notifier service.EventNotifier) Option {
+	return func(s *Sweeper) {
+		s.notifier = notifier
+	}
+}
+
+// New creates a new Sweeper.
+func New(store storage.CommandExpirer, opts ...Option) *Sweeper {
+	s := &Sweeper{
+		store:    store,
+		logger:   log.NopLogger,
+		interval: DefaultInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run sweeps every interval until ctx is done.
+func (s *Sweeper) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := s.Sweep(ctx); err != nil {
+				ctxlog.Logger(ctx, s.logger).Info(
+					"msg", "sweep expired commands",
+					"err", err,
+				)
+			}
+		}
+	}
+}
+
+// Sweep expires queued commands whose expiry has passed. Each expired
+// command is logged and, if configured, sent to the notifier.
+func (s *Sweeper) Sweep(ctx context.Context) error {
+	expired, err := s.store.ExpireCommands(ctx)
+	if err != nil {
+		return err
+	}
+	logger := ctxlog.Logger(ctx, s.logger)
+	for _, cmd := range expired {
+		logger.Info(
+			"msg", "command expired",
+			"id", cmd.ID,
+			"command_uuid", cmd.CommandUUID,
+			"request_type", cmd.RequestType,
+		)
+		if s.notifier == nil {
+			continue
+		}
+		err = s.notifier.NotifyEvent(ctx, &service.Event{
+			Topic:       TopicCommandExpired,
+			ID:          cmd.ID,
+			CommandUUID: cmd.CommandUUID,
+			Status:      storage.StatusExpired,
+		})
+		if err != nil {
+			logger.Info(
+				"msg", "notify expired command",
+				"id", cmd.ID,
+				"command_uuid", cmd.CommandUUID,
+				"err", err,
+			)
+		}
+	}
+	return nil
+}
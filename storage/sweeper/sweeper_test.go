@@ -0,0 +1,61 @@
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+type testStore struct {
+	expired []*storage.ExpiredCommand
+	err     error
+}
+
+func (s *testStore) ExpireCommands(_ context.Context) ([]*storage.ExpiredCommand, error) {
+	return s.expired, s.err
+}
+
+type testNotifier struct {
+	events []*service.Event
+	err    error
+}
+
+func (n *testNotifier) NotifyEvent(_ context.Context, ev *service.Event) error {
+	n.events = append(n.events, ev)
+	return n.err
+}
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	store := &testStore{expired: []*storage.ExpiredCommand{
+		{ID: "ID1", CommandUUID: "CMD1", RequestType: "DeviceInformation"},
+		{ID: "ID2", CommandUUID: "CMD1", RequestType: "DeviceInformation"},
+	}}
+	notifier := new(testNotifier)
+	s := New(store, WithNotifier(notifier))
+
+	if err := s.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(notifier.events), 2; have != want {
+		t.Fatalf("events: have: %v, want: %v", have, want)
+	}
+	ev := notifier.events[1]
+	if ev.Topic != TopicCommandExpired || ev.ID != "ID2" || ev.CommandUUID != "CMD1" || ev.Status != storage.StatusExpired {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	// notifier errors do not fail the sweep
+	notifier.err = errors.New("test error")
+	if err := s.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	store.err = errors.New("test error")
+	if err := s.Sweep(ctx); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,49 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestBadPushTokens tests storing and retrieving the bad push token of
+// the enrollment ID id.
+func TestBadPushTokens(t *testing.T, id string, s storage.BadPushTokenStore) {
+	ctx := context.Background()
+
+	tokens, err := s.RetrieveBadPushTokens(ctx, []string{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(tokens), 0; have != want {
+		t.Errorf("bad push tokens: have: %d, want: %d", have, want)
+	}
+
+	for _, token := range []*storage.BadPushToken{
+		{ID: id, Token: "aabbcc", Reason: "BadDeviceToken"},
+		{ID: id, Token: "ddeeff", Reason: "Unregistered"},
+	} {
+		if err = s.StoreBadPushToken(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tokens, err = s.RetrieveBadPushTokens(ctx, []string{id, "nonexistent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(tokens), 1; have != want {
+		t.Fatalf("bad push tokens: have: %d, want: %d", have, want)
+	}
+	token := tokens[id]
+	if token == nil {
+		t.Fatal("missing bad push token")
+	}
+	if have, want := token.Token, "ddeeff"; have != want {
+		t.Errorf("token: have: %q, want: %q", have, want)
+	}
+	if have, want := token.Reason, "Unregistered"; have != want {
+		t.Errorf("reason: have: %q, want: %q", have, want)
+	}
+}
@@ -0,0 +1,194 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/groob/plist"
+)
+
+// EnrollmentInterfaces are the storage interfaces needed for testing
+// enrollment listing.
+type EnrollmentInterfaces interface {
+	storage.CheckinStore
+	storage.EnrollmentLister
+}
+
+// checkin marshals msg and decodes it into an MDM check-in message.
+func checkin(msg interface{}) (interface{}, error) {
+	rawBytes, err := plist.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return mdm.DecodeCheckin(rawBytes)
+}
+
+// enroll fakes an Authenticate (for device channels) and a TokenUpdate
+// check-in message for an enrollment.
+func enroll(t *testing.T, s storage.CheckinStore, ctx context.Context, udid, userID, serial, topic string) *mdm.Request {
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: udid},
+		Context:  ctx,
+	}
+	if userID != "" {
+		r.EnrollID = &mdm.EnrollID{Type: mdm.User, ID: userID, ParentID: udid}
+	} else {
+		msg, err := checkin(&struct {
+			MessageType  string
+			UDID         string
+			Topic        string
+			SerialNumber string
+		}{"Authenticate", udid, topic, serial})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.StoreAuthenticate(r, msg.(*mdm.Authenticate)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := checkin(&struct {
+		MessageType string
+		UDID        string
+		UserID      string `plist:",omitempty"`
+		Topic       string
+		PushMagic   string
+		Token       []byte
+	}{"TokenUpdate", udid, userID, topic, "MAGIC", []byte("TOKEN")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenUpdate, ok := msg.(*mdm.TokenUpdate)
+	if !ok {
+		t.Fatal(errors.New("not a TokenUpdate message"))
+	}
+	if err = s.StoreTokenUpdate(r, tokenUpdate); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// EnrollDevice fakes the check-in messages of a device channel
+// enrollment for udid. Storage backends that enforce foreign keys need
+// enrollments to exist before queueing commands, storing inventory, etc.
+func EnrollDevice(t *testing.T, s storage.CheckinStore, ctx context.Context, udid string) *mdm.Request {
+	return enroll(t, s, ctx, udid, "", "", "com.example.test")
+}
+
+// listIDs lists enrollments and returns their IDs.
+func listIDs(t *testing.T, s EnrollmentInterfaces, ctx context.Context, filter *storage.EnrollmentFilter, cursor string, limit int) (ids []string) {
+	enrollments, err := s.ListEnrollments(ctx, filter, cursor, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range enrollments {
+		ids = append(ids, e.ID)
+	}
+	return
+}
+
+func compareIDs(t *testing.T, want, have []string) {
+	if len(want) != len(have) {
+		t.Fatalf("ids: want: %v, have: %v", want, have)
+	}
+	for i := range want {
+		if want[i] != have[i] {
+			t.Errorf("ids: want: %v, have: %v", want, have)
+			return
+		}
+	}
+}
+
+// TestEnrollmentLister tests listing and filtering enrollments.
+func TestEnrollmentLister(t *testing.T, ctx context.Context, s EnrollmentInterfaces) {
+	const (
+		topic1 = "com.apple.mgmt.External.topic1"
+		topic2 = "com.apple.mgmt.External.topic2"
+		dev1   = "LISTTEST-0001"
+		dev2   = "LISTTEST-0002"
+		user1  = "LISTTEST-0003"
+	)
+
+	start := time.Now().Add(-time.Minute)
+
+	// the store may contain other enrollments so listings are
+	// restricted to the enrollments created here.
+	scoped := func(filter storage.EnrollmentFilter) *storage.EnrollmentFilter {
+		filter.IDs = []string{dev1, dev2, user1}
+		return &filter
+	}
+
+	enroll(t, s, ctx, dev1, "", "SERIAL1", topic1)
+	r := enroll(t, s, ctx, dev2, "", "SERIAL2", topic2)
+	enroll(t, s, ctx, dev1, user1, "", topic1)
+
+	t.Run("all", func(t *testing.T) {
+		enrollments, err := s.ListEnrollments(ctx, scoped(storage.EnrollmentFilter{}), "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := len(enrollments), 3; have != want {
+			t.Fatalf("count: have: %v, want: %v", have, want)
+		}
+		dev := enrollments[0]
+		if dev.ID != dev1 || dev.Type != "Device" || dev.Topic != topic1 || dev.SerialNumber != "SERIAL1" || !dev.Enabled || dev.TokenUpdateTally != 1 || dev.ParentID != "" {
+			t.Errorf("unexpected device enrollment: %+v", dev)
+		}
+		if dev.LastSeenAt.Before(start) {
+			t.Errorf("last seen: %v before %v", dev.LastSeenAt, start)
+		}
+		user := enrollments[2]
+		if user.ID != user1 || user.Type != "User" || user.ParentID != dev1 || user.SerialNumber != "SERIAL1" {
+			t.Errorf("unexpected user enrollment: %+v", user)
+		}
+	})
+
+	t.Run("paging", func(t *testing.T) {
+		compareIDs(t, []string{dev1, dev2}, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{}), "", 2))
+		compareIDs(t, []string{user1}, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{}), dev2, 2))
+		compareIDs(t, nil, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{}), user1, 2))
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		compareIDs(t, []string{dev1, user1}, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{Topics: []string{topic1}}), "", 0))
+		compareIDs(t, []string{user1}, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{Types: []string{"User"}}), "", 0))
+		compareIDs(t, []string{dev2}, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{SerialNumbers: []string{"SERIAL2"}}), "", 0))
+		compareIDs(t, []string{user1}, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{ParentIDs: []string{dev1}}), "", 0))
+		compareIDs(t, []string{dev1, dev2}, listIDs(t, s, ctx, &storage.EnrollmentFilter{IDs: []string{dev1, dev2}}, "", 0))
+		compareIDs(t, []string{dev1, dev2, user1}, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{LastSeenAfter: start}), "", 0))
+		compareIDs(t, nil, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{LastSeenBefore: start}), "", 0))
+		device, user := true, false
+		compareIDs(t, []string{dev1, dev2}, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{DeviceChannel: &device}), "", 0))
+		compareIDs(t, []string{user1}, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{DeviceChannel: &user}), "", 0))
+	})
+
+	t.Run("batches", func(t *testing.T) {
+		var batches [][]string
+		err := storage.ListEnrollmentIDs(ctx, s, scoped(storage.EnrollmentFilter{}), 2, func(ids []string) error {
+			batches = append(batches, ids)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := len(batches), 2; have != want {
+			t.Fatalf("batches: have: %d, want: %d", have, want)
+		}
+		compareIDs(t, []string{dev1, dev2}, batches[0])
+		compareIDs(t, []string{user1}, batches[1])
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		if err := s.Disable(r); err != nil {
+			t.Fatal(err)
+		}
+		disabled := false
+		compareIDs(t, []string{dev2}, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{Enabled: &disabled}), "", 0))
+		enabled := true
+		compareIDs(t, []string{dev1, user1}, listIDs(t, s, ctx, scoped(storage.EnrollmentFilter{Enabled: &enabled}), "", 0))
+	})
+}
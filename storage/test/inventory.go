@@ -0,0 +1,61 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestInventory tests storing and retrieving inventory values for id.
+func TestInventory(t *testing.T, id string, s interface {
+	storage.InventoryStore
+	storage.InventoryRetriever
+}) {
+	ctx := context.Background()
+
+	values, err := s.RetrieveInventory(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) > 0 {
+		t.Fatalf("expected empty inventory, got: %v", values)
+	}
+
+	err = s.StoreInventoryValues(ctx, id, storage.InventoryValues{
+		"os_version":      "12.6",
+		"device_capacity": 500.5,
+		"supervised":      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// merge: update one value and add another
+	err = s.StoreInventoryValues(ctx, id, storage.InventoryValues{
+		"os_version":  "13.0",
+		"fde_enabled": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err = s.RetrieveInventory(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := storage.InventoryValues{
+		"os_version":      "13.0",
+		"device_capacity": 500.5,
+		"supervised":      true,
+		"fde_enabled":     false,
+	}
+	if have, want := len(values), len(want); have != want {
+		t.Errorf("inventory length: have: %d, want: %d", have, want)
+	}
+	for name, wantValue := range want {
+		if have := values[name]; have != wantValue {
+			t.Errorf("%s: have: %v, want: %v", name, have, wantValue)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/push/apnstest"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+type pushCertStorage interface {
+	storage.PushCertStore
+	storage.PushCertLister
+	storage.PushCertDeleter
+}
+
+// TestPushCerts tests storing, listing, and deleting push certificates.
+func TestPushCerts(t *testing.T, s pushCertStorage) {
+	ctx := context.Background()
+	const topic1 = "com.apple.mgmt.External.1b9d3f6a-2c4e-4a8b-9d7f-0e2c4a6b8d1f"
+	const topic2 = "com.apple.mgmt.External.5e7a9c1b-3d5f-4b7a-8c9e-1f3a5c7e9b2d"
+	notAfter := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second).UTC()
+
+	for i, topic := range []string{topic1, topic2} {
+		certPEM, keyPEM := apnstest.NewPushCert(t, topic, int64(0xABC+i), notAfter)
+		if err := s.StorePushCert(ctx, certPEM, keyPEM); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos, err := s.ListPushCerts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]*storage.PushCertInfo)
+	for _, info := range infos {
+		found[info.Topic] = info
+	}
+	info := found[topic1]
+	if info == nil {
+		t.Fatalf("push cert not listed: %s", topic1)
+	}
+	if have, want := info.SerialNumber, "ABC"; have != want {
+		t.Errorf("serial number: have: %q, want: %q", have, want)
+	}
+	if !info.NotAfter.Equal(notAfter) {
+		t.Errorf("not after: have: %s, want: %s", info.NotAfter, notAfter)
+	}
+	if info.Subject == "" || info.StaleToken == "" {
+		t.Errorf("unexpected push cert info: %+v", info)
+	}
+	_, staleToken, err := s.RetrievePushCert(ctx, topic1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := info.StaleToken, staleToken; have != want {
+		t.Errorf("stale token: have: %q, want: %q", have, want)
+	}
+
+	if err = s.DeletePushCert(ctx, topic1); err != nil {
+		t.Fatal(err)
+	}
+	// deleting again is not an error
+	if err = s.DeletePushCert(ctx, topic1); err != nil {
+		t.Fatal(err)
+	}
+	infos, err = s.ListPushCerts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if info.Topic == topic1 {
+			t.Errorf("deleted push cert listed: %s", topic1)
+		}
+	}
+	if _, _, err = s.RetrievePushCert(ctx, topic1); err == nil {
+		t.Error("expected error retrieving deleted push cert")
+	}
+	if len(infos) < 1 {
+		t.Errorf("push cert not listed: %s", topic2)
+	}
+}
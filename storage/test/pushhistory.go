@@ -0,0 +1,68 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+type pushHistoryStorage interface {
+	storage.PushHistoryStore
+	storage.PushHistoryRetriever
+}
+
+// TestPushHistory tests storing, pruning, and retrieving the push
+// history of the enrollment IDs id1 and id2.
+func TestPushHistory(t *testing.T, id1, id2 string, s pushHistoryStorage) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	results, err := s.RetrievePushHistory(ctx, id1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(results), 0; have != want {
+		t.Errorf("push history: have: %d, want: %d", have, want)
+	}
+
+	for i := 0; i < 3; i++ {
+		err = s.StorePushResults(ctx, []*storage.PushResult{
+			{ID: id1, PushedAt: now.Add(time.Duration(i) * time.Second), APNsID: "apns-" + string(rune('a'+i)), Topic: "topic"},
+			{ID: id2, PushedAt: now, Topic: "topic", Error: "push failed", Reason: "BadDeviceToken"},
+		}, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err = s.RetrievePushHistory(ctx, id1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(results), 2; have != want {
+		t.Fatalf("push history: have: %d, want: %d", have, want)
+	}
+	// newest first
+	if have, want := results[0].APNsID, "apns-c"; have != want {
+		t.Errorf("apns id: have: %q, want: %q", have, want)
+	}
+	if have, want := results[1].APNsID, "apns-b"; have != want {
+		t.Errorf("apns id: have: %q, want: %q", have, want)
+	}
+	if !results[0].PushedAt.Equal(now.Add(2 * time.Second)) {
+		t.Errorf("pushed at: have: %s, want: %s", results[0].PushedAt, now.Add(2*time.Second))
+	}
+
+	results, err = s.RetrievePushHistory(ctx, id2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(results), 1; have != want {
+		t.Fatalf("push history: have: %d, want: %d", have, want)
+	}
+	if results[0].ID != id2 || results[0].Topic != "topic" || results[0].Error != "push failed" || results[0].Reason != "BadDeviceToken" {
+		t.Errorf("unexpected push result: %+v", results[0])
+	}
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestPushRetries tests storing, retrieving, and deleting push retries
+// for the enrollment IDs id1 and id2.
+func TestPushRetries(t *testing.T, id1, id2 string, s storage.PushRetryStore) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	for _, retry := range []*storage.PushRetry{
+		{ID: id1, Attempts: 1, NextAt: now.Add(time.Minute), LastError: "test error"},
+		{ID: id2, Attempts: 2, NextAt: now.Add(-time.Minute)},
+	} {
+		if err := s.StorePushRetry(ctx, retry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	retries, err := s.RetrieveDuePushRetries(ctx, now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(retries), 1; have != want {
+		t.Fatalf("due retries: have: %d, want: %d", have, want)
+	}
+	if have, want := retries[0].ID, id2; have != want {
+		t.Errorf("id: have: %q, want: %q", have, want)
+	}
+	if have, want := retries[0].Attempts, 2; have != want {
+		t.Errorf("attempts: have: %d, want: %d", have, want)
+	}
+	if !retries[0].NextAt.Equal(now.Add(-time.Minute)) {
+		t.Errorf("next at: have: %v, want: %v", retries[0].NextAt, now.Add(-time.Minute))
+	}
+
+	// replace the first retry to make it due
+	err = s.StorePushRetry(ctx, &storage.PushRetry{ID: id1, Attempts: 3, NextAt: now.Add(-2 * time.Minute), LastError: "test error 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retries, err = s.RetrieveDuePushRetries(ctx, now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(retries), 2; have != want {
+		t.Fatalf("due retries: have: %d, want: %d", have, want)
+	}
+	if have, want := retries[0].ID, id1; have != want {
+		t.Errorf("id (ordering): have: %q, want: %q", have, want)
+	}
+	if have, want := retries[0].LastError, "test error 2"; have != want {
+		t.Errorf("last error: have: %q, want: %q", have, want)
+	}
+
+	retries, err = s.RetrieveDuePushRetries(ctx, now, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(retries), 1; have != want {
+		t.Errorf("limited due retries: have: %d, want: %d", have, want)
+	}
+
+	if err = s.DeletePushRetries(ctx, []string{id1, id2}); err != nil {
+		t.Fatal(err)
+	}
+	retries, err = s.RetrieveDuePushRetries(ctx, now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retries) > 0 {
+		t.Errorf("expected no due retries, got: %d", len(retries))
+	}
+}
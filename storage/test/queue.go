@@ -3,6 +3,7 @@ package test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
 	"github.com/micromdm/nanomdm/storage"
@@ -42,12 +43,12 @@ func newCommand(cmd string) (*mdm.Command, error) {
 }
 
 // enqueue queues a new command
-func enqueue(t *testing.T, q QueueInterfaces, ctx context.Context, id, cmdStr string) {
+func enqueue(t *testing.T, q QueueInterfaces, ctx context.Context, id, cmdStr string, opts ...storage.EnqueueOption) {
 	cmd, err := newCommand(cmdStr)
 	if err != nil {
 		t.Fatal(err)
 	}
-	res, err := q.EnqueueCommand(ctx, []string{id}, cmd)
+	res, err := q.EnqueueCommand(ctx, []string{id}, cmd, opts...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -152,4 +153,364 @@ func TestQueue(t *testing.T, id string, q QueueInterfaces) {
 		reportRetrieve(t, q, r, "CMD3", "Acknowledged", "")
 		reportRetrieve(t, q, r, "", "Idle", "")
 	})
+
+	t.Run("priority", func(t *testing.T) {
+		reportRetrieve(t, q, r, "", "Idle", "")
+		enqueue(t, q, ctx, id, "PCMD1")
+		enqueue(t, q, ctx, id, "PCMD2", storage.WithPriority(10))
+		enqueue(t, q, ctx, id, "PCMD3", storage.WithPriority(-5))
+		enqueue(t, q, ctx, id, "PCMD4", storage.WithPriority(10))
+		reportRetrieve(t, q, r, "", "Idle", "PCMD2")
+		reportRetrieve(t, q, r, "PCMD2", "Acknowledged", "PCMD4")
+		reportRetrieve(t, q, r, "PCMD4", "Acknowledged", "PCMD1")
+		reportRetrieve(t, q, r, "PCMD1", "Acknowledged", "PCMD3")
+		reportRetrieve(t, q, r, "PCMD3", "Acknowledged", "")
+	})
+}
+
+// TestRetrieveQueue tests retrieving the commands of a queue.
+// Assumes an empty queue for id.
+func TestRetrieveQueue(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.QueueRetriever
+}) {
+	ctx := context.Background()
+
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{
+			Type: mdm.Device,
+			ID:   id,
+		},
+		Context: ctx,
+	}
+
+	enqueue(t, q, ctx, id, "QCMD1")
+	enqueue(t, q, ctx, id, "QCMD2")
+	enqueue(t, q, ctx, id, "QCMD3")
+	reportRetrieve(t, q, r, "", "Idle", "QCMD1")
+	reportRetrieve(t, q, r, "QCMD1", "Acknowledged", "QCMD2")
+	report(t, q, r, "QCMD2", "NotNow")
+
+	items, err := q.RetrieveQueue(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"QCMD1": "Acknowledged",
+		"QCMD2": "NotNow",
+		"QCMD3": "",
+	}
+	if have, want := len(items), len(want); have != want {
+		t.Fatalf("count: have: %v, want: %v", have, want)
+	}
+	for _, item := range items {
+		status, ok := want[item.CommandUUID]
+		if !ok {
+			t.Errorf("unexpected command: %s", item.CommandUUID)
+			continue
+		}
+		if item.Status != status {
+			t.Errorf("%s status: have: %q, want: %q", item.CommandUUID, item.Status, status)
+		}
+		if item.RequestType != item.CommandUUID {
+			t.Errorf("%s request type: have: %q", item.CommandUUID, item.RequestType)
+		}
+		if !item.Active {
+			t.Errorf("%s: not active", item.CommandUUID)
+		}
+		if (status == "") != (item.ResultUpdatedAt == nil) {
+			t.Errorf("%s: unexpected result updated at: %v", item.CommandUUID, item.ResultUpdatedAt)
+		}
+	}
+}
+
+// TestRetrieveCommandResults tests retrieving the results of a command.
+func TestRetrieveCommandResults(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.CommandResultsRetriever
+}) {
+	ctx := context.Background()
+
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{
+			Type: mdm.Device,
+			ID:   id,
+		},
+		Context: ctx,
+	}
+
+	check := func(t *testing.T, status string, tally int) {
+		results, err := q.RetrieveCommandResults(ctx, "RCMD1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("count: have: %v, want: 1", len(results))
+		}
+		result := results[0]
+		if result.ID != id {
+			t.Errorf("id: have: %q, want: %q", result.ID, id)
+		}
+		if result.Status != status {
+			t.Errorf("status: have: %q, want: %q", result.Status, status)
+		}
+		if result.NotNowTally != tally {
+			t.Errorf("not now tally: have: %v, want: %v", result.NotNowTally, tally)
+		}
+		if (status == "") != (len(result.Result) == 0) {
+			t.Errorf("unexpected result: %q", string(result.Result))
+		}
+	}
+
+	enqueue(t, q, ctx, id, "RCMD1")
+	check(t, "", 0)
+	reportRetrieve(t, q, r, "", "Idle", "RCMD1")
+	reportRetrieve(t, q, r, "RCMD1", "NotNow", "")
+	check(t, "NotNow", 1)
+	reportRetrieve(t, q, r, "", "Idle", "RCMD1")
+	reportRetrieve(t, q, r, "RCMD1", "Acknowledged", "")
+	check(t, "Acknowledged", 1)
+
+	results, err := q.RetrieveCommandResults(ctx, "RCMD-INVALID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("count: have: %v, want: 0", len(results))
+	}
+}
+
+// TestDequeueCommand tests removing queued commands.
+// Assumes an empty queue for id.
+func TestDequeueCommand(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.CommandDequeuer
+}) {
+	ctx := context.Background()
+
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{
+			Type: mdm.Device,
+			ID:   id,
+		},
+		Context: ctx,
+	}
+
+	dequeue := func(t *testing.T, ids []string, uuid string, want int) {
+		ct, err := q.DequeueCommand(ctx, ids, uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct != want {
+			t.Errorf("dequeue %s count: have: %v, want: %v", uuid, ct, want)
+		}
+	}
+
+	enqueue(t, q, ctx, id, "DCMD1")
+	enqueue(t, q, ctx, id, "DCMD2")
+	reportRetrieve(t, q, r, "", "Idle", "DCMD1")
+	reportRetrieve(t, q, r, "DCMD1", "Acknowledged", "DCMD2")
+	dequeue(t, []string{id}, "DCMD1", 0)
+	dequeue(t, []string{id}, "DCMD2", 1)
+	reportRetrieve(t, q, r, "", "Idle", "")
+
+	enqueue(t, q, ctx, id, "DCMD3")
+	reportRetrieve(t, q, r, "", "Idle", "DCMD3")
+	reportRetrieve(t, q, r, "DCMD3", "NotNow", "")
+	dequeue(t, nil, "DCMD3", 1)
+	reportRetrieve(t, q, r, "", "Idle", "")
+	dequeue(t, nil, "DCMD3", 0)
+}
+
+func containsID(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// TestScheduledCommands tests retrieving commands scheduled with a
+// not-before time. Assumes an empty queue for id.
+func TestScheduledCommands(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.ScheduledCommandRetriever
+}) {
+	ctx := context.Background()
+
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{
+			Type: mdm.Device,
+			ID:   id,
+		},
+		Context: ctx,
+	}
+
+	now := time.Now().Truncate(time.Second)
+
+	enqueue(t, q, ctx, id, "SCMD1", storage.WithNotBefore(now.Add(time.Hour)))
+	enqueue(t, q, ctx, id, "SCMD2", storage.WithNotBefore(now.Add(-time.Minute)))
+	reportRetrieve(t, q, r, "", "Idle", "SCMD2")
+	reportRetrieve(t, q, r, "SCMD2", "Acknowledged", "")
+
+	ids, err := q.RetrieveScheduledIDs(ctx, now, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsID(ids, id) {
+		t.Errorf("scheduled ids: have: %v, want: %s", ids, id)
+	}
+
+	ids, err = q.RetrieveScheduledIDs(ctx, now.Add(-2*time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsID(ids, id) {
+		t.Errorf("scheduled ids: have: %v, want: without %s", ids, id)
+	}
+}
+
+// TestPendingCommands tests retrieving enrollments with pending
+// commands. Assumes an empty queue for id.
+func TestPendingCommands(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.PendingCommandRetriever
+}) {
+	ctx := context.Background()
+
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{
+			Type: mdm.Device,
+			ID:   id,
+		},
+		Context: ctx,
+	}
+
+	pending := func(before time.Time) bool {
+		ids, err := q.RetrievePendingIDs(ctx, before)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return containsID(ids, id)
+	}
+
+	enqueue(t, q, ctx, id, "PENDCMD1")
+	enqueue(t, q, ctx, id, "PENDCMD2", storage.WithNotBefore(time.Now().Add(time.Hour)))
+	if !pending(time.Now().Add(time.Minute)) {
+		t.Error("expected pending commands")
+	}
+	if pending(time.Now().Add(-time.Hour)) {
+		t.Error("expected no commands pending before an hour ago")
+	}
+
+	// only a scheduled command remains
+	reportRetrieve(t, q, r, "", "Idle", "PENDCMD1")
+	reportRetrieve(t, q, r, "PENDCMD1", "Acknowledged", "")
+	if pending(time.Now().Add(time.Minute)) {
+		t.Error("expected no pending commands")
+	}
+}
+
+func TestExpireCommands(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.CommandExpirer
+	storage.CommandResultsRetriever
+}) {
+	ctx := context.Background()
+
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{
+			Type: mdm.Device,
+			ID:   id,
+		},
+		Context: ctx,
+	}
+
+	now := time.Now().Truncate(time.Second)
+
+	enqueue(t, q, ctx, id, "ECMD1", storage.WithExpires(now.Add(-time.Minute)))
+	enqueue(t, q, ctx, id, "ECMD2", storage.WithExpires(now.Add(time.Hour)))
+	reportRetrieve(t, q, r, "", "Idle", "ECMD2")
+	reportRetrieve(t, q, r, "ECMD2", "Acknowledged", "")
+
+	expireIDs := func() (uuids []string) {
+		expired, err := q.ExpireCommands(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cmd := range expired {
+			if cmd.ID == id {
+				uuids = append(uuids, cmd.CommandUUID)
+			}
+		}
+		return
+	}
+
+	if have := expireIDs(); len(have) != 1 || have[0] != "ECMD1" {
+		t.Errorf("expired: have: %v, want: [ECMD1]", have)
+	}
+	if have := expireIDs(); len(have) != 0 {
+		t.Errorf("expired: have: %v, want: none", have)
+	}
+
+	results, err := q.RetrieveCommandResults(ctx, "ECMD1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != storage.StatusExpired {
+		t.Errorf("results: have: %v, want: status %s", results, storage.StatusExpired)
+	}
+}
+
+func TestDeadLetters(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.DeadLetterStore
+	storage.DeadLetterRetriever
+}) {
+	ctx := context.Background()
+
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{
+			Type: mdm.Device,
+			ID:   id,
+		},
+		Context: ctx,
+	}
+
+	enqueue(t, q, ctx, id, "DLCMD1")
+	enqueue(t, q, ctx, id, "DLCMD2")
+	reportRetrieve(t, q, r, "", "Idle", "DLCMD1")
+	report(t, q, r, "DLCMD1", "NotNow")
+	report(t, q, r, "DLCMD1", "NotNow")
+
+	tally, first, err := q.RetrieveNotNow(ctx, id, "DLCMD1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tally != 2 {
+		t.Errorf("NotNow tally: have: %v, want: %v", tally, 2)
+	}
+	if first.IsZero() {
+		t.Error("first NotNow: have: zero, want: non-zero")
+	}
+
+	if err = q.DeadLetterCommand(ctx, id, "DLCMD1"); err != nil {
+		t.Fatal(err)
+	}
+	// dead-lettered commands are no longer delivered
+	reportRetrieve(t, q, r, "", "Idle", "DLCMD2")
+	reportRetrieve(t, q, r, "DLCMD2", "Acknowledged", "")
+
+	deadLetters, err := q.RetrieveDeadLetters(ctx, []string{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 {
+		t.Fatalf("dead letters: have: %v, want: 1", len(deadLetters))
+	}
+	dl := deadLetters[0]
+	if dl.ID != id || dl.CommandUUID != "DLCMD1" || dl.RequestType != "DLCMD1" || dl.NotNowTally != 2 {
+		t.Errorf("unexpected dead letter: %+v", dl)
+	}
 }